@@ -1,28 +1,162 @@
 package broadcast
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Broadcaster interface {
 	// Register a new channel to receive broadcasts
 	Register(chan<- interface{})
 	// Unregister a channel so that it no longer receives broadcasts.
 	Unregister(chan<- interface{})
-	// Shut this broadcaster down.
+	// Shut this broadcaster down, the channels still registered are closed once the submitted objects are sent.
 	Close() error
 	// Submit a new object to all subscribers
 	Submit(interface{}) bool
 }
 
+// SlowConsumerPolicy decides what happens when a subscriber is not ready to receive a message
+type SlowConsumerPolicy int
+
+const (
+	// Block waits until the subscriber reads the message, holding back every other subscriber
+	Block SlowConsumerPolicy = iota
+	// Drop skips the message for the subscribers that are not ready to receive it
+	Drop
+)
+
+func (p SlowConsumerPolicy) String() string {
+	switch p {
+	case Drop:
+		return "drop"
+	default:
+		return "block"
+	}
+}
+
+/*
+ParseSlowConsumerPolicy converts a policy name ("block" or "drop") into a SlowConsumerPolicy.
+An empty string returns the default Block policy.
+*/
+func ParseSlowConsumerPolicy(s string) (SlowConsumerPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "block":
+		return Block, nil
+	case "drop":
+		return Drop, nil
+	default:
+		return Block, fmt.Errorf("unknown slow consumer policy: %q", s)
+	}
+}
+
+type Options struct {
+	// Size of the input buffer, messages submitted while it is full are rejected
+	BufferSize int
+	/*
+		Number of past messages replayed to every new subscriber, whatever the SlowConsumerPolicy.
+		The replay blocks until the subscriber reads, so its channel should hold HistoryDepth messages.
+	*/
+	HistoryDepth int
+	// Behaviour towards subscribers that are not ready to receive
+	SlowConsumerPolicy SlowConsumerPolicy
+	// Decides which messages are kept in the history, all of them when nil
+	Keep func(interface{}) bool
+	/*
+		Called with every message of the history for each message broadcast, it returns the
+		entry to keep in place of the old one, or false to remove it. nil never changes the history.
+	*/
+	Revise func(entry, m interface{}) (interface{}, bool)
+	// Told of every submit and every message dropped, nil to count nothing
+	Observer Observer
+}
+
+// Observer counts what goes through a broadcaster, its methods must return quickly
+type Observer interface {
+	// A message was submitted, accepted is false when the input buffer was full
+	Submitted(accepted bool)
+	// A message was skipped for a subscriber not ready to receive it, with the Drop policy
+	Dropped()
+	// A message was sent to its subscribers, the fan-out began at start
+	Delivered(m interface{}, subscribers int, start time.Time)
+}
+
 type broadcaster struct {
 	input chan interface{}
 	reg   chan chan<- interface{}
 	unreg chan chan<- interface{}
 
 	outputs map[chan<- interface{}]bool
+
+	options Options
+	history []interface{}
+}
+
+func (b *broadcaster) send(ch chan<- interface{}, m interface{}) {
+	if b.options.SlowConsumerPolicy == Drop {
+		select {
+		case ch <- m:
+		default:
+			if b.options.Observer != nil {
+				b.options.Observer.Dropped()
+			}
+		}
+		return
+	}
+	ch <- m
 }
 
 func (b *broadcaster) broadcast(m interface{}) {
+	start := time.Now()
 	//On diffuse le msg a tout les listeners(tout les viewers du chat)
 	for ch := range b.outputs {
-		ch <- m
+		b.send(ch, m)
+	}
+	if b.options.Observer != nil {
+		b.options.Observer.Delivered(m, len(b.outputs), start)
+	}
+	b.revise(m)
+	b.remember(m)
+}
+
+// Un message peut modifier ou retirer ceux déjà gardés, comme une édition ou une suppression
+func (b *broadcaster) revise(m interface{}) {
+	if b.options.Revise == nil {
+		return
+	}
+	history := b.history[:0]
+	for _, entry := range b.history {
+		if entry, ok := b.options.Revise(entry, m); ok {
+			history = append(history, entry)
+		}
+	}
+	b.history = history
+}
+
+// On garde les derniers messages pour les rejouer aux nouveaux listeners
+func (b *broadcaster) remember(m interface{}) {
+	if b.options.HistoryDepth <= 0 {
+		return
+	}
+	if b.options.Keep != nil && !b.options.Keep(m) {
+		return
+	}
+	b.history = append(b.history, m)
+	if len(b.history) > b.options.HistoryDepth {
+		b.history = b.history[len(b.history)-b.options.HistoryDepth:]
+	}
+}
+
+// Les messages soumis avant Close() sont diffusés avant l'arrêt
+func (b *broadcaster) flush() {
+	for {
+		select {
+		case m := <-b.input:
+			b.broadcast(m)
+		default:
+			return
+		}
 	}
 }
 
@@ -38,7 +172,17 @@ func (b *broadcaster) run() {
 		case ch, ok := <-b.reg:
 			if ok {
 				b.outputs[ch] = true
+				// L'historique est toujours envoyé en bloquant : le lecteur n'a pas encore
+				// commencé à lire, la politique Drop le perdrait presque entièrement
+				for _, m := range b.history {
+					ch <- m
+				}
 			} else {
+				b.flush()
+				// Un abonné qui a manqué le dernier message sait quand même que plus rien n'arrivera
+				for ch := range b.outputs {
+					close(ch)
+				}
 				return
 			}
 		case ch := <-b.unreg:
@@ -69,21 +213,35 @@ func (b *broadcaster) Submit(m interface{}) bool {
 	if b == nil {
 		return false
 	}
+	accepted := true
 	select {
 	case b.input <- m:
-		return true
 	default:
-		return false
+		accepted = false
+	}
+	if b.options.Observer != nil {
+		b.options.Observer.Submitted(accepted)
 	}
+	return accepted
 }
 
 func NewBroadcaster(buflen int) Broadcaster {
+	return NewBroadcasterWithOptions(Options{
+		BufferSize: buflen,
+	})
+}
+
+/*
+NewBroadcasterWithOptions creates a broadcaster tuned by the given Options and starts its goroutine.
+*/
+func NewBroadcasterWithOptions(options Options) Broadcaster {
 	//Initialisation des channels avec make
 	b := &broadcaster{
-		input:   make(chan interface{}, buflen),
+		input:   make(chan interface{}, options.BufferSize),
 		reg:     make(chan chan<- interface{}),
 		unreg:   make(chan chan<- interface{}),
 		outputs: make(map[chan<- interface{}]bool),
+		options: options,
 	}
 
 	go b.run()
@@ -0,0 +1,267 @@
+package broadcast
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter is an Observer remembering what the broadcaster did
+type counter struct {
+	mu        sync.Mutex
+	accepted  int
+	rejected  int
+	dropped   int
+	delivered int
+}
+
+func (c *counter) Submitted(accepted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if accepted {
+		c.accepted++
+	} else {
+		c.rejected++
+	}
+}
+
+func (c *counter) Dropped() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped++
+}
+
+func (c *counter) Delivered(m interface{}, subscribers int, start time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.delivered++
+}
+
+// waitFor polls cond until it holds or a second has passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func receive(t *testing.T, ch chan interface{}) interface{} {
+	t.Helper()
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a message")
+		return nil
+	}
+}
+
+func TestParseSlowConsumerPolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SlowConsumerPolicy
+		wantErr bool
+	}{
+		{"", Block, false},
+		{"block", Block, false},
+		{" Drop ", Drop, false},
+		{"DROP", Drop, false},
+		{"skip", Block, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSlowConsumerPolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseSlowConsumerPolicy(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSubmitReachesEverySubscriber(t *testing.T) {
+	b := NewBroadcaster(1)
+	defer b.Close()
+
+	first, second := make(chan interface{}, 1), make(chan interface{}, 1)
+	b.Register(first)
+	b.Register(second)
+
+	if !b.Submit("hello") {
+		t.Fatal("Submit refused a message with an empty buffer")
+	}
+	if m := receive(t, first); m != "hello" {
+		t.Errorf("first subscriber got %v", m)
+	}
+	if m := receive(t, second); m != "hello" {
+		t.Errorf("second subscriber got %v", m)
+	}
+}
+
+func TestSubmitRejectedWhenBufferFull(t *testing.T) {
+	observer := &counter{}
+	b := NewBroadcasterWithOptions(Options{BufferSize: 1, Observer: observer})
+
+	// Le subscriber ne lit jamais : le broadcaster bloque sur lui et le buffer se remplit
+	b.Register(make(chan interface{}))
+
+	rejected := false
+	for i := 0; i < 10 && !rejected; i++ {
+		rejected = !b.Submit(i)
+	}
+	if !rejected {
+		t.Fatal("Submit never refused a message while the buffer was full")
+	}
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if observer.rejected != 1 {
+		t.Errorf("observer counted %d rejected submits, want 1", observer.rejected)
+	}
+}
+
+func TestDropPolicySkipsSlowSubscribers(t *testing.T) {
+	observer := &counter{}
+	b := NewBroadcasterWithOptions(Options{BufferSize: 4, SlowConsumerPolicy: Drop, Observer: observer})
+	defer b.Close()
+
+	slow := make(chan interface{})
+	fast := make(chan interface{}, 4)
+	b.Register(slow)
+	b.Register(fast)
+
+	b.Submit("hello")
+	if m := receive(t, fast); m != "hello" {
+		t.Errorf("fast subscriber got %v", m)
+	}
+	waitFor(t, "the dropped message", func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return observer.dropped == 1
+	})
+}
+
+func TestHistoryReplayedToNewSubscribers(t *testing.T) {
+	for _, policy := range []SlowConsumerPolicy{Block, Drop} {
+		t.Run(policy.String(), func(t *testing.T) {
+			observer := &counter{}
+			b := NewBroadcasterWithOptions(Options{
+				BufferSize:         10,
+				HistoryDepth:       2,
+				SlowConsumerPolicy: policy,
+				Observer:           observer,
+				Keep:               func(m interface{}) bool { return m != "ephemeral" },
+			})
+			defer b.Close()
+
+			for _, m := range []interface{}{"one", "two", "ephemeral", "three"} {
+				b.Submit(m)
+			}
+			waitFor(t, "the messages to be broadcast", func() bool {
+				observer.mu.Lock()
+				defer observer.mu.Unlock()
+				return observer.delivered == 4
+			})
+
+			// Channel sans buffer, lue après l'enregistrement : l'historique ne doit pas être perdu
+			late := make(chan interface{})
+			b.Register(late)
+			time.Sleep(10 * time.Millisecond)
+			for _, want := range []string{"two", "three"} {
+				if m := receive(t, late); m != want {
+					t.Errorf("replayed %v, want %v", m, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHistoryRevised(t *testing.T) {
+	observer := &counter{}
+	b := NewBroadcasterWithOptions(Options{
+		BufferSize:   10,
+		HistoryDepth: 3,
+		Observer:     observer,
+		Keep:         func(m interface{}) bool { return !strings.Contains(m.(string), ":") },
+		Revise: func(entry, m interface{}) (interface{}, bool) {
+			switch m {
+			case "delete:" + entry.(string):
+				return nil, false
+			case "edit:" + entry.(string):
+				return entry.(string) + " (edited)", true
+			}
+			return entry, true
+		},
+	})
+	defer b.Close()
+
+	for _, m := range []interface{}{"one", "two", "three", "delete:two", "edit:three"} {
+		b.Submit(m)
+	}
+	waitFor(t, "the messages to be broadcast", func() bool {
+		observer.mu.Lock()
+		defer observer.mu.Unlock()
+		return observer.delivered == 5
+	})
+
+	late := make(chan interface{}, 3)
+	b.Register(late)
+	for _, want := range []string{"one", "three (edited)"} {
+		if m := receive(t, late); m != want {
+			t.Errorf("replayed %v, want %v", m, want)
+		}
+	}
+	select {
+	case m := <-late:
+		t.Errorf("replayed %v, a deleted message", m)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestCloseFlushesSubmittedMessages(t *testing.T) {
+	b := NewBroadcasterWithOptions(Options{BufferSize: 4})
+	ch := make(chan interface{}, 4)
+	b.Register(ch)
+
+	b.Submit("one")
+	b.Submit("two")
+	b.Close()
+
+	for _, want := range []string{"one", "two"} {
+		if m := receive(t, ch); m != want {
+			t.Errorf("got %v, want %v", m, want)
+		}
+	}
+}
+
+func TestCloseClosesRegisteredChannels(t *testing.T) {
+	b := NewBroadcasterWithOptions(Options{BufferSize: 4, SlowConsumerPolicy: Drop})
+	full := make(chan interface{}, 1)
+	left := make(chan interface{}, 1)
+	b.Register(full)
+	b.Register(left)
+	b.Unregister(left)
+
+	// L'abonné plein peut perdre le message, pas la fermeture
+	full <- "zero"
+	b.Submit("one")
+	b.Close()
+
+	deadline := time.After(time.Second)
+	for closed := false; !closed; {
+		select {
+		case _, ok := <-full:
+			closed = !ok
+		case <-deadline:
+			t.Fatal("registered channel not closed")
+		}
+	}
+	select {
+	case _, ok := <-left:
+		if !ok {
+			t.Error("unregistered channel closed by the broadcaster")
+		}
+	default:
+	}
+}
@@ -2,6 +2,9 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,17 +17,195 @@ type Config struct {
 	DB_NAME string
 
 	JWT_SECRET string
+	// Ids of the global administrators, an email could be taken by anyone
+	ADMIN_USER_IDS []uint
+
+	LOG_FORMAT string
+	LOG_LEVEL  string
+
+	TRACING_EXPORTER      string
+	TRACING_OTLP_ENDPOINT string
+	TRACING_SERVICE_NAME  string
+	TRACING_SAMPLE_RATIO  float64
+
+	SHUTDOWN_DRAIN   time.Duration
+	SHUTDOWN_TIMEOUT time.Duration
+
+	MANAGER_CONTROL_BUFFER_SIZE int
+	ROOM_BUFFER_SIZE            int
+	ROOM_MAX_SUBSCRIBERS        int
+	ROOM_MAX_MESSAGE_SIZE       int
+	ROOM_HISTORY_DEPTH          int
+	ROOM_SLOW_CONSUMER_POLICY   string
+	// nil when unset, so that 0 can disable the collection of idle rooms
+	ROOM_IDLE_TTL    *time.Duration
+	ROOM_GC_INTERVAL time.Duration
+	TYPING_TTL       time.Duration
+	TYPING_THROTTLE  time.Duration
+
+	ATTACHMENT_STORE         string
+	ATTACHMENT_DIR           string
+	ATTACHMENT_MAX_SIZE      int
+	ATTACHMENT_ALLOWED_TYPES []string
+	S3_ENDPOINT              string
+	S3_REGION                string
+	S3_BUCKET                string
+	S3_ACCESS_KEY            string
+	S3_SECRET_KEY            string
+
+	UNFURL_WORKERS   int
+	UNFURL_TIMEOUT   time.Duration
+	UNFURL_MAX_SIZE  int
+	UNFURL_CACHE_TTL time.Duration
+
+	TRUSTED_PROXIES        []string
+	RATE_LIMIT_STORE       string
+	RATE_LIMIT_MESSAGES    string
+	RATE_LIMIT_MESSAGES_IP string
+	RATE_LIMIT_UPLOADS     string
+	RATE_LIMIT_UPLOADS_IP  string
+	RATE_LIMIT_ROOMS       []string
+
+	FILTER_MAX_LENGTH       int
+	FILTER_DUPLICATE_WINDOW time.Duration
+	FILTER_REGEXP_FILE      string
+	FILTER_REGEXP_ACTION    string
+	FILTER_PROFANITY_WORDS  []string
+	FILTER_PROFANITY_ACTION string
+	FILTER_LINK_ALLOWLIST   []string
+	FILTER_LINK_ACTION      string
 }
 
 func InitConfig() *Config {
 	godotenv.Load()
 
 	return &Config{
-		DB_HOST:    os.Getenv("DB_HOST"),
-		DB_USER:    os.Getenv("DB_USER"),
-		DB_PASS:    os.Getenv("DB_PASS"),
-		DB_PORT:    os.Getenv("DB_PORT"),
-		DB_NAME:    os.Getenv("DB_NAME"),
-		JWT_SECRET: os.Getenv("JWT_SECRET"),
+		DB_HOST:        os.Getenv("DB_HOST"),
+		DB_USER:        os.Getenv("DB_USER"),
+		DB_PASS:        os.Getenv("DB_PASS"),
+		DB_PORT:        os.Getenv("DB_PORT"),
+		DB_NAME:        os.Getenv("DB_NAME"),
+		JWT_SECRET:     os.Getenv("JWT_SECRET"),
+		ADMIN_USER_IDS: getEnvIdList("ADMIN_USER_IDS"),
+
+		LOG_FORMAT: os.Getenv("LOG_FORMAT"),
+		LOG_LEVEL:  os.Getenv("LOG_LEVEL"),
+
+		TRACING_EXPORTER:      os.Getenv("TRACING_EXPORTER"),
+		TRACING_OTLP_ENDPOINT: os.Getenv("TRACING_OTLP_ENDPOINT"),
+		TRACING_SERVICE_NAME:  os.Getenv("TRACING_SERVICE_NAME"),
+		TRACING_SAMPLE_RATIO:  getEnvFloat("TRACING_SAMPLE_RATIO"),
+
+		SHUTDOWN_DRAIN:   getEnvDuration("SHUTDOWN_DRAIN"),
+		SHUTDOWN_TIMEOUT: getEnvDuration("SHUTDOWN_TIMEOUT"),
+
+		MANAGER_CONTROL_BUFFER_SIZE: getEnvInt("MANAGER_CONTROL_BUFFER_SIZE"),
+		ROOM_BUFFER_SIZE:            getEnvInt("ROOM_BUFFER_SIZE"),
+		ROOM_MAX_SUBSCRIBERS:        getEnvInt("ROOM_MAX_SUBSCRIBERS"),
+		ROOM_MAX_MESSAGE_SIZE:       getEnvInt("ROOM_MAX_MESSAGE_SIZE"),
+		ROOM_HISTORY_DEPTH:          getEnvInt("ROOM_HISTORY_DEPTH"),
+		ROOM_SLOW_CONSUMER_POLICY:   os.Getenv("ROOM_SLOW_CONSUMER_POLICY"),
+		ROOM_IDLE_TTL:               getEnvOptionalDuration("ROOM_IDLE_TTL"),
+		ROOM_GC_INTERVAL:            getEnvDuration("ROOM_GC_INTERVAL"),
+		TYPING_TTL:                  getEnvDuration("TYPING_TTL"),
+		TYPING_THROTTLE:             getEnvDuration("TYPING_THROTTLE"),
+
+		ATTACHMENT_STORE:         os.Getenv("ATTACHMENT_STORE"),
+		ATTACHMENT_DIR:           os.Getenv("ATTACHMENT_DIR"),
+		ATTACHMENT_MAX_SIZE:      getEnvInt("ATTACHMENT_MAX_SIZE"),
+		ATTACHMENT_ALLOWED_TYPES: getEnvList("ATTACHMENT_ALLOWED_TYPES"),
+		S3_ENDPOINT:              os.Getenv("S3_ENDPOINT"),
+		S3_REGION:                os.Getenv("S3_REGION"),
+		S3_BUCKET:                os.Getenv("S3_BUCKET"),
+		S3_ACCESS_KEY:            os.Getenv("S3_ACCESS_KEY"),
+		S3_SECRET_KEY:            os.Getenv("S3_SECRET_KEY"),
+
+		UNFURL_WORKERS:   getEnvInt("UNFURL_WORKERS"),
+		UNFURL_TIMEOUT:   getEnvDuration("UNFURL_TIMEOUT"),
+		UNFURL_MAX_SIZE:  getEnvInt("UNFURL_MAX_SIZE"),
+		UNFURL_CACHE_TTL: getEnvDuration("UNFURL_CACHE_TTL"),
+
+		TRUSTED_PROXIES:        getEnvList("TRUSTED_PROXIES"),
+		RATE_LIMIT_STORE:       os.Getenv("RATE_LIMIT_STORE"),
+		RATE_LIMIT_MESSAGES:    os.Getenv("RATE_LIMIT_MESSAGES"),
+		RATE_LIMIT_MESSAGES_IP: os.Getenv("RATE_LIMIT_MESSAGES_IP"),
+		RATE_LIMIT_UPLOADS:     os.Getenv("RATE_LIMIT_UPLOADS"),
+		RATE_LIMIT_UPLOADS_IP:  os.Getenv("RATE_LIMIT_UPLOADS_IP"),
+		RATE_LIMIT_ROOMS:       getEnvList("RATE_LIMIT_ROOMS"),
+
+		FILTER_MAX_LENGTH:       getEnvInt("FILTER_MAX_LENGTH"),
+		FILTER_DUPLICATE_WINDOW: getEnvDuration("FILTER_DUPLICATE_WINDOW"),
+		FILTER_REGEXP_FILE:      os.Getenv("FILTER_REGEXP_FILE"),
+		FILTER_REGEXP_ACTION:    os.Getenv("FILTER_REGEXP_ACTION"),
+		FILTER_PROFANITY_WORDS:  getEnvList("FILTER_PROFANITY_WORDS"),
+		FILTER_PROFANITY_ACTION: os.Getenv("FILTER_PROFANITY_ACTION"),
+		FILTER_LINK_ALLOWLIST:   getEnvList("FILTER_LINK_ALLOWLIST"),
+		FILTER_LINK_ACTION:      os.Getenv("FILTER_LINK_ACTION"),
+	}
+}
+
+// getEnvInt reads an integer variable, an unset or invalid value gives 0
+func getEnvInt(key string) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// getEnvFloat reads a decimal variable, an unset or invalid value gives 0
+func getEnvFloat(key string) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// getEnvDuration reads a duration variable such as "10m", an unset or invalid value gives 0
+func getEnvDuration(key string) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return 0
+	}
+
+	return value
+}
+
+// getEnvOptionalDuration reads a duration variable, an unset or invalid value gives nil
+func getEnvOptionalDuration(key string) *time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return nil
+	}
+
+	return &value
+}
+
+// getEnvList reads a comma separated variable, an unset variable gives an empty list
+func getEnvList(key string) []string {
+	values := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
 	}
+
+	return values
+}
+
+// getEnvIdList reads a comma separated list of ids, the values which are not positive integers are left out
+func getEnvIdList(key string) []uint {
+	ids := []uint{}
+	for _, value := range getEnvList(key) {
+		id, err := strconv.ParseUint(value, 10, 0)
+		if err != nil || id == 0 {
+			continue
+		}
+		ids = append(ids, uint(id))
+	}
+
+	return ids
 }
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetEnvOptionalDuration(t *testing.T) {
+	tests := []struct {
+		value string
+		want  *time.Duration
+	}{
+		{"", nil},
+		{"soon", nil},
+		{"0", durationOf(0)},
+		{"90s", durationOf(90 * time.Second)},
+		{"-1m", durationOf(-time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Setenv("TEST_DURATION", tt.value)
+		got := getEnvOptionalDuration("TEST_DURATION")
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("%q gives %v, want nil", tt.value, *got)
+		case tt.want != nil && (got == nil || *got != *tt.want):
+			t.Errorf("%q gives %v, want %v", tt.value, got, *tt.want)
+		}
+	}
+}
+
+func durationOf(d time.Duration) *time.Duration {
+	return &d
+}
+
+func TestGetEnvIdList(t *testing.T) {
+	t.Setenv("TEST_IDS", " 1, 12,root@example.com,0,-3,,7 ")
+	if got := getEnvIdList("TEST_IDS"); !reflect.DeepEqual(got, []uint{1, 12, 7}) {
+		t.Errorf("got %v, want [1 12 7]", got)
+	}
+	t.Setenv("TEST_IDS", "")
+	if got := getEnvIdList("TEST_IDS"); len(got) != 0 {
+		t.Errorf("unset list gives %v", got)
+	}
+}
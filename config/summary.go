@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// secretMarkers are the parts of the names of the variables holding credentials
+var secretMarkers = []string{"PASS", "SECRET", "KEY", "TOKEN"}
+
+// Redacted replaces the value of a credential in the summary
+const Redacted = "[REDACTED]"
+
+/*
+Summary returns the configuration by variable name, to be shown to the administrators.
+The credentials are redacted, only whether they are set can be told.
+
+Returns:
+  - (map[string]interface{}): the value of every variable
+*/
+func (c *Config) Summary() map[string]interface{} {
+	summary := map[string]interface{}{}
+	value := reflect.ValueOf(*c)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i)
+		// les valeurs optionnelles sont montrées telles quelles, ou nil
+		if field.Kind() == reflect.Pointer && !field.IsNil() {
+			field = field.Elem()
+		}
+		if !isSecret(name) {
+			summary[name] = field.Interface()
+			// les durées en nanosecondes sont illisibles
+			if duration, ok := summary[name].(time.Duration); ok {
+				summary[name] = duration.String()
+			}
+			continue
+		}
+
+		// une valeur vide reste visible pour repérer un secret manquant
+		summary[name] = ""
+		if !field.IsZero() {
+			summary[name] = Redacted
+		}
+	}
+
+	return summary
+}
+
+func isSecret(name string) bool {
+	for _, marker := range secretMarkers {
+		if strings.Contains(name, marker) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummaryRedactsCredentials(t *testing.T) {
+	idle := 5 * time.Minute
+	conf := &Config{
+		DB_HOST:        "db",
+		DB_PASS:        "hunter2",
+		JWT_SECRET:     "jwt",
+		S3_ACCESS_KEY:  "",
+		ROOM_IDLE_TTL:  &idle,
+		TYPING_TTL:     3 * time.Second,
+		ADMIN_USER_IDS: []uint{1},
+	}
+	summary := conf.Summary()
+
+	for name, want := range map[string]interface{}{
+		"DB_HOST":       "db",
+		"DB_PASS":       Redacted,
+		"JWT_SECRET":    Redacted,
+		"S3_ACCESS_KEY": "",
+		"S3_SECRET_KEY": "",
+		"ROOM_IDLE_TTL": "5m0s",
+		"TYPING_TTL":    "3s",
+	} {
+		if summary[name] != want {
+			t.Errorf("%s shown as %v, want %v", name, summary[name], want)
+		}
+	}
+	if ids, ok := summary["ADMIN_USER_IDS"].([]uint); !ok || len(ids) != 1 {
+		t.Errorf("ADMIN_USER_IDS shown as %v", summary["ADMIN_USER_IDS"])
+	}
+	if ttl, ok := (&Config{}).Summary()["ROOM_IDLE_TTL"].(*time.Duration); !ok || ttl != nil {
+		t.Errorf("unset optional value shown as %v, want nil", ttl)
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/riri95500/go-chat
 
-go 1.19
+go 1.21
 
 require (
 	github.com/MohammadBnei/go-html-adapter v0.0.0-20221129000024-31209e2035d2
@@ -9,6 +9,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/kjk/betterguid v0.0.0-20170621091430-c442874ba63a
 	golang.org/x/crypto v0.11.0
+	golang.org/x/net v0.10.0
 	gorm.io/driver/mysql v1.5.1
 	gorm.io/gorm v1.25.2
 )
@@ -35,7 +36,6 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.11 // indirect
 	golang.org/x/arch v0.3.0 // indirect
-	golang.org/x/net v0.10.0 // indirect
 	golang.org/x/sys v0.10.0 // indirect
 	golang.org/x/text v0.11.0 // indirect
 	google.golang.org/protobuf v1.30.0 // indirect
@@ -0,0 +1,210 @@
+package handler
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// AdminHandler lets the administrators look into the rooms running in the manager and act on them
+type AdminHandler struct {
+	roomManager service.Manager
+	auditLogger service.AuditLogger
+}
+
+func NewAdminHandler(roomManager service.Manager, auditLogger service.AuditLogger) *AdminHandler {
+	return &AdminHandler{
+		roomManager: roomManager,
+		auditLogger: auditLogger,
+	}
+}
+
+type AnnouncementDTO struct {
+	// The room to announce in, every running room when empty
+	RoomId string `json:"roomId"`
+	Text   string `json:"text"`
+}
+
+type CloseRoomDTO struct {
+	Reason string `json:"reason"`
+}
+
+/*
+GetRooms returns the running rooms with their listeners, users, message rate
+and last activity, the most recently active first.
+*/
+func (h *AdminHandler) GetRooms(c *gin.Context) {
+	c.JSON(200, h.roomManager.Rooms())
+}
+
+/*
+GetListeners returns the listeners open in the room, with their user, IP and connection time.
+
+Errors:
+  - 404 Not Found: if the room is not running
+*/
+func (h *AdminHandler) GetListeners(c *gin.Context) {
+	listeners, err := h.roomManager.Listeners(c.Param("roomid"))
+	if err != nil {
+		c.JSON(404, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, listeners)
+}
+
+/*
+Disconnect closes a listener of the room, ending the stream using it.
+
+Errors:
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if the room is not running or the listener is already closed
+*/
+func (h *AdminHandler) Disconnect(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	err = h.roomManager.Disconnect(roomid, c.Param("id"))
+	if err != nil {
+		c.JSON(404, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.auditLogger.Audit(auditEvent(c, model.AuditListenerDisconnected, actor, "room:"+roomid, model.AuditMetadata{
+		"listener": c.Param("id"),
+	}))
+
+	c.Status(204)
+}
+
+/*
+Announce sends a system notice to a room, or to every running room when no room is given.
+
+Errors:
+  - 400 Bad Request: if the body is invalid or the text is empty
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if the room is not running
+*/
+func (h *AdminHandler) Announce(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &AnnouncementDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if strings.TrimSpace(data.Text) == "" {
+		c.JSON(400, gin.H{
+			"error": service.ErrEmptyAnnouncement.Error(),
+		})
+		return
+	}
+
+	rooms := 1
+	target := "room:" + data.RoomId
+	if data.RoomId == "" {
+		rooms = h.roomManager.AnnounceAll(data.Text)
+		target = "rooms"
+	} else if err := h.roomManager.Announce(data.RoomId, data.Text); err != nil {
+		c.JSON(404, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.auditLogger.Audit(auditEvent(c, model.AuditAnnouncement, actor, target, model.AuditMetadata{
+		"text":  data.Text,
+		"rooms": rooms,
+	}))
+
+	c.JSON(200, gin.H{
+		"rooms": rooms,
+	})
+}
+
+/*
+DeleteRoom deletes a room, its listeners receive a room.closed event. It stands in for
+the DeleteRoom of the HTML adapter, which does not know who deletes the room.
+
+Errors:
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *AdminHandler) DeleteRoom(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	h.roomManager.DeleteBroadcast(roomid)
+	h.auditLogger.Audit(auditEvent(c, model.AuditRoomDeleted, actor, "room:"+roomid, nil))
+
+	c.Status(204)
+}
+
+/*
+CloseRoom closes a running room, its listeners receive a room.closed event with the reason.
+The room starts again on its next use.
+
+Errors:
+  - 400 Bad Request: if the body is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if the room is not running
+*/
+func (h *AdminHandler) CloseRoom(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &CloseRoomDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if err := h.roomManager.CloseRoom(roomid, data.Reason); err != nil {
+		c.JSON(404, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.auditLogger.Audit(auditEvent(c, model.AuditRoomClosed, actor, "room:"+roomid, model.AuditMetadata{
+		"reason": data.Reason,
+	}))
+
+	c.Status(204)
+}
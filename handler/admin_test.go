@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// adminManager runs the room general, with the listener l1
+type adminManager struct {
+	service.Manager
+	announced []string
+	closed    []string
+}
+
+func (m *adminManager) Rooms() []service.RoomInfo {
+	return []service.RoomInfo{{RoomId: "general", Listeners: 1}}
+}
+
+func (m *adminManager) Listeners(roomid string) ([]service.ListenerInfo, error) {
+	if roomid != "general" {
+		return nil, service.ErrRoomNotRunning
+	}
+	return []service.ListenerInfo{{Id: "l1", UserId: "2"}}, nil
+}
+
+func (m *adminManager) Disconnect(roomid, listenerId string) error {
+	if roomid != "general" {
+		return service.ErrRoomNotRunning
+	}
+	if listenerId != "l1" {
+		return service.ErrListenerNotFound
+	}
+	m.closed = append(m.closed, listenerId)
+	return nil
+}
+
+func (m *adminManager) Announce(roomid, text string) error {
+	if roomid != "general" {
+		return service.ErrRoomNotRunning
+	}
+	m.announced = append(m.announced, roomid)
+	return nil
+}
+
+func (m *adminManager) AnnounceAll(text string) int {
+	m.announced = append(m.announced, "")
+	return 1
+}
+
+func (m *adminManager) DeleteBroadcast(roomid string) {
+	m.closed = append(m.closed, roomid)
+}
+
+func (m *adminManager) CloseRoom(roomid, reason string) error {
+	if roomid != "general" {
+		return service.ErrRoomNotRunning
+	}
+	m.closed = append(m.closed, roomid)
+	return nil
+}
+
+// adminRouter serves the admin endpoints to user, without AdminMiddleware
+func adminRouter(user *model.User) (*gin.Engine, *adminManager, *auditRecorder) {
+	m := &adminManager{}
+	audit := &auditRecorder{}
+	h := NewAdminHandler(m, audit)
+	router := gin.New()
+	router.GET("/admin/rooms", withUser(user), h.GetRooms)
+	router.GET("/admin/rooms/:roomid/listeners", withUser(user), h.GetListeners)
+	router.DELETE("/admin/rooms/:roomid/listeners/:id", withUser(user), h.Disconnect)
+	router.POST("/admin/announcements", withUser(user), h.Announce)
+	router.POST("/admin/rooms/:roomid/close", withUser(user), h.CloseRoom)
+	router.DELETE("/room/:roomid", withUser(user), h.DeleteRoom)
+	return router, m, audit
+}
+
+func TestAdminActionsNeedAnActor(t *testing.T) {
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodDelete, "/admin/rooms/general/listeners/l1", nil),
+		httptest.NewRequest(http.MethodPost, "/admin/announcements", strings.NewReader(`{"text":"maintenance"}`)),
+		httptest.NewRequest(http.MethodPost, "/admin/rooms/general/close", strings.NewReader(`{}`)),
+		httptest.NewRequest(http.MethodDelete, "/room/general", nil),
+	}
+	for _, r := range requests {
+		router, m, audit := adminRouter(nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != 401 {
+			t.Errorf("%s %s: status %d, want 401", r.Method, r.URL.Path, w.Code)
+		}
+		if len(m.closed) > 0 || len(m.announced) > 0 || len(audit.recorded()) > 0 {
+			t.Errorf("%s %s: done without an actor", r.Method, r.URL.Path)
+		}
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+		action string
+		target string
+	}{
+		{"rooms", http.MethodGet, "/admin/rooms", "", 200, "", ""},
+		{"listeners", http.MethodGet, "/admin/rooms/general/listeners", "", 200, "", ""},
+		{"listeners of a stopped room", http.MethodGet, "/admin/rooms/lobby/listeners", "", 404, "", ""},
+		{"disconnect", http.MethodDelete, "/admin/rooms/general/listeners/l1", "", 204, model.AuditListenerDisconnected, "room:general"},
+		{"disconnect a closed listener", http.MethodDelete, "/admin/rooms/general/listeners/l2", "", 404, "", ""},
+		{"announce in a room", http.MethodPost, "/admin/announcements", `{"roomId":"general","text":"maintenance"}`, 200, model.AuditAnnouncement, "room:general"},
+		{"announce everywhere", http.MethodPost, "/admin/announcements", `{"text":"restart"}`, 200, model.AuditAnnouncement, "rooms"},
+		{"announce in a stopped room", http.MethodPost, "/admin/announcements", `{"roomId":"lobby","text":"maintenance"}`, 404, "", ""},
+		{"empty announcement", http.MethodPost, "/admin/announcements", `{"text":" "}`, 400, "", ""},
+		{"invalid announcement", http.MethodPost, "/admin/announcements", `{`, 400, "", ""},
+		{"close", http.MethodPost, "/admin/rooms/general/close", `{"reason":"spam"}`, 204, model.AuditRoomClosed, "room:general"},
+		{"close a stopped room", http.MethodPost, "/admin/rooms/lobby/close", `{}`, 404, "", ""},
+		{"delete", http.MethodDelete, "/room/general", "", 204, model.AuditRoomDeleted, "room:general"},
+	}
+	for _, tt := range tests {
+		router, _, audit := adminRouter(userWithId(9, true))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+
+		events := audit.recorded()
+		if tt.action == "" {
+			if len(events) > 0 {
+				t.Errorf("%s: audited %+v", tt.name, events)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].Action != tt.action || events[0].Target != tt.target || events[0].ActorId == nil || *events[0].ActorId != 9 {
+			t.Errorf("%s: audited %+v, want %s of %s by 9", tt.name, events, tt.action, tt.target)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/service"
+	"github.com/riri95500/go-chat/storage"
+	"gorm.io/gorm"
+)
+
+type AttachmentHandler struct {
+	attachmentService *service.AttachmentService
+	roomService       *service.RoomService
+	roomManager       service.Manager
+}
+
+func NewAttachmentHandler(attachmentService *service.AttachmentService, roomService *service.RoomService, roomManager service.Manager) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+		roomService:       roomService,
+		roomManager:       roomManager,
+	}
+}
+
+/*
+Upload stores the file sent in the "file" field of a multipart form, joining the room if needed.
+The returned id is then given in the attachmentIds of a message.
+
+Errors:
+  - 400 Bad Request: if there is no file
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is banned or muted
+  - 413 Request Entity Too Large: if the file is larger than the limit
+  - 415 Unsupported Media Type: if the type of the file is not allowed
+*/
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if _, err := h.roomService.Join(roomid, user.ID); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err := h.roomManager.Admit(userKey(user), roomid); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Marge pour les en-têtes du formulaire multipart
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.attachmentService.MaxSize()+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		if errors.As(err, &maxBytesError) {
+			err = service.ErrAttachmentTooLarge
+		}
+		logError(c, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	attachment, err := h.attachmentService.Upload(c.Request.Context(), roomid, userKey(user), header.Filename, file, header.Size)
+	if err != nil {
+		logError(c, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(201, attachment)
+}
+
+/*
+Download sends the content of an attachment. Only the members of the room can download it,
+images are displayed inline while the other files are downloaded.
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is not a member of the room
+  - 404 Not Found: if there is no such attachment in the room
+*/
+func (h *AttachmentHandler) Download(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": service.ErrInvalidMessageId.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if _, err := h.roomService.GetMember(roomid, user.ID); err != nil {
+		c.JSON(403, gin.H{
+			"error": service.ErrNotParticipant.Error(),
+		})
+		return
+	}
+
+	attachment, err := h.attachmentService.GetAttachment(roomid, id, userKey(user))
+	if err != nil {
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	content, err := h.attachmentService.Open(c.Request.Context(), attachment)
+	if err != nil {
+		logError(c, err)
+		c.JSON(attachmentErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	defer content.Close()
+
+	disposition := "attachment"
+	if strings.HasPrefix(attachment.ContentType, "image/") {
+		disposition = "inline"
+	}
+
+	c.DataFromReader(200, attachment.Size, attachment.ContentType, content, map[string]string{
+		"Content-Disposition":    mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, max-age=86400",
+	})
+}
+
+// attachmentErrorStatus maps the errors of the AttachmentService to an HTTP status
+func attachmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, storage.ErrNotFound):
+		return 404
+	case errors.Is(err, service.ErrAttachmentTooLarge):
+		return 413
+	case errors.Is(err, service.ErrAttachmentType):
+		return 415
+	default:
+		return 400
+	}
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+	"github.com/riri95500/go-chat/storage"
+)
+
+// attachmentRouter serves the attachments of the room general, where only user 1 is a member
+func attachmentRouter(t *testing.T, user *model.User, m *fakeManager) (*gin.Engine, *fakedb.DB, storage.BlobStore) {
+	t.Helper()
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "count(*)"):
+			return fakedb.Rows([]string{"count"}, int64(0))
+		case strings.Contains(query, "FROM `room_members`") && args[1] == int64(1):
+			return fakedb.Rows([]string{"id", "room_id", "user_id", "role"}, int64(1), "general", int64(1), model.RoleMember)
+		case strings.Contains(query, "FROM `attachments`"):
+			return fakedb.Rows([]string{"id", "room_id", "user_id", "key", "filename", "content_type", "size"},
+				int64(7), "general", "1", "attachments/7", "cat.png", "image/png", int64(8))
+		}
+		return nil, nil
+	})
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachmentService := service.NewAttachmentService(db, store, &config.Config{ATTACHMENT_MAX_SIZE: 1024})
+	h := NewAttachmentHandler(attachmentService, service.NewRoomService(db), m)
+	router := gin.New()
+	router.POST("/rooms/:roomid/attachments", withUser(user), h.Upload)
+	router.GET("/rooms/:roomid/attachments/:id", withUser(user), h.Download)
+	return router, fake, store
+}
+
+func uploadRequest(t *testing.T, content []byte) *http.Request {
+	t.Helper()
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	if content != nil {
+		part, _ := form.CreateFormFile("file", "../notes.txt")
+		part.Write(content)
+	}
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/rooms/general/attachments", body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestUpload(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *model.User
+		content []byte
+		muted   bool
+		want    int
+	}{
+		{"anonymous", nil, []byte("hello"), false, 401},
+		{"text file", userWithId(1, false), []byte("hello"), false, 201},
+		{"no file", userWithId(1, false), nil, false, 400},
+		{"html", userWithId(1, false), []byte("<html><script>alert(1)</script></html>"), false, 415},
+		{"too large", userWithId(1, false), bytes.Repeat([]byte("a"), 2048), false, 413},
+		{"muted", userWithId(1, false), []byte("hello"), true, 403},
+	}
+	for _, tt := range tests {
+		m := newFakeManager()
+		if tt.muted {
+			m.restricted["1"] = service.ErrMuted
+		}
+		router, fake, _ := attachmentRouter(t, tt.user, m)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, uploadRequest(t, tt.content))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+		}
+		if stored := fake.Ran("INSERT INTO `attachments`"); stored != (tt.want == 201) {
+			t.Errorf("%s: attachment stored %v", tt.name, stored)
+		}
+		if tt.want == 201 && !strings.Contains(w.Body.String(), `"filename":"notes.txt"`) {
+			t.Errorf("%s: the file name keeps its directory: %s", tt.name, w.Body)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	tests := []struct {
+		name string
+		user *model.User
+		id   string
+		want int
+	}{
+		{"anonymous", nil, "7", 401},
+		{"invalid id", userWithId(1, false), "abc", 400},
+		{"not a member", userWithId(2, false), "7", 403},
+		{"the author", userWithId(1, false), "7", 200},
+	}
+	for _, tt := range tests {
+		router, _, store := attachmentRouter(t, tt.user, newFakeManager())
+		store.Put(context.Background(), "attachments/7", bytes.NewReader(png), int64(len(png)), "image/png")
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/general/attachments/"+tt.id, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		if !bytes.Equal(w.Body.Bytes(), png) {
+			t.Errorf("%s: got %q", tt.name, w.Body)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != "inline; filename=cat.png" {
+			t.Errorf("%s: Content-Disposition %q", tt.name, got)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: content type sniffing allowed", tt.name)
+		}
+	}
+}
+
+func TestDownloadOfMissingBlob(t *testing.T) {
+	router, _, _ := attachmentRouter(t, userWithId(1, false), newFakeManager())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/general/attachments/7", nil))
+	if w.Code != 404 {
+		t.Errorf("status %d, want 404", w.Code)
+	}
+}
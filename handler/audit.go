@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// maxUserAgent is the size of the user agent column of the audit log
+const maxUserAgent = 255
+
+type AuditHandler struct {
+	auditService *service.AuditService
+}
+
+func NewAuditHandler(auditService *service.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+/*
+AdminMiddleware rejects the requests of users who are not global administrators.
+It must run after AuthMiddleware.
+*/
+func AdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !user.Admin {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": "only the administrators can do this",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+GetEvents returns the audit log, most recent first.
+
+Query parameters:
+  - actor (int): only the events of this user
+  - action (string): only the events of this action, such as auth.login_failed
+  - since (RFC 3339 time): only the events at or after this time
+  - until (RFC 3339 time): only the events before this time
+  - before (int): only events older than this event ID are returned
+  - limit (int): the maximum number of events, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+*/
+func (h *AuditHandler) GetEvents(c *gin.Context) {
+	before, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	query := service.AuditQuery{
+		Action: c.Query("action"),
+		Before: before,
+		Limit:  limit,
+	}
+	if actor := c.Query("actor"); actor != "" {
+		id, err := strconv.Atoi(actor)
+		if err != nil || id <= 0 {
+			c.JSON(400, gin.H{
+				"error": "invalid actor",
+			})
+			return
+		}
+		query.ActorId = uint(id)
+	}
+	query.Since, err = queryTime(c, "since")
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	query.Until, err = queryTime(c, "until")
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	events, err := h.auditService.GetEvents(query)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, events)
+}
+
+// queryTime reads an RFC 3339 time from the query, the zero time when absent
+func queryTime(c *gin.Context, param string) (time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid " + param + ", expected an RFC 3339 time")
+	}
+
+	return t, nil
+}
+
+/*
+auditEvent builds an audit event for the current request, with the IP and the user agent of the client.
+
+Parameters:
+  - c (*gin.Context): the current request
+  - action (string): one of the model.Audit* actions
+  - actor (*model.User): the user at the origin of the action, nil when unknown
+  - target (string): what the action was done on
+  - metadata (model.AuditMetadata): the details of the action, may be nil
+
+Returns:
+  - (*model.AuditEvent): the event, to give to an AuditLogger
+*/
+func auditEvent(c *gin.Context, action string, actor *model.User, target string, metadata model.AuditMetadata) *model.AuditEvent {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+
+	event := &model.AuditEvent{
+		Action:    action,
+		Target:    target,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent,
+		Metadata:  metadata,
+	}
+	if actor != nil && actor.ID > 0 {
+		event.ActorId = &actor.ID
+	}
+
+	return event
+}
+
+// userTarget is the target of the audit events about a user
+func userTarget(id uint) string {
+	return "user:" + strconv.Itoa(int(id))
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// auditRecorder is an AuditLogger keeping the events in memory
+type auditRecorder struct {
+	mu     sync.Mutex
+	events []*model.AuditEvent
+}
+
+func (r *auditRecorder) Audit(event *model.AuditEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *auditRecorder) recorded() []*model.AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*model.AuditEvent{}, r.events...)
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		user *model.User
+		want int
+	}{
+		{"anonymous", nil, 401},
+		{"a user", userWithId(1, false), 403},
+		{"an administrator", userWithId(1, true), 200},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.GET("/admin", withUser(tt.user), AdminMiddleware(), func(c *gin.Context) {
+			c.Status(200)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin", nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestGetEventsQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  int
+		ran   string
+	}{
+		{"", 200, "ORDER BY id DESC"},
+		{"?actor=3&action=auth.login", 200, "actor_id = ?"},
+		{"?since=2026-01-02T15:04:05Z&until=2026-01-03T15:04:05Z", 200, "created_at < ?"},
+		{"?actor=abc", 400, ""},
+		{"?actor=-1", 400, ""},
+		{"?since=yesterday", 400, ""},
+		{"?until=2026-01-02", 400, ""},
+		{"?limit=ten", 400, ""},
+		{"?limit=0", 400, ""},
+	}
+	for _, tt := range tests {
+		db, fake := fakedb.Open(t, nil)
+		h := NewAuditHandler(service.NewAuditService(db, slog.Default()))
+		router := gin.New()
+		router.GET("/admin/audit", h.GetEvents)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/audit"+tt.query, nil))
+		if w.Code != tt.want {
+			t.Errorf("%q: status %d, want %d: %s", tt.query, w.Code, tt.want, w.Body.String())
+		}
+		if tt.ran != "" && !fake.Ran(tt.ran) {
+			t.Errorf("%q: %s not in the query", tt.query, tt.ran)
+		}
+		if tt.ran == "" && fake.Ran("audit_events") {
+			t.Errorf("%q: audit log queried", tt.query)
+		}
+	}
+}
+
+func TestAuditEventDescribesTheRequest(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	c.Request.RemoteAddr = "192.0.2.1:1234"
+	c.Request.Header.Set("User-Agent", strings.Repeat("a", 300))
+
+	event := auditEvent(c, model.AuditLogin, userWithId(4, false), userTarget(4), nil)
+	if event.ActorId == nil || *event.ActorId != 4 || event.Target != "user:4" {
+		t.Errorf("event %+v, want user 4 as actor and target", event)
+	}
+	if event.IP != "192.0.2.1" {
+		t.Errorf("IP %q, want 192.0.2.1", event.IP)
+	}
+	if len(event.UserAgent) != maxUserAgent {
+		t.Errorf("user agent of %d bytes, want it cut to %d", len(event.UserAgent), maxUserAgent)
+	}
+
+	if event := auditEvent(c, model.AuditLoginFailed, nil, "", nil); event.ActorId != nil {
+		t.Errorf("unknown actor audited as %d", *event.ActorId)
+	}
+}
@@ -12,19 +12,22 @@ import (
 	"github.com/riri95500/go-chat/config"
 	"github.com/riri95500/go-chat/model"
 	"github.com/riri95500/go-chat/service"
+	"github.com/riri95500/go-chat/tracing"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
 	RTService   *service.RTService
 	UserService *service.UserService
+	AuditLogger service.AuditLogger
 	*config.Config
 }
 
-func NewAuthHandler(rTService *service.RTService, userService *service.UserService, config *config.Config) *AuthHandler {
+func NewAuthHandler(rTService *service.RTService, userService *service.UserService, auditLogger service.AuditLogger, config *config.Config) *AuthHandler {
 	return &AuthHandler{
 		RTService:   rTService,
 		UserService: userService,
+		AuditLogger: auditLogger,
 		Config:      config,
 	}
 }
@@ -78,21 +81,33 @@ func (authHandler *AuthHandler) Login(c *gin.Context) {
 	returnError := curryReturnError(c, false)
 
 	if err := c.ShouldBindJSON(&loginDTO); err != nil {
-		fmt.Println(err)
+		logError(c, err)
 		returnError(err)
 		return
 	}
 
-	user, err := authHandler.UserService.GetUserByEmail(loginDTO.Email)
+	user, err := authHandler.UserService.WithContext(c.Request.Context()).GetUserByEmail(loginDTO.Email)
 	if err != nil {
-		fmt.Println(err)
+		logError(c, err)
+		authOutcomes.With(authLoginFailure).Inc()
+		authHandler.AuditLogger.Audit(auditEvent(c, model.AuditLoginFailed, nil, "", model.AuditMetadata{
+			"email":  loginDTO.Email,
+			"reason": "unknown email",
+		}))
 		returnError(err)
 		return
 	}
 
+	_, span := tracing.Child(c.Request.Context(), "bcrypt.CompareHashAndPassword")
 	err = user.CheckPassword(loginDTO.Password)
+	span.End()
 	if err != nil {
-		fmt.Println(err)
+		logError(c, err)
+		authOutcomes.With(authLoginFailure).Inc()
+		authHandler.AuditLogger.Audit(auditEvent(c, model.AuditLoginFailed, nil, userTarget(user.ID), model.AuditMetadata{
+			"email":  loginDTO.Email,
+			"reason": "incorrect password",
+		}))
 		if err == bcrypt.ErrMismatchedHashAndPassword {
 			returnError(errors.New("incorrect password"))
 		} else {
@@ -103,18 +118,21 @@ func (authHandler *AuthHandler) Login(c *gin.Context) {
 
 	jwt, err := authHandler.GenerateToken(user)
 	if err != nil {
-		fmt.Println(err)
+		logError(c, err)
 		returnError(err)
 		return
 	}
 
-	rt, err := authHandler.RTService.CreateRT(c.ClientIP(), int(user.ID))
+	rt, err := authHandler.RTService.WithContext(c.Request.Context()).CreateRT(c.ClientIP(), int(user.ID))
 	if err != nil {
-		fmt.Println(err)
+		logError(c, err)
 		returnError(err)
 		return
 	}
 
+	authOutcomes.With(authLoginSuccess).Inc()
+	authHandler.AuditLogger.Audit(auditEvent(c, model.AuditLogin, user, userTarget(user.ID), nil))
+
 	c.SetCookie("jwt", jwt, 3600, "/", "*", false, true)
 	c.SetCookie("rt", rt.Hash, 3600, "/", "*", false, true)
 
@@ -140,14 +158,13 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 		// before request
 
 		returnErrorWithAbort := curryReturnError(c, true)
-		returnError := curryReturnError(c, false)
 
 		// First, trying to extract the jwt from the cookie
 		jwtToken, err := c.Cookie("jwt")
 
 		// If not present, proceed to extract it from the Authorization header
 		if err != nil && err != http.ErrNoCookie {
-			returnError(err)
+			returnErrorWithAbort(err)
 			return
 		}
 
@@ -180,9 +197,10 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 		})
 
 		if err != nil && !errors.Is(err, jwt.ErrTokenExpired) {
-			returnError(err)
+			returnErrorWithAbort(err)
 			return
 		}
+		expired := errors.Is(err, jwt.ErrTokenExpired)
 
 		err = func(c *gin.Context) error {
 			// If the token is expired, let's try to update it with the refresh token
@@ -196,8 +214,12 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 				return err
 			}
 			// If we get a token, this part will handle all the logic. It means that it does not return to the main part.
-			rt, err := authHandler.RTService.GetRT(rtToken)
+			rt, err := authHandler.RTService.WithContext(c.Request.Context()).GetRT(rtToken)
 			if err != nil {
+				authOutcomes.With(authRefreshFailure).Inc()
+				authHandler.AuditLogger.Audit(auditEvent(c, model.AuditRefreshFailed, nil, "", model.AuditMetadata{
+					"error": err.Error(),
+				}))
 				return err
 			}
 
@@ -206,12 +228,18 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 				return errors.New("token expired, unable to automatically refresh. Something went wrong retrieving the user")
 			}
 
-			c.Set("user", rt.User)
+			authOutcomes.With(authRefreshUsed).Inc()
+			authHandler.AuditLogger.Audit(auditEvent(c, model.AuditTokenRefreshed, &rt.User, userTarget(rt.User.ID), model.AuditMetadata{
+				"refreshToken": rt.ID,
+				"issuedTo":     rt.Ip,
+			}))
+
+			c.Set("user", &rt.User)
 
 			// Regenerating the cookie and putting it in the response's cookies
 			newJwt, err := authHandler.GenerateToken(&rt.User)
 			if err != nil {
-				fmt.Println(err)
+				logError(c, err)
 				return err
 			}
 
@@ -226,9 +254,13 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 			returnErrorWithAbort(err)
 			return
 		}
+		// La requête a déjà été servie avec l'utilisateur du refresh token
+		if expired {
+			return
+		}
 
 		userId := token.Claims.(jwt.MapClaims)["id"].(float64)
-		user, err := authHandler.UserService.GetUser(int(userId))
+		user, err := authHandler.UserService.WithContext(c.Request.Context()).GetUser(int(userId))
 		if err != nil {
 			returnErrorWithAbort(err)
 			return
@@ -242,6 +274,30 @@ func (authHandler *AuthHandler) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+/*
+currentUser returns the user set in the context by AuthMiddleware.
+
+Parameters:
+- c (*gin.Context): A pointer to the gin.Context instance.
+
+Returns:
+- (*model.User): the authenticated user
+- (error): an error if no user is in the context
+*/
+func currentUser(c *gin.Context) (*model.User, error) {
+	user, ok := c.Get("user")
+	if !ok {
+		return nil, errors.New("no user in the context")
+	}
+
+	u, ok := user.(*model.User)
+	if !ok {
+		return nil, errors.New("no user in the context")
+	}
+
+	return u, nil
+}
+
 func curryReturnError(c *gin.Context, abort bool) func(err error) {
 	return func(err error) {
 		c.JSON(400, gin.H{
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/service"
+)
+
+func TestAuthMiddlewareStopsInvalidTokens(t *testing.T) {
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "FROM `users`") {
+			return []string{"id", "email", "username"}, [][]driver.Value{{int64(1), "alice@example.com", "alice"}}
+		}
+		return nil, nil
+	})
+	auth := NewAuthHandler(service.NewRTService(db), service.NewUserService(db), &auditRecorder{}, &config.Config{JWT_SECRET: "secret"})
+	users := NewUserHandler(service.NewUserService(db), &auditRecorder{})
+	router := gin.New()
+	api := router.Group("/api/v1/user", auth.AuthMiddleware())
+	api.GET("/:id", users.GetUser)
+	api.GET("/", users.GetUsers)
+
+	valid, err := auth.GenerateToken(userWithId(1, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := (&AuthHandler{Config: &config.Config{JWT_SECRET: "other"}}).GenerateToken(userWithId(1, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		cookie string
+	}{
+		{"no token", "/api/v1/user/", "", ""},
+		{"garbage bearer token", "/api/v1/user/", "Bearer garbage", ""},
+		{"garbage bearer token on a user", "/api/v1/user/1", "Bearer garbage", ""},
+		{"token signed with another secret", "/api/v1/user/", "Bearer " + forged, ""},
+		{"garbage cookie", "/api/v1/user/", "", "garbage"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "jwt", Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != 400 || strings.Contains(w.Body.String(), "alice") {
+			t.Errorf("%s: status %d, body %s, want 400 without user data", tt.name, w.Code, w.Body.String())
+		}
+	}
+	if fake.Ran("FROM `users`") {
+		t.Error("users read without a valid token")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/user/", nil)
+	r.Header.Set("Authorization", "Bearer "+valid)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != 200 || !strings.Contains(w.Body.String(), "alice") {
+		t.Errorf("valid token: status %d, body %s, want the users", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+	"gorm.io/gorm"
+)
+
+type DMHandler struct {
+	roomManager service.Manager
+	roomService *service.RoomService
+}
+
+func NewDMHandler(roomManager service.Manager, roomService *service.RoomService) *DMHandler {
+	return &DMHandler{
+		roomManager: roomManager,
+		roomService: roomService,
+	}
+}
+
+type ConversationDTO struct {
+	UserIds []uint `json:"userIds"`
+}
+
+/*
+StartConversation starts, or reuses, the one-to-one conversation between
+the authenticated user and the user given in the path.
+The conversation is then used as any room through /api/v1/rooms/:roomid.
+
+Errors:
+  - 400 Bad Request: if the id is invalid, or is the one of the authenticated user
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if the user does not exist
+*/
+func (h *DMHandler) StartConversation(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.startConversation(c, []uint{user.ID, uint(id)})
+}
+
+/*
+StartGroupConversation starts, or reuses, the conversation between the
+authenticated user and the users given in the body.
+
+Errors:
+  - 400 Bad Request: if the body is invalid or the number of users is not allowed
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if a user does not exist
+*/
+func (h *DMHandler) StartGroupConversation(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &ConversationDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.startConversation(c, append(data.UserIds, user.ID))
+}
+
+func (h *DMHandler) startConversation(c *gin.Context, userIds []uint) {
+	room, err := h.roomService.StartConversation(userIds)
+	if err != nil {
+		logError(c, err)
+		status := 400
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = 404
+		}
+		c.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// Les streams /me/stream des participants s'abonnent à la conversation
+	for _, member := range room.Members {
+		h.roomManager.Publish(service.NewEvent(service.EventRoomJoined, service.UserRoomId(userKey(&member.User)), "", service.RoomJoinedPayload{
+			RoomId: room.RoomId,
+		}))
+	}
+
+	c.JSON(200, conversationResponse(room))
+}
+
+/*
+GetConversations returns the inbox of the authenticated user: their
+conversations, the most recently active first.
+
+Errors:
+  - 400 Bad Request: if the conversations could not be retrieved
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *DMHandler) GetConversations(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	rooms, err := h.roomService.GetConversations(user.ID)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	conversations := make([]gin.H, len(rooms))
+	for i, room := range rooms {
+		conversations[i] = conversationResponse(room)
+	}
+
+	c.JSON(200, conversations)
+}
+
+func conversationResponse(room *model.Room) gin.H {
+	participants := make([]model.User, len(room.Members))
+	for i, member := range room.Members {
+		participants[i] = member.User
+	}
+
+	return gin.H{
+		"roomId":         room.RoomId,
+		"participants":   participants,
+		"lastActivityAt": room.LastActivityAt,
+	}
+}
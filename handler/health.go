@@ -0,0 +1,160 @@
+package handler
+
+import (
+	"context"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/service"
+	"gorm.io/gorm"
+)
+
+// checkTimeout bounds every check of the probes, so that a stuck dependency fails the probe instead of hanging it
+const checkTimeout = 2 * time.Second
+
+type HealthHandler struct {
+	db           *gorm.DB
+	roomManager  service.Manager
+	conf         *config.Config
+	version      string
+	startedAt    time.Time
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandler(db *gorm.DB, roomManager service.Manager, conf *config.Config, version string) *HealthHandler {
+	return &HealthHandler{
+		db:          db,
+		roomManager: roomManager,
+		conf:        conf,
+		version:     version,
+		startedAt:   time.Now(),
+	}
+}
+
+// ShutDown makes the readiness probe fail, so that no new traffic is routed to the server while it drains
+func (h *HealthHandler) ShutDown() {
+	h.shuttingDown.Store(true)
+}
+
+/*
+Healthz is the liveness probe, it fails when the manager goroutine does not answer.
+It checks nothing outside of the process, a restart would not fix it.
+*/
+func (h *HealthHandler) Healthz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+	defer cancel()
+
+	if err := h.roomManager.Ping(ctx); err != nil {
+		logError(c, err)
+		c.JSON(503, gin.H{
+			"status": "unhealthy",
+			"checks": gin.H{"manager": err.Error()},
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status": "ok",
+	})
+}
+
+/*
+Readyz is the readiness probe, it fails while the database or the manager
+routing the messages cannot be reached, and once the server is shutting down.
+Every check is run, the response tells which ones failed.
+*/
+func (h *HealthHandler) Readyz(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), checkTimeout)
+	defer cancel()
+
+	checks := gin.H{
+		"database": "ok",
+		"manager":  "ok",
+		"shutdown": "ok",
+	}
+	ready := true
+
+	if err := h.pingDB(ctx); err != nil {
+		logError(c, err)
+		checks["database"] = err.Error()
+		ready = false
+	}
+	if err := h.roomManager.Ping(ctx); err != nil {
+		logError(c, err)
+		checks["manager"] = err.Error()
+		ready = false
+	}
+	if h.shuttingDown.Load() {
+		checks["shutdown"] = "shutting down"
+		ready = false
+	}
+
+	if !ready {
+		c.JSON(503, gin.H{
+			"status": "unavailable",
+			"checks": checks,
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status": "ok",
+		"checks": checks,
+	})
+}
+
+func (h *HealthHandler) pingDB(ctx context.Context) error {
+	sqlDB, err := h.db.DB()
+	if err != nil {
+		return err
+	}
+
+	return sqlDB.PingContext(ctx)
+}
+
+/*
+Status describes the running server to the administrators: rooms and listeners,
+uptime, build and configuration, with the credentials redacted.
+*/
+func (h *HealthHandler) Status(c *gin.Context) {
+	stats := h.roomManager.Stats()
+
+	c.JSON(200, gin.H{
+		"startedAt":    h.startedAt,
+		"uptime":       time.Since(h.startedAt).Round(time.Second).String(),
+		"shuttingDown": h.shuttingDown.Load(),
+		"build":        h.build(),
+		"rooms":        stats.Rooms,
+		"listeners":    stats.Listeners,
+		"queues":       stats.Queues,
+		"config":       h.conf.Summary(),
+	})
+}
+
+// build returns the version given at link time, with the commit and the Go version read from the binary
+func (h *HealthHandler) build() gin.H {
+	build := gin.H{
+		"version": h.version,
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	build["go"] = info.GoVersion
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build["revision"] = setting.Value
+		case "vcs.time":
+			build["commitTime"] = setting.Value
+		case "vcs.modified":
+			build["modified"] = setting.Value == "true"
+		}
+	}
+
+	return build
+}
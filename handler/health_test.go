@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/service"
+)
+
+// pingManager answers Ping with err, and Stats with one room and two listeners
+type pingManager struct {
+	service.Manager
+	err error
+}
+
+func (m *pingManager) Ping(ctx context.Context) error {
+	return m.err
+}
+
+func (m *pingManager) Stats() service.ManagerStats {
+	return service.ManagerStats{Rooms: 1, Listeners: 2, Queues: map[string]int{"messages": 0}}
+}
+
+// probe calls the endpoint of the handler and decodes its response
+func probe(t *testing.T, endpoint gin.HandlerFunc) (int, map[string]interface{}) {
+	t.Helper()
+	router := gin.New()
+	router.GET("/probe", endpoint)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/probe", nil))
+
+	body := map[string]interface{}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, body
+}
+
+func TestHealthz(t *testing.T) {
+	db, _ := fakedb.Open(t, nil)
+	if code, _ := probe(t, NewHealthHandler(db, &pingManager{}, &config.Config{}, "test").Healthz); code != 200 {
+		t.Errorf("status %d with a running manager, want 200", code)
+	}
+
+	code, body := probe(t, NewHealthHandler(db, &pingManager{err: context.DeadlineExceeded}, &config.Config{}, "test").Healthz)
+	if code != 503 || body["checks"].(map[string]interface{})["manager"] != context.DeadlineExceeded.Error() {
+		t.Errorf("status %d, body %v with a stuck manager, want 503 naming the manager", code, body)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	closedDB, _ := fakedb.Open(t, nil)
+	sqlDB, err := closedDB.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+
+	tests := []struct {
+		name     string
+		closed   bool
+		manager  error
+		shutdown bool
+		want     int
+		failed   []string
+	}{
+		{"ready", false, nil, false, 200, nil},
+		{"database down", true, nil, false, 503, []string{"database"}},
+		{"manager stuck", false, errors.New("stuck"), false, 503, []string{"manager"}},
+		{"shutting down", false, nil, true, 503, []string{"shutdown"}},
+		{"everything down", true, errors.New("stuck"), true, 503, []string{"database", "manager", "shutdown"}},
+	}
+	for _, tt := range tests {
+		db, _ := fakedb.Open(t, nil)
+		if tt.closed {
+			db = closedDB
+		}
+		h := NewHealthHandler(db, &pingManager{err: tt.manager}, &config.Config{}, "test")
+		if tt.shutdown {
+			h.ShutDown()
+		}
+
+		code, body := probe(t, h.Readyz)
+		if code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, code, tt.want)
+		}
+		checks := body["checks"].(map[string]interface{})
+		failed := 0
+		for _, check := range tt.failed {
+			if checks[check] == "ok" {
+				t.Errorf("%s: check %s passed", tt.name, check)
+			}
+		}
+		for _, result := range checks {
+			if result != "ok" {
+				failed++
+			}
+		}
+		if failed != len(tt.failed) {
+			t.Errorf("%s: checks %v, want %v failed", tt.name, checks, tt.failed)
+		}
+	}
+}
+
+func TestStatusRedactsTheConfiguration(t *testing.T) {
+	db, _ := fakedb.Open(t, nil)
+	h := NewHealthHandler(db, &pingManager{}, &config.Config{DB_HOST: "db", DB_PASS: "hunter2"}, "1.2.3")
+	h.ShutDown()
+
+	code, body := probe(t, h.Status)
+	if code != 200 {
+		t.Fatalf("status %d, want 200", code)
+	}
+	conf := body["config"].(map[string]interface{})
+	if conf["DB_HOST"] != "db" || conf["DB_PASS"] != config.Redacted {
+		t.Errorf("config shown as %v", conf)
+	}
+	if body["build"].(map[string]interface{})["version"] != "1.2.3" || body["rooms"] != float64(1) || body["listeners"] != float64(2) || body["shuttingDown"] != true {
+		t.Errorf("status %v", body)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"html"
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/markdown"
+	"github.com/riri95500/go-chat/service"
+)
+
+/*
+HTMLStream replaces the Stream of the HTML adapter, whose page inserts every
+received line as HTML. Messages are sent as " user → html" lines where the html
+is the sanitised rendering of their Markdown, and every other text is escaped.
+*/
+func (h *RoomHandler) HTMLStream(c *gin.Context) {
+	roomid := c.Param("roomid")
+	sub := subscription{
+		roomid:   roomid,
+		listener: h.roomManager.OpenUserListener(roomid, "", c.ClientIP()),
+	}
+
+	events := make(chan *service.Event)
+	ended := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+	go h.forward(sub, events, ended, done)
+
+	clientGone := c.Request.Context().Done()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-clientGone:
+			return false
+		case <-ended:
+			return false
+		case e := <-events:
+			if line, ok := htmlLine(e); ok {
+				c.SSEvent("message", line)
+			}
+			return true
+		}
+	})
+}
+
+/*
+HTMLPost replaces the PostRoom of the HTML adapter, which trusts the user field of its form.
+The message is sent by the authenticated user, whose JWT cookie the page sends along,
+and goes through the same checks as PostMessage. The user field is ignored.
+
+Errors:
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is banned or muted
+  - 422 Unprocessable Entity: if the message is rejected by a content filter
+  - 429 Too Many Requests: if the user sends messages faster than the slow mode of the room allows
+  - 503 Service Unavailable: if the room is too busy to broadcast the message
+*/
+func (h *RoomHandler) HTMLPost(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if _, err := h.roomService.Join(roomid, user.ID); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	text := c.PostForm("message")
+	message := &service.Message{
+		UserId: userKey(user),
+		RoomId: roomid,
+		Text:   text,
+	}
+	if err := h.roomManager.SubmitMessage(c.Request.Context(), message); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"status":  "success",
+		"message": text,
+	})
+}
+
+// htmlLine renders the events shown by the HTML adapter page, the other ones are skipped
+func htmlLine(e *service.Event) (string, bool) {
+	switch payload := e.Payload.(type) {
+	case service.MessagePayload:
+		content := payload.Content
+		if content == nil {
+			content = markdown.Parse(payload.Text)
+		}
+		return " " + html.EscapeString(e.Actor) + " → " + markdown.RenderHTML(content), true
+	case service.SystemPayload:
+		return "<em>" + html.EscapeString(payload.Text) + "</em>", true
+	case service.RoomClosedPayload:
+		if payload.Reason == "" {
+			return "<em>The room was closed</em>", true
+		}
+		return "<em>The room was closed: " + html.EscapeString(payload.Reason) + "</em>", true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+func TestHTMLPostSendsAsTheAuthenticatedUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *model.User
+		refused error
+		want    int
+	}{
+		{"anonymous", nil, nil, 401},
+		{"a member", userWithId(1, false), nil, 200},
+		{"a banned member", userWithId(1, false), service.ErrBanned, 403},
+		{"an overloaded room", userWithId(1, false), service.ErrRoomOverloaded, 503},
+	}
+	for _, tt := range tests {
+		db, _ := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "count(*)"):
+				return fakedb.Rows([]string{"count"}, int64(0))
+			case strings.Contains(query, "FROM `room_members`"):
+				return fakedb.Rows([]string{"id", "room_id", "user_id", "role"}, int64(1), "general", int64(1), model.RoleMember)
+			}
+			return nil, nil
+		})
+		m := newFakeManager()
+		if tt.refused != nil {
+			m.restricted["1"] = tt.refused
+		}
+		h := &RoomHandler{roomManager: m, roomService: service.NewRoomService(db)}
+		router := gin.New()
+		router.POST("/room/:roomid", withUser(tt.user), h.HTMLPost)
+
+		// Le champ user du formulaire désigne un autre utilisateur
+		form := url.Values{"user": {"2"}, "message": {"hello"}}
+		req := httptest.NewRequest(http.MethodPost, "/room/general", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body)
+			continue
+		}
+		if tt.want != 200 {
+			if len(m.submitted) != 0 {
+				t.Errorf("%s: message submitted", tt.name)
+			}
+			continue
+		}
+		if len(m.submitted) != 1 || m.submitted[0].UserId != "1" || m.submitted[0].Text != "hello" {
+			t.Errorf("%s: submitted %+v, want hello from user 1", tt.name, m.submitted)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kjk/betterguid"
+	"github.com/riri95500/go-chat/logging"
+)
+
+// RequestIDHeader carries the correlation ID of a request, from the client or generated
+const RequestIDHeader = "X-Request-ID"
+
+// Les IDs reçus du client sont réécrits dans les logs et les réponses, on les garde courts et simples
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+/*
+RequestIDMiddleware gives every request an ID, the one of the X-Request-ID header
+when it is valid or a new one, and sends it back in the response.
+The request context then carries a logger adding the ID to every line, see requestLogger.
+
+Parameters:
+  - logger (*slog.Logger): the logger of the application
+*/
+func RequestIDMiddleware(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestId.MatchString(id) {
+			id = betterguid.New()
+		}
+
+		c.Set("requestId", id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(logging.WithLogger(c.Request.Context(), logger.With("request_id", id)))
+
+		c.Next()
+	}
+}
+
+// AccessLogMiddleware writes a line per request once it is handled, it must run after RequestIDMiddleware
+func AccessLogMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		} else if status >= 400 {
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("ip", c.ClientIP()),
+			slog.Int("size", c.Writer.Size()),
+		}
+		if user, err := currentUser(c); err == nil {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(user.ID)))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		requestLogger(c).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// requestLogger returns the logger of the request, with its ID
+func requestLogger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c.Request.Context())
+}
+
+// logError logs an error met while handling the request, along with the handler
+func logError(c *gin.Context, err error) {
+	requestLogger(c).WarnContext(c.Request.Context(), "request failed", "handler", c.HandlerName(), "error", err)
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		kept   bool
+	}{
+		{"no ID", "", false},
+		{"valid ID", "req-42.a_b", true},
+		{"ID with spaces", "req 42", false},
+		{"ID with a new line", "req\n42", false},
+		{"too long ID", strings.Repeat("a", 65), false},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		router := gin.New()
+		router.GET("/ping", RequestIDMiddleware(slog.New(slog.NewTextHandler(out, nil))), func(c *gin.Context) {
+			requestLogger(c).Info("handled")
+			c.Status(200)
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		r.Header.Set(RequestIDHeader, tt.header)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		id := w.Header().Get(RequestIDHeader)
+		if tt.kept && id != tt.header || !tt.kept && (id == "" || id == tt.header) {
+			t.Errorf("%s: response ID %q", tt.name, id)
+		}
+		if !strings.Contains(out.String(), "request_id="+id) {
+			t.Errorf("%s: logged %q, want the ID %s", tt.name, out, id)
+		}
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	tests := []struct {
+		status int
+		user   *model.User
+		want   []string
+	}{
+		{200, userWithId(4, false), []string{"level=INFO", "status=200", "user_id=4", "route=/rooms/:roomid"}},
+		{404, nil, []string{"level=WARN", "status=404"}},
+		{503, nil, []string{"level=ERROR", "status=503"}},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		router := gin.New()
+		router.Use(RequestIDMiddleware(slog.New(slog.NewTextHandler(out, nil))), AccessLogMiddleware())
+		router.GET("/rooms/:roomid", withUser(tt.user), func(c *gin.Context) {
+			c.Status(tt.status)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/rooms/general", nil))
+		for _, part := range append(tt.want, "msg=request", "path=/rooms/general", "request_id=") {
+			if !strings.Contains(out.String(), part) {
+				t.Errorf("status %d: logged %q, want %s", tt.status, out, part)
+			}
+		}
+		if tt.user == nil && strings.Contains(out.String(), "user_id") {
+			t.Errorf("status %d: anonymous request logged with a user", tt.status)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/metrics"
+)
+
+const (
+	authLoginSuccess   = "login_success"
+	authLoginFailure   = "login_failure"
+	authRefreshUsed    = "refresh_used"
+	authRefreshFailure = "refresh_failure"
+)
+
+var (
+	httpRequestDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Duration of the HTTP requests, streams included, by route and status.", nil, "method", "route", "status")
+	authOutcomes = metrics.NewCounterVec("chat_auth_total",
+		"Outcomes of the logins and of the refresh token uses.", "outcome")
+)
+
+// Les séries existent dès le démarrage, à 0
+func init() {
+	for _, outcome := range []string{authLoginSuccess, authLoginFailure, authRefreshUsed, authRefreshFailure} {
+		authOutcomes.With(outcome)
+	}
+}
+
+/*
+MetricsMiddleware times every request. Requests are labelled with their route
+pattern, not their path, so that room and user ids do not make new series.
+*/
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequestDuration.With(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
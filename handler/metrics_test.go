@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/metrics"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// metricValue scrapes the default registry for the value of a series, -1 when it is absent
+func metricValue(t *testing.T, series string) float64 {
+	t.Helper()
+	w := httptest.NewRecorder()
+	metrics.Default.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+	}
+	return -1
+}
+
+func TestMetricsMiddlewareLabelsRoutes(t *testing.T) {
+	router := gin.New()
+	router.Use(MetricsMiddleware())
+	router.GET("/metrics-test/:roomid", func(c *gin.Context) {
+		c.Status(204)
+	})
+
+	for _, path := range []string{"/metrics-test/general", "/metrics-test/random", "/metrics-test-missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := metricValue(t, `http_request_duration_seconds_count{method="GET",route="/metrics-test/:roomid",status="204"}`); got != 2 {
+		t.Errorf("%v requests counted on the route pattern, want 2", got)
+	}
+	if got := metricValue(t, `http_request_duration_seconds_count{method="GET",route="unmatched",status="404"}`); got < 1 {
+		t.Errorf("unmatched request not counted")
+	}
+	if got := metricValue(t, `http_request_duration_seconds_count{method="GET",route="/metrics-test/general",status="204"}`); got != -1 {
+		t.Errorf("series made from the path of a request")
+	}
+}
+
+func TestFailedLoginsCounted(t *testing.T) {
+	if got := metricValue(t, `chat_auth_total{outcome="refresh_used"}`); got < 0 {
+		t.Error("auth outcomes not exposed before their first use")
+	}
+
+	db, _ := fakedb.Open(t, nil)
+	audit := &auditRecorder{}
+	h := NewAuthHandler(nil, service.NewUserService(db), audit, &config.Config{})
+	router := gin.New()
+	router.POST("/login", h.Login)
+
+	before := metricValue(t, `chat_auth_total{outcome="login_failure"}`)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"email":"nobody@example.com","password":"secret"}`)))
+	if w.Code == 200 {
+		t.Fatal("unknown email logged in")
+	}
+	if got := metricValue(t, `chat_auth_total{outcome="login_failure"}`); got != before+1 {
+		t.Errorf("%v failed logins counted, want %v", got, before+1)
+	}
+	events := audit.recorded()
+	if len(events) != 1 || events[0].Action != model.AuditLoginFailed || events[0].ActorId != nil {
+		t.Errorf("audited %+v, want a failed login without actor", events)
+	}
+}
@@ -0,0 +1,655 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+	"gorm.io/gorm"
+)
+
+type ModerationHandler struct {
+	roomManager       service.Manager
+	roomService       *service.RoomService
+	messageService    *service.MessageService
+	moderationService *service.ModerationService
+	reviewService     *service.ReviewService
+	reportService     *service.ReportService
+}
+
+func NewModerationHandler(roomManager service.Manager, roomService *service.RoomService, messageService *service.MessageService, moderationService *service.ModerationService, reviewService *service.ReviewService, reportService *service.ReportService) *ModerationHandler {
+	return &ModerationHandler{
+		roomManager:       roomManager,
+		roomService:       roomService,
+		messageService:    messageService,
+		moderationService: moderationService,
+		reviewService:     reviewService,
+		reportService:     reportService,
+	}
+}
+
+type ModerationDTO struct {
+	// Duration in seconds of a ban or a mute, 0 for no end
+	Duration int    `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+type SlowModeDTO struct {
+	// Minimum delay in seconds between two messages of a user, 0 to disable slow mode
+	Interval int `json:"interval"`
+}
+
+/*
+ModeratorMiddleware rejects the requests of users who are neither an owner or a
+moderator of the room nor an administrator. It must run after AuthMiddleware.
+*/
+func (h *ModerationHandler) ModeratorMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !user.Admin && !h.roomService.IsModerator(c.Param("roomid"), user.ID) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": "only the moderators of the room can do this",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+OwnerMiddleware rejects the requests of users who are neither the owner of the
+room nor an administrator. It must run after AuthMiddleware.
+*/
+func (h *ModerationHandler) OwnerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !user.Admin && !h.roomService.IsOwner(c.Param("roomid"), user.ID) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": "only the owner of the room can do this",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Kick closes the streams of a user in the room, the user can come back right away
+func (h *ModerationHandler) Kick(c *gin.Context) {
+	h.moderate(c, model.ModerationKick)
+}
+
+// Ban kicks a user out of the room and prevents them from coming back, for a duration or for good
+func (h *ModerationHandler) Ban(c *gin.Context) {
+	h.moderate(c, model.ModerationBan)
+}
+
+// Unban lifts the ban of a user
+func (h *ModerationHandler) Unban(c *gin.Context) {
+	h.moderate(c, model.ModerationUnban)
+}
+
+// Mute makes the room read-only for a user, for a duration or for good
+func (h *ModerationHandler) Mute(c *gin.Context) {
+	h.moderate(c, model.ModerationMute)
+}
+
+// Unmute lifts the mute of a user
+func (h *ModerationHandler) Unmute(c *gin.Context) {
+	h.moderate(c, model.ModerationUnmute)
+}
+
+/*
+moderate applies an action of the authenticated moderator on the user given in the path.
+The owner and the moderators of the room cannot be moderated.
+
+Errors:
+  - 400 Bad Request: if the user id or the body is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user targeted is a moderator of the room, or the moderator themselves
+*/
+func (h *ModerationHandler) moderate(c *gin.Context, action string) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	targetId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil || targetId <= 0 {
+		c.JSON(400, gin.H{
+			"error": "invalid user id",
+		})
+		return
+	}
+
+	data := &ModerationDTO{}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(data); err != nil {
+			logError(c, err)
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+	if data.Duration < 0 {
+		c.JSON(400, gin.H{
+			"error": service.ErrInvalidDuration.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if uint(targetId) == user.ID || h.roomService.IsModerator(roomid, uint(targetId)) {
+		c.JSON(403, gin.H{
+			"error": service.ErrCannotModerate.Error(),
+		})
+		return
+	}
+
+	moderation := &service.Moderation{
+		Action:      action,
+		RoomId:      roomid,
+		ModeratorId: userKey(user),
+		UserId:      strconv.Itoa(targetId),
+		Reason:      data.Reason,
+	}
+	if data.Duration > 0 && (action == model.ModerationBan || action == model.ModerationMute) {
+		moderation.Until = time.Now().Add(time.Duration(data.Duration) * time.Second)
+	}
+
+	h.apply(c, moderation)
+}
+
+// GrantModerator makes a user a moderator of the room
+func (h *ModerationHandler) GrantModerator(c *gin.Context) {
+	h.changeRole(c, model.ModerationGrantModerator)
+}
+
+// RevokeModerator makes a moderator of the room a simple member again
+func (h *ModerationHandler) RevokeModerator(c *gin.Context) {
+	h.changeRole(c, model.ModerationRevokeModerator)
+}
+
+// SetOwner gives the room to a user, the former owner stays a moderator
+func (h *ModerationHandler) SetOwner(c *gin.Context) {
+	h.changeRole(c, model.ModerationSetOwner)
+}
+
+/*
+changeRole changes the role in the room of the user given in the path.
+Only public rooms have roles, and the owner keeps theirs until another owner is set.
+
+Errors:
+  - 400 Bad Request: if the user id is invalid or the room is private
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the role of the owner would be taken away
+*/
+func (h *ModerationHandler) changeRole(c *gin.Context, action string) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	targetId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil || targetId <= 0 {
+		c.JSON(400, gin.H{
+			"error": "invalid user id",
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if service.IsPrivateRoomId(roomid) {
+		c.JSON(400, gin.H{
+			"error": service.ErrNoRoles.Error(),
+		})
+		return
+	}
+	if action != model.ModerationSetOwner && h.roomService.IsOwner(roomid, uint(targetId)) {
+		c.JSON(403, gin.H{
+			"error": service.ErrCannotModerate.Error(),
+		})
+		return
+	}
+
+	h.apply(c, &service.Moderation{
+		Action:      action,
+		RoomId:      roomid,
+		ModeratorId: userKey(user),
+		UserId:      strconv.Itoa(targetId),
+	})
+}
+
+/*
+SlowMode enables, changes or disables the slow mode of the room.
+
+Errors:
+  - 400 Bad Request: if the body is invalid
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *ModerationHandler) SlowMode(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &SlowModeDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if data.Interval < 0 {
+		c.JSON(400, gin.H{
+			"error": service.ErrInvalidDuration.Error(),
+		})
+		return
+	}
+
+	h.apply(c, &service.Moderation{
+		Action:      model.ModerationSlowMode,
+		RoomId:      c.Param("roomid"),
+		ModeratorId: userKey(user),
+		SlowMode:    time.Duration(data.Interval) * time.Second,
+	})
+}
+
+// apply records the action in the moderation log then applies it to the running room
+func (h *ModerationHandler) apply(c *gin.Context, moderation *service.Moderation) {
+	entry, err := h.moderationService.Record(moderation)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.roomManager.Moderate(moderation)
+
+	c.JSON(200, entry)
+}
+
+/*
+GetLog returns the moderation log of the room, most recent first.
+
+Query parameters:
+  - before (int): only entries older than this entry ID are returned
+  - limit (int): the maximum number of entries, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+*/
+func (h *ModerationHandler) GetLog(c *gin.Context) {
+	before, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	entries, err := h.moderationService.GetLog(c.Param("roomid"), before, limit)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, entries)
+}
+
+// GetRestrictions returns the bans and mutes in force in the room
+func (h *ModerationHandler) GetRestrictions(c *gin.Context) {
+	restrictions, err := h.moderationService.GetRestrictions(c.Param("roomid"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, restrictions)
+}
+
+/*
+GetFlagged returns the messages of the room flagged by the content filters, most recent first.
+
+Query parameters:
+  - status (string): pending, approved or removed, all of them when absent
+  - before (int): only entries older than this entry ID are returned
+  - limit (int): the maximum number of entries, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+*/
+func (h *ModerationHandler) GetFlagged(c *gin.Context) {
+	before, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	flagged, err := h.reviewService.GetFlagged(c.Param("roomid"), c.Query("status"), before, limit)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, flagged)
+}
+
+// ApproveFlagged closes the review of a flagged message, leaving the message as it is
+func (h *ModerationHandler) ApproveFlagged(c *gin.Context) {
+	h.review(c, model.ReviewApproved)
+}
+
+// RemoveFlagged closes the review of a flagged message by deleting the message
+func (h *ModerationHandler) RemoveFlagged(c *gin.Context) {
+	h.review(c, model.ReviewRemoved)
+}
+
+/*
+review records the decision of the authenticated moderator on a flagged message.
+A removed message is deleted and a message.deleted event is broadcast to the room.
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if there is no such flagged message in the room
+  - 409 Conflict: if the message was already reviewed
+*/
+func (h *ModerationHandler) review(c *gin.Context, status string) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": service.ErrInvalidMessageId.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	flagged, err := h.reviewService.Review(roomid, id, user.ID, status)
+	if err != nil {
+		code := messageErrorStatus(err)
+		if errors.Is(err, service.ErrAlreadyReviewed) {
+			code = 409
+		}
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if status == model.ReviewRemoved {
+		if err := h.deleteMessage(roomid, flagged.MessageId, user.ID); err != nil {
+			logError(c, err)
+			c.JSON(messageErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		moderation := &service.Moderation{
+			Action:      model.ModerationDeleteMessage,
+			RoomId:      roomid,
+			ModeratorId: userKey(user),
+			UserId:      flagged.UserId,
+			MessageId:   strconv.Itoa(int(flagged.MessageId)),
+			Reason:      "flagged: " + flagged.Reasons,
+		}
+		if _, err := h.moderationService.Record(moderation); err != nil {
+			logError(c, err)
+		}
+		h.roomManager.Moderate(moderation)
+	}
+
+	c.JSON(200, flagged)
+}
+
+// deleteMessage deletes a message on behalf of a moderator and tells the room, a message already deleted is ignored
+func (h *ModerationHandler) deleteMessage(roomid string, messageId uint, moderatorId uint) error {
+	msg, err := h.messageService.GetMessage(roomid, int(messageId))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	err = h.messageService.DeleteMessage(msg, moderatorId)
+	if err != nil {
+		return err
+	}
+
+	h.roomManager.Publish(service.NewEvent(service.EventMessageDeleted, roomid, strconv.Itoa(int(moderatorId)), service.MessageDeletedPayload{
+		MessageId: strconv.Itoa(int(msg.ID)),
+	}))
+
+	return nil
+}
+
+/*
+GetReports returns the reports of the room, most recent first.
+
+Query parameters:
+  - status (string): pending, dismissed or resolved, all of them when absent
+  - before (int): only reports older than this report ID are returned
+  - limit (int): the maximum number of reports, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+*/
+func (h *ModerationHandler) GetReports(c *gin.Context) {
+	before, limit, err := pagination(c)
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	reports, err := h.reportService.GetReports(c.Param("roomid"), c.Query("status"), before, limit)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, reports)
+}
+
+// DismissReport closes a report without acting on the message or the user
+func (h *ModerationHandler) DismissReport(c *gin.Context) {
+	h.resolve(c, model.ModerationDismissReport)
+}
+
+// DeleteReported closes a report by deleting the message reported
+func (h *ModerationHandler) DeleteReported(c *gin.Context) {
+	h.resolve(c, model.ModerationDeleteMessage)
+}
+
+// MuteReported closes a report by muting the user reported, for a duration or for good
+func (h *ModerationHandler) MuteReported(c *gin.Context) {
+	h.resolve(c, model.ModerationMute)
+}
+
+// BanReported closes a report by banning the user reported, for a duration or for good
+func (h *ModerationHandler) BanReported(c *gin.Context) {
+	h.resolve(c, model.ModerationBan)
+}
+
+/*
+resolve closes a pending report with an action of the authenticated moderator.
+The report is closed first so that two moderators cannot act on it, then the action
+is applied and written to the moderation log against the moderator.
+
+Errors:
+  - 400 Bad Request: if the id or the body is invalid, or a message is deleted for a report on a user
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user reported is a moderator of the room
+  - 404 Not Found: if there is no such report in the room
+  - 409 Conflict: if the report was already handled
+*/
+func (h *ModerationHandler) resolve(c *gin.Context, action string) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{
+			"error": "invalid report id",
+		})
+		return
+	}
+
+	data := &ModerationDTO{}
+	if c.Request.ContentLength > 0 {
+		if err := c.BindJSON(data); err != nil {
+			logError(c, err)
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+	if data.Duration < 0 {
+		c.JSON(400, gin.H{
+			"error": service.ErrInvalidDuration.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	report, err := h.reportService.GetReport(roomid, id)
+	if err != nil {
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	switch action {
+	case model.ModerationDeleteMessage:
+		if report.MessageId == nil {
+			c.JSON(400, gin.H{
+				"error": service.ErrNoMessage.Error(),
+			})
+			return
+		}
+	case model.ModerationMute, model.ModerationBan:
+		if h.roomService.IsModerator(roomid, report.UserId) {
+			c.JSON(403, gin.H{
+				"error": service.ErrCannotModerate.Error(),
+			})
+			return
+		}
+	}
+
+	status := model.ReportResolved
+	if action == model.ModerationDismissReport {
+		status = model.ReportDismissed
+	}
+	err = h.reportService.Close(report, user.ID, status, action)
+	if err != nil {
+		code := 400
+		if errors.Is(err, service.ErrReportClosed) {
+			code = 409
+		}
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	moderation := &service.Moderation{
+		Action:      action,
+		RoomId:      roomid,
+		ModeratorId: userKey(user),
+		UserId:      strconv.Itoa(int(report.UserId)),
+		Reason:      "report #" + strconv.Itoa(int(report.ID)),
+	}
+	if data.Reason != "" {
+		moderation.Reason += ": " + data.Reason
+	}
+	if data.Duration > 0 && (action == model.ModerationBan || action == model.ModerationMute) {
+		moderation.Until = time.Now().Add(time.Duration(data.Duration) * time.Second)
+	}
+
+	if action == model.ModerationDeleteMessage {
+		moderation.MessageId = strconv.Itoa(int(*report.MessageId))
+		if err := h.deleteMessage(roomid, *report.MessageId, user.ID); err != nil {
+			logError(c, err)
+			c.JSON(messageErrorStatus(err), gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+	}
+
+	_, err = h.moderationService.Record(moderation)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.roomManager.Moderate(moderation)
+
+	c.JSON(200, report)
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+)
+
+func TestRoleMiddlewaresLetAdministratorsIn(t *testing.T) {
+	h := &ModerationHandler{}
+	middlewares := map[string]gin.HandlerFunc{
+		"moderator": h.ModeratorMiddleware(),
+		"owner":     h.OwnerMiddleware(),
+	}
+	tests := []struct {
+		name string
+		user *model.User
+		want int
+	}{
+		{"anonymous", nil, 401},
+		{"an administrator", userWithId(1, true), 200},
+	}
+	for role, middleware := range middlewares {
+		for _, tt := range tests {
+			router := gin.New()
+			router.POST("/rooms/:roomid", withUser(tt.user), middleware, func(c *gin.Context) {
+				c.Status(200)
+			})
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rooms/general", nil))
+			if w.Code != tt.want {
+				t.Errorf("%s middleware, %s: status %d, want %d", role, tt.name, w.Code, tt.want)
+			}
+		}
+	}
+}
+
+func TestChangeRoleRejectsInvalidRequests(t *testing.T) {
+	h := &ModerationHandler{}
+	tests := []struct {
+		name   string
+		user   *model.User
+		roomid string
+		userId string
+		want   int
+	}{
+		{"anonymous", nil, "general", "2", 401},
+		{"invalid user id", userWithId(1, true), "general", "abc", 400},
+		{"negative user id", userWithId(1, true), "general", "-2", 400},
+		{"direct conversation", userWithId(1, true), "dm:1-2", "2", 400},
+		{"user room", userWithId(1, true), "user:2", "2", 400},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.PUT("/rooms/:roomid/moderators/:userId", withUser(tt.user), h.GrantModerator)
+		router.PUT("/rooms/:roomid/owner/:userId", withUser(tt.user), h.SetOwner)
+
+		for _, path := range []string{"/rooms/" + tt.roomid + "/moderators/" + tt.userId, "/rooms/" + tt.roomid + "/owner/" + tt.userId} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, path, nil))
+			if w.Code != tt.want {
+				t.Errorf("%s, %s: status %d, want %d", tt.name, path, w.Code, tt.want)
+			}
+		}
+	}
+}
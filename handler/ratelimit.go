@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/ratelimit"
+)
+
+/*
+RateLimitMiddleware limits the requests of a route with the token buckets of the rule:
+one per client IP, and one per authenticated user in each room when the route has a
+user, so it must run after AuthMiddleware on authenticated routes. A request takes a
+token from every bucket or from none, a request refused by one bucket spends nothing.
+Refused requests get a 429 with a Retry-After header. The requests go through
+when the limiter fails, a broken store must not stop the chat.
+*/
+func RateLimitMiddleware(limiter ratelimit.Limiter, rule ratelimit.Rule) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		buckets := []ratelimit.Bucket{
+			{Key: rule.Name + ":ip:" + c.ClientIP(), Limit: rule.IP},
+		}
+		if user, err := currentUser(c); err == nil {
+			roomid := c.Param("roomid")
+			buckets = append(buckets, ratelimit.Bucket{Key: rule.Name + ":user:" + userKey(user) + ":room:" + roomid, Limit: rule.UserLimit(roomid)})
+		}
+
+		allowed, retryAfter, err := limiter.Allow(c.Request.Context(), buckets...)
+		if err != nil {
+			requestLogger(c).ErrorContext(c.Request.Context(), "rate limiter unavailable, request let through", "rule", rule.Name, "error", err)
+			c.Next()
+			return
+		}
+		if !allowed {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			if seconds < 1 {
+				seconds = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(seconds))
+			c.AbortWithStatusJSON(429, gin.H{
+				"error":      "too many requests, retry in " + (time.Duration(seconds) * time.Second).String(),
+				"retryAfter": seconds,
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/ratelimit"
+)
+
+// brokenLimiter fails like a limiter whose store is down
+type brokenLimiter struct{}
+
+func (brokenLimiter) Allow(ctx context.Context, buckets ...ratelimit.Bucket) (bool, time.Duration, error) {
+	return false, 0, errors.New("database is down")
+}
+
+func limitedRouter(limiter ratelimit.Limiter, user *model.User) *gin.Engine {
+	rule := ratelimit.Rule{
+		Name: "messages",
+		User: ratelimit.Limit{Rate: 1.0 / 60, Burst: 1},
+		IP:   ratelimit.Limit{Rate: 1.0 / 60, Burst: 2},
+	}
+	router := gin.New()
+	router.POST("/rooms/:roomid/messages", withUser(user), RateLimitMiddleware(limiter, rule), func(c *gin.Context) {
+		c.Status(200)
+	})
+	return router
+}
+
+func post(router *gin.Engine, roomid string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/rooms/"+roomid+"/messages", nil))
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter := ratelimit.NewMemoryLimiter()
+	router := limitedRouter(limiter, userWithId(1, false))
+
+	if w := post(router, "general"); w.Code != 200 {
+		t.Fatalf("first message: status %d", w.Code)
+	}
+	w := post(router, "general")
+	if w.Code != 429 {
+		t.Fatalf("second message in the room: status %d, want 429", w.Code)
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "60" {
+		t.Errorf("Retry-After %q, want 60", retry)
+	}
+
+	// Refusé par le bucket de l'utilisateur, le message n'a pas consommé celui de l'IP
+	if w := post(router, "random"); w.Code != 200 {
+		t.Errorf("message in another room: status %d, the IP quota was spent by a refused request", w.Code)
+	}
+	if w := post(router, "other"); w.Code != 429 {
+		t.Errorf("third message from the IP: status %d, want 429", w.Code)
+	}
+}
+
+func TestRateLimitMiddlewareFailsOpen(t *testing.T) {
+	router := limitedRouter(brokenLimiter{}, userWithId(1, false))
+	for i := 0; i < 3; i++ {
+		if w := post(router, "general"); w.Code != 200 {
+			t.Fatalf("status %d with a broken limiter, want the request let through", w.Code)
+		}
+	}
+}
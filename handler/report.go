@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+	"gorm.io/gorm"
+)
+
+type ReportHandler struct {
+	reportService *service.ReportService
+	roomService   *service.RoomService
+}
+
+func NewReportHandler(reportService *service.ReportService, roomService *service.RoomService) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+		roomService:   roomService,
+	}
+}
+
+type ReportDTO struct {
+	RoomId string `json:"roomId"`
+	// Message reported, 0 when a user is reported
+	MessageId uint `json:"messageId"`
+	// User reported, ignored when a message is reported
+	UserId uint   `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+/*
+CreateReport reports a message or a user of a room to its moderators.
+
+Errors:
+  - 400 Bad Request: if the body is invalid, or neither a message nor another user is reported
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user cannot access the room
+  - 404 Not Found: if the message or the user does not exist
+  - 409 Conflict: if the user already has a pending report on the same message or user
+*/
+func (h *ReportHandler) CreateReport(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &ReportDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if data.RoomId != "" && !h.roomService.CanAccess(data.RoomId, user.ID) {
+		c.JSON(403, gin.H{
+			"error": service.ErrNotParticipant.Error(),
+		})
+		return
+	}
+
+	report := &model.Report{
+		ReporterId: user.ID,
+		RoomId:     data.RoomId,
+		UserId:     data.UserId,
+		Reason:     data.Reason,
+	}
+	if data.MessageId != 0 {
+		report.MessageId = &data.MessageId
+	}
+
+	err = h.reportService.CreateReport(report)
+	if err != nil {
+		code := 400
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			code = 404
+		} else if errors.Is(err, service.ErrAlreadyReported) {
+			code = 409
+		}
+		c.JSON(code, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(201, report)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// reportDB holds message 7 of user 1 in general, report 4 on user 3, and pending reports of the reporter
+func reportDB(t *testing.T, pending int64) *service.ReportService {
+	t.Helper()
+	db, _ := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "count(*)"):
+			return fakedb.Rows([]string{"count(*)"}, pending)
+		case strings.Contains(query, "FROM `messages`") && args[1] == int64(7):
+			return fakedb.Rows([]string{"id", "room_id", "user_id", "text"}, int64(7), "general", "1", "spam")
+		case strings.Contains(query, "FROM `reports`") && args[0] == "general" && args[1] == int64(4):
+			return fakedb.Rows([]string{"id", "reporter_id", "room_id", "user_id", "status"}, int64(4), int64(2), "general", int64(3), model.ReportPending)
+		}
+		return nil, nil
+	})
+	return service.NewReportService(db)
+}
+
+func TestCreateReportStatuses(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *model.User
+		body    string
+		pending int64
+		want    int
+	}{
+		{"anonymous", nil, `{"roomId":"general","messageId":7}`, 0, 401},
+		{"invalid body", userWithId(2, false), `{`, 0, 400},
+		{"nothing reported", userWithId(2, false), `{"roomId":"general"}`, 0, 400},
+		{"own message", userWithId(1, false), `{"roomId":"general","messageId":7}`, 0, 400},
+		{"unknown message", userWithId(2, false), `{"roomId":"general","messageId":8}`, 0, 404},
+		{"already reported", userWithId(2, false), `{"roomId":"general","messageId":7}`, 1, 409},
+		{"message", userWithId(2, false), `{"roomId":"general","messageId":7,"reason":"spam"}`, 0, 201},
+	}
+	for _, tt := range tests {
+		h := NewReportHandler(reportDB(t, tt.pending), nil)
+		router := gin.New()
+		router.POST("/reports", withUser(tt.user), h.CreateReport)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/reports", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+}
+
+func TestResolveRejectsInvalidRequests(t *testing.T) {
+	tests := []struct {
+		name string
+		user *model.User
+		path string
+		body string
+		want int
+	}{
+		{"anonymous", nil, "/rooms/general/reports/4/dismiss", "", 401},
+		{"invalid report id", userWithId(9, true), "/rooms/general/reports/abc/dismiss", "", 400},
+		{"negative duration", userWithId(9, true), "/rooms/general/reports/4/mute", `{"duration":-1}`, 400},
+		{"unknown report", userWithId(9, true), "/rooms/general/reports/5/dismiss", "", 404},
+		{"report in another room", userWithId(9, true), "/rooms/random/reports/4/dismiss", "", 404},
+		{"deleting for a report on a user", userWithId(9, true), "/rooms/general/reports/4/delete", "", 400},
+	}
+	for _, tt := range tests {
+		h := &ModerationHandler{reportService: reportDB(t, 0)}
+		router := gin.New()
+		router.POST("/rooms/:roomid/reports/:id/dismiss", withUser(tt.user), h.DismissReport)
+		router.POST("/rooms/:roomid/reports/:id/delete", withUser(tt.user), h.DeleteReported)
+		router.POST("/rooms/:roomid/reports/:id/mute", withUser(tt.user), h.MuteReported)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,841 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+	"gorm.io/gorm"
+)
+
+type RoomHandler struct {
+	roomManager    service.Manager
+	userService    *service.UserService
+	roomService    *service.RoomService
+	messageService *service.MessageService
+}
+
+func NewRoomHandler(roomManager service.Manager, userService *service.UserService, roomService *service.RoomService, messageService *service.MessageService) *RoomHandler {
+	return &RoomHandler{
+		roomManager:    roomManager,
+		userService:    userService,
+		roomService:    roomService,
+		messageService: messageService,
+	}
+}
+
+// userKey is the id of the user as known by the room manager
+func userKey(user *model.User) string {
+	return strconv.Itoa(int(user.ID))
+}
+
+/*
+AccessMiddleware rejects the requests on a private room (a direct conversation)
+from users who are not members of it. It must run after AuthMiddleware.
+
+Returns:
+  - gin.HandlerFunc: A function that handles the middleware.
+*/
+func (h *RoomHandler) AccessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if !h.roomService.CanAccess(c.Param("roomid"), user.ID) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": service.ErrNotParticipant.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+PublicRoomMiddleware rejects the requests on private rooms, for the
+routes without authentication such as the ones of the HTML adapter.
+*/
+func PublicRoomMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if service.IsPrivateRoomId(c.Param("roomid")) {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": service.ErrNotParticipant.Error(),
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+/*
+Stream opens a listener in the room for the authenticated user and forwards every
+event of the room as Server-Sent Events until the client leaves.
+Every event is a service.Event envelope sent with its type as SSE event name,
+the stream ends when the room is closed.
+
+Parameters:
+  - c (*gin.Context): the context of the current HTTP request
+
+Errors:
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *RoomHandler) Stream(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if _, err := h.roomService.Join(roomid, user.ID); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.stream(c, user, []subscription{h.subscribe(c, roomid, user)}, nil)
+}
+
+// GetPresence godoc
+// @Summary      Get the online members of a room
+// @Description  get the users having the room stream open
+// @Tags         Room
+// @Produce      json
+// @Param        roomid   path      string  true  "Room ID"
+// @Success      200  {object}  UserRespone[]
+// @Failure      400  {object}  ErrorResponse
+// @Router       /rooms/{roomid}/presence [get]
+func (h *RoomHandler) GetPresence(c *gin.Context) {
+	ids := []int{}
+	for _, userid := range h.roomManager.Presence(c.Param("roomid")) {
+		id, err := strconv.Atoi(userid)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	users, err := h.userService.WithContext(c.Request.Context()).GetUsersByIds(ids)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, users)
+}
+
+type TypingDTO struct {
+	Typing bool `json:"typing"`
+}
+
+/*
+Typing signals to the room that the authenticated user started or stopped typing.
+The signal is not stored and expires on its own if it is not repeated.
+
+Parameters:
+  - c (*gin.Context): the context of the current HTTP request
+
+Errors:
+  - 400 Bad Request: if the body is not a valid TypingDTO
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *RoomHandler) Typing(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &TypingDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.roomManager.Typing(userKey(user), c.Param("roomid"), data.Typing)
+
+	c.Status(204)
+}
+
+type MessageDTO struct {
+	Text string `json:"text"`
+	// Message replied to, 0 when the message is not a reply
+	ParentId uint `json:"parentId"`
+	// Message quoted, 0 when no message is quoted
+	QuoteId uint `json:"quoteId"`
+	// Attachments uploaded beforehand in the room by the author
+	AttachmentIds []uint `json:"attachmentIds"`
+}
+
+/*
+PostMessage submits a message of the authenticated user to the room, joining the room if needed.
+
+Parameters:
+  - c (*gin.Context): the context of the current HTTP request
+
+Errors:
+  - 400 Bad Request: if the body is invalid or the message is refused by the room
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is banned or muted
+  - 422 Unprocessable Entity: if the message is rejected by a content filter, the error tells why
+  - 429 Too Many Requests: if the user sends messages faster than the slow mode of the room allows
+  - 503 Service Unavailable: if the room is too busy to broadcast the message, which is saved nonetheless
+*/
+func (h *RoomHandler) PostMessage(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &MessageDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if _, err := h.roomService.Join(roomid, user.ID); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	message := &service.Message{
+		UserId: userKey(user),
+		RoomId: roomid,
+		Text:   data.Text,
+	}
+	if data.ParentId != 0 {
+		message.ParentId = strconv.Itoa(int(data.ParentId))
+	}
+	if data.QuoteId != 0 {
+		message.QuoteId = strconv.Itoa(int(data.QuoteId))
+	}
+	for _, id := range data.AttachmentIds {
+		message.AttachmentIds = append(message.AttachmentIds, strconv.Itoa(int(id)))
+	}
+	if err := h.roomManager.SubmitMessage(c.Request.Context(), message); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, message)
+}
+
+/*
+EditMessage replaces the text of a message of the authenticated user and
+broadcasts a message.edited event to the room. The new text goes through the
+same limits and filters as a new message, and the users it newly mentions are notified.
+
+Parameters:
+  - c (*gin.Context): the context of the current HTTP request
+
+Errors:
+  - 400 Bad Request: if the id or the body is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is not the author of the message, or is banned or muted
+  - 404 Not Found: if there is no such message in the room
+  - 422 Unprocessable Entity: if the new text is rejected by a content filter, the error tells why
+*/
+func (h *RoomHandler) EditMessage(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &model.MessageUpdateDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	message := &service.Message{
+		Id:     strconv.Itoa(id),
+		UserId: userKey(user),
+		RoomId: roomid,
+		Text:   data.Text,
+	}
+	if err := h.roomManager.EditMessage(c.Request.Context(), message); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	msg, err := h.messageService.GetMessage(roomid, id)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	msg.Content = message.Content
+
+	h.roomManager.Publish(service.NewEvent(service.EventMessageEdited, roomid, userKey(user), service.MessageEditedPayload{
+		MessageId: strconv.Itoa(int(msg.ID)),
+		Text:      msg.Text,
+		Content:   msg.Content,
+		EditedAt:  *msg.EditedAt,
+	}))
+
+	c.JSON(200, msg)
+}
+
+/*
+DeleteMessage deletes a message and broadcasts a message.deleted event to the room.
+The author of the message, the moderators of the room and the administrators can delete it.
+
+Parameters:
+  - c (*gin.Context): the context of the current HTTP request
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is neither the author nor a moderator
+  - 404 Not Found: if there is no such message in the room
+*/
+func (h *RoomHandler) DeleteMessage(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	msg, err := h.messageService.GetMessage(roomid, id)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if msg.UserId != userKey(user) && !user.Admin && !h.roomService.IsModerator(roomid, user.ID) {
+		c.JSON(403, gin.H{
+			"error": "only the author or a moderator can delete this message",
+		})
+		return
+	}
+
+	if err := h.messageService.DeleteMessage(msg, user.ID); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.roomManager.Publish(service.NewEvent(service.EventMessageDeleted, roomid, userKey(user), service.MessageDeletedPayload{
+		MessageId: strconv.Itoa(int(msg.ID)),
+	}))
+
+	c.JSON(200, gin.H{
+		"message": "Message deleted successfully",
+	})
+}
+
+// GetMessageEdits returns the previous texts of a message, oldest first
+func (h *RoomHandler) GetMessageEdits(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	msg, err := h.messageService.GetMessage(c.Param("roomid"), id)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	edits, err := h.messageService.GetEdits(msg.ID)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, edits)
+}
+
+/*
+GetMessages returns the messages of the room which are not replies, most recent first,
+with the number of replies and the reactions of each one.
+
+Query parameters:
+  - before (int): only messages older than this message ID are returned
+  - limit (int): the maximum number of messages, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+*/
+func (h *RoomHandler) GetMessages(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	before, limit, err := pagination(c)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	messages, err := h.messageService.GetMessages(c.Param("roomid"), before, limit)
+	if err == nil {
+		err = h.messageService.LoadReactions(messages, user.ID)
+	}
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, messages)
+}
+
+/*
+GetThread returns a message and all its replies, oldest first, with their reactions.
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 404 Not Found: if there is no such message in the room
+*/
+func (h *RoomHandler) GetThread(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	parent, replies, err := h.messageService.GetThread(c.Param("roomid"), id)
+	if err == nil {
+		err = h.messageService.LoadReactions(append([]*model.Message{parent}, replies...), user.ID)
+	}
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message": parent,
+		"replies": replies,
+	})
+}
+
+/*
+StreamThread streams only the events of one thread: new replies, and the
+edits, deletions and reactions of the first message and its replies.
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if there is no such message in the room
+*/
+func (h *RoomHandler) StreamThread(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	parent, replies, err := h.messageService.GetThread(roomid, id)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.stream(c, user, []subscription{h.subscribe(c, roomid, user)}, threadFilter(parent, replies))
+}
+
+// threadFilter keeps the events of the thread, the new replies join it as they arrive
+func threadFilter(parent *model.Message, replies []*model.Message) func(*service.Event) bool {
+	threadId := strconv.Itoa(int(parent.ID))
+	thread := map[string]bool{threadId: true}
+	for _, reply := range replies {
+		thread[strconv.Itoa(int(reply.ID))] = true
+	}
+
+	return func(event *service.Event) bool {
+		switch payload := event.Payload.(type) {
+		case service.MessagePayload:
+			if payload.ParentId == threadId {
+				thread[event.Id] = true
+				return true
+			}
+		case service.MessageEditedPayload:
+			return thread[payload.MessageId]
+		case service.MessageUnfurledPayload:
+			return thread[payload.MessageId]
+		case service.MessageDeletedPayload:
+			return thread[payload.MessageId]
+		case service.ReactionPayload:
+			return thread[payload.MessageId]
+		}
+		return false
+	}
+}
+
+/*
+AddReaction adds the emoji given in the path on a message, for the authenticated user,
+and broadcasts a reaction.added event to the room.
+
+Errors:
+  - 400 Bad Request: if the id or the emoji is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is banned or muted
+  - 404 Not Found: if there is no such message in the room
+  - 409 Conflict: if the user already reacted with this emoji
+*/
+func (h *RoomHandler) AddReaction(c *gin.Context) {
+	h.react(c, true)
+}
+
+/*
+RemoveReaction removes the emoji given in the path from a message, for the authenticated user,
+and broadcasts a reaction.removed event to the room.
+
+Errors:
+  - 400 Bad Request: if the id is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 403 Forbidden: if the user is banned or muted
+  - 404 Not Found: if there is no such message in the room, or the user did not react with this emoji
+*/
+func (h *RoomHandler) RemoveReaction(c *gin.Context) {
+	h.react(c, false)
+}
+
+func (h *RoomHandler) react(c *gin.Context, add bool) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	if err := h.roomManager.Admit(userKey(user), roomid); err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	msg, err := h.messageService.GetMessage(roomid, id)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	emoji := c.Param("emoji")
+	eventType := service.EventReactionAdded
+	if add {
+		err = h.messageService.AddReaction(msg, user.ID, emoji)
+	} else {
+		eventType = service.EventReactionRemoved
+		err = h.messageService.RemoveReaction(msg, user.ID, emoji)
+	}
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	h.roomManager.Publish(service.NewEvent(eventType, roomid, userKey(user), service.ReactionPayload{
+		MessageId: strconv.Itoa(int(msg.ID)),
+		Emoji:     emoji,
+	}))
+
+	c.Status(204)
+}
+
+type ReadDTO struct {
+	// Last message read, 0 for the last message of the room
+	MessageId uint `json:"messageId"`
+	// Broadcast a read receipt to the room
+	Receipt bool `json:"receipt"`
+}
+
+/*
+MarkRead moves the read marker of the authenticated user in the room and,
+if asked, broadcasts a receipt.read event to the room.
+
+Errors:
+  - 400 Bad Request: if the body is invalid
+  - 401 Unauthorized: if no user is in the context
+  - 404 Not Found: if there is no such message in the room
+*/
+func (h *RoomHandler) MarkRead(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	data := &ReadDTO{}
+	if err := c.BindJSON(data); err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomid := c.Param("roomid")
+	lastRead, err := h.roomService.MarkRead(roomid, user.ID, data.MessageId)
+	if err != nil {
+		logError(c, err)
+		c.JSON(messageErrorStatus(err), gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	if data.Receipt && lastRead != 0 {
+		h.roomManager.Publish(service.NewEvent(service.EventRead, roomid, userKey(user), service.ReadPayload{
+			MessageId: strconv.Itoa(int(lastRead)),
+		}))
+	}
+
+	c.JSON(200, gin.H{
+		"lastReadMessageId": lastRead,
+	})
+}
+
+/*
+GetRooms returns the rooms of the authenticated user, the most recently active
+first, with their number of unread messages.
+
+Errors:
+  - 400 Bad Request: if the rooms could not be retrieved
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *RoomHandler) GetRooms(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	rooms, err := h.roomService.GetUserRooms(user.ID)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, rooms)
+}
+
+/*
+GetMentions returns the messages mentioning the authenticated user, most recent first,
+so that mentions received while offline are not lost.
+
+Query parameters:
+  - before (int): only messages older than this message ID are returned
+  - limit (int): the maximum number of messages, 50 by default and at most 100
+
+Errors:
+  - 400 Bad Request: if a query parameter is invalid
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *RoomHandler) GetMentions(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	before, limit, err := pagination(c)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	messages, err := h.messageService.GetMentions(user.ID, before, limit)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(200, messages)
+}
+
+// pagination reads the before and limit query parameters, limit is 50 by default, at least 1 and at most 100
+func pagination(c *gin.Context) (int, int, error) {
+	before, err := strconv.Atoi(c.DefaultQuery("before", "0"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil {
+		return 0, 0, err
+	}
+	if limit < 1 {
+		return 0, 0, errors.New("limit must be at least 1")
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	return before, limit, nil
+}
+
+// messageErrorStatus maps the errors of the MessageService to an HTTP status
+func messageErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return 404
+	case errors.Is(err, service.ErrNotAuthor), errors.Is(err, service.ErrNotParticipant),
+		errors.Is(err, service.ErrBanned), errors.Is(err, service.ErrMuted):
+		return 403
+	case errors.Is(err, service.ErrSlowMode):
+		return 429
+	case errors.Is(err, service.ErrMessageRejected):
+		return 422
+	case errors.Is(err, service.ErrAlreadyReacted):
+		return 409
+	case errors.Is(err, service.ErrRoomOverloaded):
+		return 503
+	default:
+		return 400
+	}
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+func messageWithId(id uint) *model.Message {
+	message := &model.Message{}
+	message.ID = id
+	return message
+}
+
+func TestThreadFilter(t *testing.T) {
+	keep := threadFilter(messageWithId(1), []*model.Message{messageWithId(2)})
+
+	event := func(eventType service.EventType, id string, payload interface{}) *service.Event {
+		event := service.NewEvent(eventType, "general", "1", payload)
+		if id != "" {
+			event.Id = id
+		}
+		return event
+	}
+	// Dans l'ordre : la réponse 3 rejoint le fil avant d'être modifiée
+	tests := []struct {
+		name  string
+		event *service.Event
+		want  bool
+	}{
+		{"reply", event(service.EventMessage, "3", service.MessagePayload{ParentId: "1"}), true},
+		{"other message", event(service.EventMessage, "4", service.MessagePayload{}), false},
+		{"reply elsewhere", event(service.EventMessage, "5", service.MessagePayload{ParentId: "4"}), false},
+		{"edit of the first message", event(service.EventMessageEdited, "", service.MessageEditedPayload{MessageId: "1"}), true},
+		{"edit of a new reply", event(service.EventMessageEdited, "", service.MessageEditedPayload{MessageId: "3"}), true},
+		{"edit elsewhere", event(service.EventMessageEdited, "", service.MessageEditedPayload{MessageId: "4"}), false},
+		{"deleted reply", event(service.EventMessageDeleted, "", service.MessageDeletedPayload{MessageId: "2"}), true},
+		{"reaction on a reply", event(service.EventReactionAdded, "", service.ReactionPayload{MessageId: "2", Emoji: "👍"}), true},
+		{"reaction elsewhere", event(service.EventReactionAdded, "", service.ReactionPayload{MessageId: "5", Emoji: "👍"}), false},
+		{"typing", event(service.EventTyping, "", service.TypingPayload{Typing: true}), false},
+	}
+	for _, tt := range tests {
+		if got := keep(tt.event); got != tt.want {
+			t.Errorf("%s: kept %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWritesRefusedToRestrictedUsers(t *testing.T) {
+	m := newFakeManager()
+	m.restricted["1"] = service.ErrMuted
+	m.restricted["2"] = service.ErrBanned
+	// Refusées avant d'atteindre le MessageService, absent ici
+	h := &RoomHandler{roomManager: m}
+
+	for _, user := range []*model.User{userWithId(1, false), userWithId(2, false)} {
+		router := gin.New()
+		router.PATCH("/rooms/:roomid/messages/:id", withUser(user), h.EditMessage)
+		router.PUT("/rooms/:roomid/messages/:id/reactions/:emoji", withUser(user), h.AddReaction)
+		router.DELETE("/rooms/:roomid/messages/:id/reactions/:emoji", withUser(user), h.RemoveReaction)
+
+		for _, req := range []*http.Request{
+			httptest.NewRequest(http.MethodPatch, "/rooms/general/messages/7", strings.NewReader(`{"text": "edited"}`)),
+			httptest.NewRequest(http.MethodPut, "/rooms/general/messages/7/reactions/👍", nil),
+			httptest.NewRequest(http.MethodDelete, "/rooms/general/messages/7/reactions/👍", nil),
+		} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != 403 {
+				t.Errorf("%s %s by user %d: status %d, want 403", req.Method, req.URL.Path, user.ID, w.Code)
+			}
+		}
+	}
+}
+
+func TestPagination(t *testing.T) {
+	tests := []struct {
+		query  string
+		before int
+		limit  int
+		err    bool
+	}{
+		{"", 0, 50, false},
+		{"?before=42&limit=10", 42, 10, false},
+		{"?limit=1", 0, 1, false},
+		{"?limit=100", 0, 100, false},
+		{"?limit=500", 0, 100, false},
+		{"?limit=0", 0, 0, true},
+		{"?limit=-5", 0, 0, true},
+		{"?limit=ten", 0, 0, true},
+		{"?before=abc", 0, 0, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/messages"+tt.query, nil)
+
+		before, limit, err := pagination(c)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got %d, %d, want an error", tt.query, before, limit)
+			}
+			continue
+		}
+		if err != nil || before != tt.before || limit != tt.limit {
+			t.Errorf("%q: got %d, %d, %v, want %d, %d", tt.query, before, limit, err, tt.before, tt.limit)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+// subscription is a listener opened by a stream in one room
+type subscription struct {
+	roomid   string
+	listener chan interface{}
+}
+
+// subscribe opens a listener in the room on behalf of the user, counting them in the room presence
+func (h *RoomHandler) subscribe(c *gin.Context, roomid string, user *model.User) subscription {
+	return subscription{
+		roomid:   roomid,
+		listener: h.roomManager.OpenUserListener(roomid, userKey(user), c.ClientIP()),
+	}
+}
+
+/*
+stream forwards the events of the subscriptions accepted by filter (all of them if nil)
+to the client as Server-Sent Events, with the event type as SSE event name.
+It stops when the client leaves or when every subscription has ended, a
+subscription ends when its room is closed. A room.joined event adds the room to the stream.
+*/
+func (h *RoomHandler) stream(c *gin.Context, user *model.User, subscriptions []subscription, filter func(*service.Event) bool) {
+	events := make(chan *service.Event)
+	ended := make(chan string)
+	done := make(chan struct{})
+	defer close(done)
+
+	// Seule la boucle du stream compte les subscriptions, chacune signale sa fin sur ended
+	active := 0
+	subscribed := map[string]bool{}
+	add := func(sub subscription) {
+		subscribed[sub.roomid] = true
+		active++
+		go h.forward(sub, events, ended, done)
+	}
+	for _, sub := range subscriptions {
+		add(sub)
+	}
+	if active == 0 {
+		return
+	}
+
+	clientGone := c.Request.Context().Done()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-clientGone:
+			return false
+		case roomid := <-ended:
+			// La room pourra être ajoutée de nouveau par un room.joined
+			delete(subscribed, roomid)
+			active--
+			return active > 0
+		case e := <-events:
+			if joined, ok := e.Payload.(service.RoomJoinedPayload); ok && !subscribed[joined.RoomId] {
+				add(h.subscribe(c, joined.RoomId, user))
+			}
+			if filter == nil || filter(e) || e.Type == service.EventRoomClosed {
+				c.SSEvent(string(e.Type), e)
+			}
+			return true
+		}
+	})
+}
+
+/*
+forward sends the events of a subscription to out until done is closed or the
+subscription ends, then closes its listener and reports the end of the subscription on end.
+*/
+func (h *RoomHandler) forward(sub subscription, out chan<- *service.Event, end chan<- string, done <-chan struct{}) {
+	ended := false
+	defer func() {
+		// Le broadcaster peut être en train d'écrire dans la channel, on la vide jusqu'à sa fermeture
+		if !ended {
+			go drain(sub.listener)
+		}
+		h.roomManager.CloseListener(sub.roomid, sub.listener)
+		select {
+		case end <- sub.roomid:
+		case <-done:
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		case event, ok := <-sub.listener:
+			// Channel fermée par le manager (room pleine)
+			if !ok {
+				ended = true
+				return
+			}
+			e, ok := event.(*service.Event)
+			if !ok {
+				continue
+			}
+			// room.closed est le dernier évènement de la room
+			ended = e.Type == service.EventRoomClosed
+			select {
+			case out <- e:
+			case <-done:
+				return
+			}
+			if ended {
+				return
+			}
+		}
+	}
+}
+
+// drain reads a listener until the manager closes it or its room is closed
+func drain(listener chan interface{}) {
+	for event := range listener {
+		if e, ok := event.(*service.Event); ok && e.Type == service.EventRoomClosed {
+			return
+		}
+	}
+}
+
+/*
+StreamAll streams in a single connection the events of every room the
+authenticated user is a member of, including their direct conversations,
+and the notifications sent to the user such as mentions.
+Rooms joined through a new conversation while connected are added to the stream.
+
+Errors:
+  - 400 Bad Request: if the rooms of the user could not be retrieved
+  - 401 Unauthorized: if no user is in the context
+*/
+func (h *RoomHandler) StreamAll(c *gin.Context) {
+	user, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	roomids, err := h.roomService.GetUserRoomIds(user.ID)
+	if err != nil {
+		logError(c, err)
+		c.JSON(400, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	subscriptions := []subscription{}
+	for _, roomid := range roomids {
+		subscriptions = append(subscriptions, h.subscribe(c, roomid, user))
+	}
+
+	// La room personnelle reçoit les notifications, elle ne compte pas dans la présence
+	userRoomId := service.UserRoomId(userKey(user))
+	subscriptions = append(subscriptions, subscription{
+		roomid:   userRoomId,
+		listener: h.roomManager.OpenUserListener(userRoomId, "", c.ClientIP()),
+	})
+
+	h.stream(c, user, subscriptions, nil)
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/service"
+)
+
+/*
+fakeManager hands out the listeners of the test and records the ones closed and
+the messages submitted. The users in restricted are refused with their error.
+*/
+type fakeManager struct {
+	service.Manager
+	mu         sync.Mutex
+	listeners  map[string]chan interface{}
+	closed     map[string]bool
+	restricted map[string]error
+	submitted  []*service.Message
+}
+
+func newFakeManager() *fakeManager {
+	return &fakeManager{
+		listeners:  map[string]chan interface{}{},
+		closed:     map[string]bool{},
+		restricted: map[string]error{},
+	}
+}
+
+func (m *fakeManager) EditMessage(ctx context.Context, message *service.Message) error {
+	return m.restricted[message.UserId]
+}
+
+func (m *fakeManager) Admit(userid, roomid string) error {
+	return m.restricted[userid]
+}
+
+func (m *fakeManager) SubmitMessage(ctx context.Context, message *service.Message) error {
+	if err := m.restricted[message.UserId]; err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.submitted = append(m.submitted, message)
+	return nil
+}
+
+func (m *fakeManager) OpenUserListener(roomid, userid, ip string) chan interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	listener := make(chan interface{}, 10)
+	m.listeners[roomid] = listener
+	return listener
+}
+
+func (m *fakeManager) CloseListener(roomid string, listener chan interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed[roomid] = true
+}
+
+func (m *fakeManager) listener(t *testing.T, roomid string) chan interface{} {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		m.mu.Lock()
+		listener, ok := m.listeners[roomid]
+		m.mu.Unlock()
+		if ok {
+			return listener
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no listener opened in %s", roomid)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// streamServer serves a stream of the given rooms, as StreamAll does
+func streamServer(m *fakeManager, roomids ...string) *httptest.Server {
+	h := &RoomHandler{roomManager: m}
+	user := userWithId(1, false)
+	router := gin.New()
+	router.GET("/stream", func(c *gin.Context) {
+		subscriptions := []subscription{}
+		for _, roomid := range roomids {
+			subscriptions = append(subscriptions, h.subscribe(c, roomid, user))
+		}
+		h.stream(c, user, subscriptions, nil)
+	})
+	return httptest.NewServer(router)
+}
+
+// readStream reads the whole stream, failing the test if it does not end
+func readStream(t *testing.T, server *httptest.Server, start func()) string {
+	t.Helper()
+	body := make(chan string, 1)
+	go func() {
+		res, err := http.Get(server.URL + "/stream")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer res.Body.Close()
+		data, _ := io.ReadAll(res.Body)
+		body <- string(data)
+	}()
+	start()
+
+	select {
+	case data := <-body:
+		return data
+	case <-time.After(2 * time.Second):
+		t.Fatal("the stream did not end")
+		return ""
+	}
+}
+
+func TestStreamEndsWithItsSubscriptions(t *testing.T) {
+	m := newFakeManager()
+	server := streamServer(m, "general", "random")
+	defer server.Close()
+
+	data := readStream(t, server, func() {
+		m.listener(t, "general") <- service.NewEvent(service.EventSystem, "general", "", service.SystemPayload{Text: "hello"})
+		m.listener(t, "general") <- service.NewEvent(service.EventRoomClosed, "general", "", nil)
+		// Le stream continue tant qu'une room reste ouverte
+		time.Sleep(20 * time.Millisecond)
+		m.listener(t, "random") <- service.NewEvent(service.EventSystem, "random", "", service.SystemPayload{Text: "still there"})
+		m.listener(t, "random") <- service.NewEvent(service.EventRoomClosed, "random", "", nil)
+	})
+
+	for _, want := range []string{"hello", "still there"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("stream %q misses %q", data, want)
+		}
+	}
+	if strings.Count(data, "event:room.closed") != 2 {
+		t.Errorf("stream %q should end with both rooms closed", data)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed["general"] || !m.closed["random"] {
+		t.Errorf("listeners closed: %v", m.closed)
+	}
+}
+
+func TestStreamAddsJoinedRooms(t *testing.T) {
+	m := newFakeManager()
+	server := streamServer(m, "user:1")
+	defer server.Close()
+
+	data := readStream(t, server, func() {
+		m.listener(t, "user:1") <- service.NewEvent(service.EventRoomJoined, "user:1", "", service.RoomJoinedPayload{RoomId: "dm:1-2"})
+		m.listener(t, "user:1") <- service.NewEvent(service.EventRoomClosed, "user:1", "", nil)
+		// La room rejointe garde le stream ouvert après la fin de la première
+		time.Sleep(20 * time.Millisecond)
+		m.listener(t, "dm:1-2") <- service.NewEvent(service.EventSystem, "dm:1-2", "", service.SystemPayload{Text: "welcome"})
+		m.listener(t, "dm:1-2") <- service.NewEvent(service.EventRoomClosed, "dm:1-2", "", nil)
+	})
+
+	if !strings.Contains(data, "welcome") {
+		t.Errorf("stream %q misses the joined room", data)
+	}
+}
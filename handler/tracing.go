@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+/*
+TracingMiddleware starts the span of every request, continuing the trace of the
+client when it sends a W3C traceparent header. The services called with the request
+context, and their queries, add their spans under it.
+*/
+func TracingMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		if parent, ok := tracing.ParseTraceParent(c.GetHeader("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracing.Start(ctx, c.Request.Method+" "+route, tracing.KindServer,
+			"http.method", c.Request.Method,
+			"http.route", route,
+			"http.target", c.Request.URL.Path,
+			"client.address", c.ClientIP(),
+			"request.id", c.GetString("requestId"),
+		)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes("http.status_code", status)
+		if user, err := currentUser(c); err == nil {
+			span.SetAttributes("user.id", user.ID)
+		}
+		if status >= 500 {
+			span.RecordError(errors.New(http.StatusText(status)))
+		}
+		span.End()
+	}
+}
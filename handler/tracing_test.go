@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+// spanRecorder is a tracing.Exporter keeping the spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []*tracing.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	spans := &spanRecorder{}
+	tracer := tracing.NewTracer("test", 1, spans, slog.Default())
+	tracing.SetGlobal(tracer)
+	defer tracing.SetGlobal(tracing.NewTracer("", 0, nil, nil))
+
+	var inner tracing.SpanContext
+	router := gin.New()
+	router.Use(TracingMiddleware())
+	router.GET("/rooms/:roomid", withUser(userWithId(4, false)), func(c *gin.Context) {
+		inner = tracing.SpanFromContext(c.Request.Context()).SpanContext()
+		c.Status(503)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/rooms/general", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), r)
+	tracer.Shutdown(context.Background())
+
+	if len(spans.spans) != 1 {
+		t.Fatalf("%d spans exported, want the span of the request", len(spans.spans))
+	}
+	span := spans.spans[0]
+	if span.Name != "GET /rooms/:roomid" || span.Kind != tracing.KindServer {
+		t.Errorf("span %s of kind %d, want a server span named after the route", span.Name, span.Kind)
+	}
+	if span.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || span.Parent.String() != "00f067aa0ba902b7" {
+		t.Errorf("span %+v does not continue the trace of the client", span.Context)
+	}
+	if inner != span.Context {
+		t.Error("span not in the context of the handlers")
+	}
+	if span.Attributes["http.status_code"] != 503 || span.Attributes["user.id"] != uint(4) || span.Attributes["http.target"] != "/rooms/general" {
+		t.Errorf("attributes %v", span.Attributes)
+	}
+	if span.Error != "Service Unavailable" {
+		t.Errorf("error %q, want the status of the failed request", span.Error)
+	}
+}
@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"log"
 	"strconv"
 	"time"
 
@@ -12,11 +11,38 @@ import (
 
 type UserHandler struct {
 	userService *service.UserService
+	auditLogger service.AuditLogger
 }
 
-func NewUserHandler(userService *service.UserService) *UserHandler {
+func NewUserHandler(userService *service.UserService, auditLogger service.AuditLogger) *UserHandler {
 	return &UserHandler{
 		userService: userService,
+		auditLogger: auditLogger,
+	}
+}
+
+/*
+SelfOrAdminMiddleware rejects the requests on a user, given by the id path parameter,
+from anyone but the user themselves and the administrators. It must run after AuthMiddleware.
+*/
+func SelfOrAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := currentUser(c)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		if c.Param("id") != userKey(user) && !user.Admin {
+			c.AbortWithStatusJSON(403, gin.H{
+				"error": "only the user or an administrator can do this",
+			})
+			return
+		}
+
+		c.Next()
 	}
 }
 
@@ -40,6 +66,7 @@ type ErrorResponse struct {
 // @Param        id   path      int  true  "User ID"
 // @Success      200  {object}  UserRespone
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /user/{id} [get]
 /*
@@ -50,21 +77,29 @@ Parameters:
   - h (*UserHandler): the handler that handles user-related requests
 
 Errors:
+  - 401 Unauthorized: if no user is authenticated
   - 400 Bad Request: if the parameter id cannot be converted to an integer, or if there is an error retrieving the user
 */
 func (h *UserHandler) GetUser(c *gin.Context) {
+	if _, err := currentUser(c); err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	user, err := h.userService.GetUser(id)
+	user, err := h.userService.WithContext(c.Request.Context()).GetUser(id)
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
@@ -82,12 +117,20 @@ func (h *UserHandler) GetUser(c *gin.Context) {
 // @Produce      json
 // @Success      200  {object}  UserRespone[]
 // @Failure      400  {object}  ErrorResponse
+// @Failure      401  {object}  ErrorResponse
 // @Failure      500  {object}  ErrorResponse
 // @Router       /user [get]
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	users, err := h.userService.GetUsers()
+	if _, err := currentUser(c); err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	users, err := h.userService.WithContext(c.Request.Context()).GetUsers()
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
@@ -101,16 +144,16 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 	data := &model.UserCreateDTO{}
 
 	if err := c.BindJSON(data); err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	user, err := h.userService.CreateUser(data)
+	user, err := h.userService.WithContext(c.Request.Context()).CreateUser(data)
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
@@ -121,9 +164,17 @@ func (h *UserHandler) CreateUser(c *gin.Context) {
 }
 
 func (h *UserHandler) UpdateUser(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
@@ -132,44 +183,61 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 
 	data := &model.UserUpdateDTO{}
 	if err := c.BindJSON(data); err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	user, err := h.userService.UpdateUser(id, data)
+	user, fields, err := h.userService.WithContext(c.Request.Context()).UpdateUser(id, data)
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	// on garde les champs modifiés, pas leurs valeurs, et rien quand aucun n'a changé
+	if len(fields) > 0 {
+		h.auditLogger.Audit(auditEvent(c, model.AuditUserUpdated, actor, userTarget(user.ID), model.AuditMetadata{
+			"fields": fields,
+		}))
+	}
+
 	c.JSON(200, user)
 }
 
 func (h *UserHandler) DeleteUser(c *gin.Context) {
+	actor, err := currentUser(c)
+	if err != nil {
+		c.JSON(401, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
 	id, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	err = h.userService.DeleteUser(id)
+	err = h.userService.WithContext(c.Request.Context()).DeleteUser(id)
 	if err != nil {
-		log.Println(err)
+		logError(c, err)
 		c.JSON(400, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	h.auditLogger.Audit(auditEvent(c, model.AuditUserDeleted, actor, userTarget(uint(id)), nil))
+
 	c.JSON(200, gin.H{
 		"message": "User deleted successfully",
 	})
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/service"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// withUser stands in for AuthMiddleware, putting user in the context when it is not nil
+func withUser(user *model.User) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user != nil {
+			c.Set("user", user)
+		}
+		c.Next()
+	}
+}
+
+func TestSelfOrAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		user *model.User
+		id   string
+		want int
+	}{
+		{"anonymous", nil, "1", 401},
+		{"the user themselves", userWithId(1, false), "1", 200},
+		{"another user", userWithId(2, false), "1", 403},
+		{"an administrator", userWithId(2, true), "1", 200},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.PUT("/user/:id", withUser(tt.user), SelfOrAdminMiddleware(), func(c *gin.Context) {
+			c.Status(200)
+		})
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/user/"+tt.id, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestUserChangesAreAuditedAgainstTheirActor(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   *model.User
+		method string
+		body   string
+		want   int
+		action string
+	}{
+		{"anonymous update", nil, http.MethodPut, `{"email":"bob@example.com"}`, 401, ""},
+		{"anonymous deletion", nil, http.MethodDelete, "", 401, ""},
+		{"deletion", userWithId(2, true), http.MethodDelete, "", 200, model.AuditUserDeleted},
+	}
+	for _, tt := range tests {
+		db, fake := fakedb.Open(t, nil)
+		audit := &auditRecorder{}
+		h := NewUserHandler(service.NewUserService(db), audit)
+		router := gin.New()
+		router.PUT("/user/:id", withUser(tt.user), h.UpdateUser)
+		router.DELETE("/user/:id", withUser(tt.user), h.DeleteUser)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(tt.method, "/user/1", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, w.Code, tt.want, w.Body.String())
+		}
+
+		events := audit.recorded()
+		if tt.action == "" {
+			if len(events) > 0 || fake.Ran("`users`") {
+				t.Errorf("%s: user changed or audited without an actor", tt.name)
+			}
+			continue
+		}
+		if len(events) != 1 || events[0].Action != tt.action || events[0].ActorId == nil || *events[0].ActorId != 2 || events[0].Target != "user:1" {
+			t.Errorf("%s: audited %+v, want %s of user:1 by 2", tt.name, events, tt.action)
+		}
+	}
+}
+
+func TestUserUpdateAuditsTheChangedFields(t *testing.T) {
+	tests := []struct {
+		body   string
+		fields []string
+	}{
+		{`{"email":"alice@example.com"}`, nil},
+		{`{"email":"alice@example.com","username":"alice"}`, nil},
+		{`{"email":"bob@example.com","username":"alice"}`, []string{"email"}},
+		{`{"email":"alice@example.com","username":"bob"}`, []string{"username"}},
+		{`{"email":"bob@example.com","username":"bob"}`, []string{"email", "username"}},
+	}
+	for _, tt := range tests {
+		db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			if strings.Contains(query, "FROM `users`") {
+				return []string{"id", "email", "username"}, [][]driver.Value{{int64(1), "alice@example.com", "alice"}}
+			}
+			return nil, nil
+		})
+		audit := &auditRecorder{}
+		h := NewUserHandler(service.NewUserService(db), audit)
+		router := gin.New()
+		router.PUT("/user/:id", withUser(userWithId(1, false)), h.UpdateUser)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/user/1", strings.NewReader(tt.body)))
+		if w.Code != 200 {
+			t.Errorf("%s: status %d: %s", tt.body, w.Code, w.Body.String())
+		}
+
+		events := audit.recorded()
+		if tt.fields == nil {
+			if len(events) > 0 || fake.Ran("UPDATE") {
+				t.Errorf("%s: nothing changed but saved or audited %+v", tt.body, events)
+			}
+			continue
+		}
+		if len(events) != 1 || !reflect.DeepEqual(events[0].Metadata["fields"], tt.fields) {
+			t.Errorf("%s: audited %+v, want the fields %v", tt.body, events, tt.fields)
+		}
+	}
+}
+
+func userWithId(id uint, admin bool) *model.User {
+	user := &model.User{Admin: admin}
+	user.ID = id
+	return user
+}
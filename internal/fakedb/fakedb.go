@@ -0,0 +1,128 @@
+/*
+Package fakedb is a database/sql driver for the tests: it answers the queries
+of GORM with a function of the test and records every statement run, so that
+the services can be tested without MySQL.
+*/
+package fakedb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Answer gives the columns and rows returned to a query, no rows when nil
+type Answer func(query string, args []driver.Value) ([]string, [][]driver.Value)
+
+// DB records the statements run through the *gorm.DB returned by Open
+type DB struct {
+	mu         sync.Mutex
+	answer     Answer
+	statements []string
+}
+
+// Open returns a *gorm.DB speaking MySQL to a DB answering with answer, which may be nil
+func Open(t *testing.T, answer Answer) (*gorm.DB, *DB) {
+	t.Helper()
+	fake := &DB{answer: answer}
+	db, err := gorm.Open(mysql.New(mysql.Config{
+		Conn:                      sql.OpenDB(fake),
+		SkipInitializeWithVersion: true,
+	}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, fake
+}
+
+// Ran tells if a statement containing part was run
+func (d *DB) Ran(part string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, statement := range d.statements {
+		if strings.Contains(statement, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rows answers a single row with the given columns and values, in the same order
+func Rows(columns []string, values ...driver.Value) ([]string, [][]driver.Value) {
+	return columns, [][]driver.Value{values}
+}
+
+func (d *DB) record(query string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, query)
+}
+
+func (d *DB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: d}, nil }
+func (d *DB) Driver() driver.Driver                        { return fakeDriver{d} }
+
+type fakeDriver struct{ db *DB }
+
+func (d fakeDriver) Open(string) (driver.Conn, error) { return &fakeConn{db: d.db}, nil }
+
+type fakeConn struct{ db *DB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	db    *DB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.record(s.query)
+	return fakeResult{}, nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.record(s.query)
+	rows := &fakeRows{}
+	if s.db.answer != nil {
+		rows.columns, rows.rows = s.db.answer(s.query, args)
+	}
+	return rows, nil
+}
+
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 1, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 1, nil }
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+func TestGormLoggerLevels(t *testing.T) {
+	query := func() (string, int64) { return "SELECT * FROM `users` WHERE email = ?", 1 }
+	tests := []struct {
+		name  string
+		level slog.Level
+		begin time.Time
+		err   error
+		want  string
+	}{
+		{"failed query", slog.LevelInfo, time.Now(), errors.New("connection refused"), "level=ERROR msg=\"query failed\""},
+		{"record not found", slog.LevelInfo, time.Now(), gorm.ErrRecordNotFound, ""},
+		{"slow query", slog.LevelInfo, time.Now().Add(-time.Second), nil, "level=WARN msg=\"slow query\""},
+		{"query at info", slog.LevelInfo, time.Now(), nil, ""},
+		{"query at debug", slog.LevelDebug, time.Now(), nil, "level=DEBUG msg=query"},
+	}
+	for _, tt := range tests {
+		out := &bytes.Buffer{}
+		logger := NewGormLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: tt.level})))
+		logger.Trace(context.Background(), tt.begin, query, tt.err)
+
+		if tt.want == "" {
+			if out.Len() > 0 {
+				t.Errorf("%s: logged %q", tt.name, out)
+			}
+			continue
+		}
+		if !strings.Contains(out.String(), tt.want) || !strings.Contains(out.String(), "component=gorm") || !strings.Contains(out.String(), "email = ?") {
+			t.Errorf("%s: logged %q, want %s with the query", tt.name, out, tt.want)
+		}
+	}
+}
+
+func TestGormLoggerSilent(t *testing.T) {
+	out := &bytes.Buffer{}
+	logger := NewGormLogger(slog.New(slog.NewTextHandler(out, nil))).LogMode(gormlogger.Silent)
+	logger.Trace(context.Background(), time.Now(), func() (string, int64) { return "SELECT 1", 1 }, errors.New("failed"))
+	logger.Error(context.Background(), "failed %d", 1)
+	if out.Len() > 0 {
+		t.Errorf("silent logger wrote %q", out)
+	}
+}
+
+func TestGormLoggerDropsQueryValues(t *testing.T) {
+	logger := NewGormLogger(slog.Default()).(gorm.ParamsFilter)
+	sql, params := logger.ParamsFilter(context.Background(), "UPDATE `users` SET password = ?", "hunter2")
+	if sql != "UPDATE `users` SET password = ?" || params != nil {
+		t.Errorf("filtered to %q %v, want the query without its values", sql, params)
+	}
+}
+
+func TestGormLoggerUsesTheLoggerOfTheRequest(t *testing.T) {
+	out := &bytes.Buffer{}
+	request := slog.New(slog.NewTextHandler(out, nil)).With("request_id", "abc")
+	logger := NewGormLogger(slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil)))
+	logger.Warn(WithLogger(context.Background(), request), "slow %s", "query")
+	if !strings.Contains(out.String(), "request_id=abc") || !strings.Contains(out.String(), "msg=\"slow query\"") {
+		t.Errorf("logged %q, want the line with the request ID", out)
+	}
+}
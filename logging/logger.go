@@ -0,0 +1,114 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+// Redacted replaces the value of the attributes holding secrets
+const Redacted = "[REDACTED]"
+
+// secretKeys are the attribute keys, lowercased, whose value is never written
+var secretKeys = map[string]bool{
+	"password":      true,
+	"token":         true,
+	"refreshtoken":  true,
+	"refresh_token": true,
+	"rt":            true,
+	"jwt":           true,
+	"hash":          true,
+	"secret":        true,
+	"authorization": true,
+	"cookie":        true,
+	"set-cookie":    true,
+}
+
+type contextKey struct{}
+
+/*
+New builds the logger of the application from the Config.
+
+Parameters:
+  - conf (*config.Config): LOG_FORMAT is "text", the default, or "json", and
+    LOG_LEVEL is "debug", "info", the default, "warn" or "error"
+  - out (io.Writer): where the log lines are written
+
+Returns:
+  - (*slog.Logger): the logger, redacting secrets
+  - (error): an error if the format or the level is unknown
+*/
+func New(conf *config.Config, out io.Writer) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if conf.LOG_LEVEL != "" {
+		if err := level.UnmarshalText([]byte(conf.LOG_LEVEL)); err != nil {
+			return nil, fmt.Errorf("unknown log level %q", conf.LOG_LEVEL)
+		}
+	}
+
+	options := &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}
+
+	switch conf.LOG_FORMAT {
+	case "", "text":
+		return slog.New(traceHandler{slog.NewTextHandler(out, options)}), nil
+	case "json":
+		return slog.New(traceHandler{slog.NewJSONHandler(out, options)}), nil
+	}
+
+	return nil, fmt.Errorf("unknown log format %q", conf.LOG_FORMAT)
+}
+
+// redact hides the value of the attributes named after a secret, at any depth
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	if secretKeys[strings.ToLower(attr.Key)] {
+		return slog.String(attr.Key, Redacted)
+	}
+
+	return attr
+}
+
+// traceHandler adds the ids of the current span to the lines logged with a context
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if span := tracing.SpanFromContext(ctx); span != nil {
+		record.AddAttrs(
+			slog.String("trace_id", span.Context.TraceID.String()),
+			slog.String("span_id", span.Context.SpanID.String()),
+		)
+	}
+
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// WithLogger returns a copy of the context carrying the logger, usually one with the request ID
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger of the context, or the default logger when there is none
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
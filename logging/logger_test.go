@@ -0,0 +1,122 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+// jsonLine logs with a JSON logger of the level and returns the line, nil when nothing was written
+func jsonLine(t *testing.T, level string, log func(logger *slog.Logger)) map[string]interface{} {
+	t.Helper()
+	out := &bytes.Buffer{}
+	logger, err := New(&config.Config{LOG_FORMAT: "json", LOG_LEVEL: level}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log(logger)
+	if out.Len() == 0 {
+		return nil
+	}
+
+	line := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("%s is not a JSON line: %v", out, err)
+	}
+	return line
+}
+
+func TestNewRejectsUnknownSettings(t *testing.T) {
+	for _, conf := range []*config.Config{{LOG_FORMAT: "xml"}, {LOG_LEVEL: "verbose"}} {
+		if _, err := New(conf, &bytes.Buffer{}); err == nil {
+			t.Errorf("format %q, level %q accepted", conf.LOG_FORMAT, conf.LOG_LEVEL)
+		}
+	}
+
+	out := &bytes.Buffer{}
+	logger, err := New(&config.Config{}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Debug("hidden")
+	logger.Info("shown")
+	if strings.Contains(out.String(), "hidden") || !strings.Contains(out.String(), "msg=shown") {
+		t.Errorf("default logger wrote %q, want info lines as text", out)
+	}
+}
+
+func TestNewFiltersLevels(t *testing.T) {
+	if line := jsonLine(t, "warn", func(logger *slog.Logger) { logger.Info("ignored") }); line != nil {
+		t.Errorf("info line written at the warn level: %v", line)
+	}
+	if line := jsonLine(t, "debug", func(logger *slog.Logger) { logger.Debug("kept") }); line == nil || line["msg"] != "kept" {
+		t.Errorf("debug line %v, want it written", line)
+	}
+}
+
+func TestSecretsAreRedacted(t *testing.T) {
+	line := jsonLine(t, "", func(logger *slog.Logger) {
+		logger.Info("login", "password", "hunter2", "Authorization", "Bearer abc", "email", "bob@example.com",
+			slog.Group("request", "cookie", "jwt=abc", "path", "/login"))
+	})
+	if line["password"] != Redacted || line["Authorization"] != Redacted {
+		t.Errorf("secrets written: %v", line)
+	}
+	if line["email"] != "bob@example.com" {
+		t.Errorf("email %v, want it kept", line["email"])
+	}
+	request, _ := line["request"].(map[string]interface{})
+	if request["cookie"] != Redacted || request["path"] != "/login" {
+		t.Errorf("group %v, want only the cookie redacted", request)
+	}
+}
+
+func TestModelsHideTheirSecrets(t *testing.T) {
+	user := &model.User{Email: "bob@example.com", Password: "$2a$10$hash"}
+	user.ID = 3
+	rt := &model.RefreshToken{Hash: "abcdef", UserId: 3}
+	out := &bytes.Buffer{}
+	logger, err := New(&config.Config{LOG_FORMAT: "json"}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("refresh", "user", user, "rt", rt, "token", rt)
+	for _, secret := range []string{"bob@example.com", "$2a$10$hash", "abcdef"} {
+		if strings.Contains(out.String(), secret) {
+			t.Errorf("%q written in %s", secret, out)
+		}
+	}
+	if !strings.Contains(out.String(), `"user":{"id":3,"admin":false}`) {
+		t.Errorf("user not described by its id in %s", out)
+	}
+}
+
+func TestTraceIdsAddedToTheLines(t *testing.T) {
+	ctx, span := tracing.Start(context.Background(), "request", tracing.KindServer)
+	defer span.End()
+
+	line := jsonLine(t, "", func(logger *slog.Logger) { logger.InfoContext(ctx, "traced") })
+	if line["trace_id"] != span.Context.TraceID.String() || line["span_id"] != span.Context.SpanID.String() {
+		t.Errorf("line %v, want the ids of the span", line)
+	}
+	if line := jsonLine(t, "", func(logger *slog.Logger) { logger.With("room", "general").Info("untraced") }); line["trace_id"] != nil || line["room"] != "general" {
+		t.Errorf("line %v, want the attributes and no trace", line)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	if FromContext(context.Background()) != slog.Default() {
+		t.Error("default logger not used without one in the context")
+	}
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	if FromContext(WithLogger(context.Background(), logger)) != logger {
+		t.Error("logger of the context not returned")
+	}
+}
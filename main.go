@@ -1,66 +1,250 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/MohammadBnei/go-html-adapter/adapterHTML"
 	"github.com/gin-gonic/gin"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/handler"
+	"github.com/riri95500/go-chat/logging"
+	"github.com/riri95500/go-chat/metrics"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/ratelimit"
 	"github.com/riri95500/go-chat/service"
+	"github.com/riri95500/go-chat/storage"
+	"github.com/riri95500/go-chat/tracing"
+	"github.com/riri95500/go-chat/unfurl"
 )
 
 var roomManager service.Manager
 
+// version is set when building: go build -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
 func main() {
-	// conf := config.InitConfig()
-	// db, err := config.InitDB(conf)
-	// if err != nil {
-	// 	log.Fatalln(err)
-	// }
-
-	// db.AutoMigrate(&model.User{}, &model.RefreshToken{})
-
-	// userService := service.NewUserService(db)
-	// rtService := service.NewRTService(db)
-	// userHandler := handler.NewUserHandler(userService)
-	// authHandler := handler.NewAuthHandler(rtService, userService, conf)
-
-	// r := gin.Default()
-
-	// userApi := r.Group("/api/v1/user")
-	// userApi.GET("/:id", userHandler.GetUser)
-	// userApi.GET("/", userHandler.GetUsers)
-	// userApi.POST("/", userHandler.CreateUser)
-	// userApi.PUT("/:id", userHandler.UpdateUser)
-	// userApi.DELETE("/:id", userHandler.DeleteUser)
-
-	// authApi := r.Group("/api/v1/auth")
-	// authApi.POST("/login", authHandler.Login)
-
-	// r.GET("/test/auth", authHandler.AuthMiddleware(), func(c *gin.Context) {
-	// 	user, exist := c.Get("user")
-
-	// 	if !exist {
-	// 		c.JSON(401, gin.H{
-	// 			"error": "no user in the context",
-	// 		})
-	// 		return
-	// 	}
-	// 	c.JSON(200, gin.H{
-	// 		"user": user,
-	// 	})
-	// })
-
-	// r.Run()
-
-	roomManager = service.GetRoomManager()
+	conf := config.InitConfig()
+	logger, err := logging.New(conf, os.Stdout)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// le package log passe aussi par slog
+	slog.SetDefault(logger)
+
+	tracer, err := tracing.New(conf, logger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	db, err := config.InitDB(conf, logging.NewGormLogger(logger))
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := db.Use(tracing.NewGormPlugin()); err != nil {
+		log.Fatalln(err)
+	}
+
+	db.AutoMigrate(&model.User{}, &model.RefreshToken{}, &model.Room{}, &model.RoomMember{}, &model.Message{}, &model.MessageEdit{}, &model.Reaction{}, &model.ReadMarker{}, &model.Mention{}, &model.Attachment{}, &model.LinkPreview{}, &model.ModerationAction{}, &model.RoomRestriction{}, &model.RateLimitBucket{}, &model.FlaggedMessage{}, &model.Report{}, &model.AuditEvent{})
+
+	blobStore, err := storage.NewBlobStore(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	limiter, err := ratelimit.NewLimiter(conf, db, logger)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rules, err := ratelimit.NewRules(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	managerOptions, err := service.NewManagerOptions(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	managerOptions.Filters, err = service.NewMessageFilters(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	userService := service.NewUserService(db)
+	rtService := service.NewRTService(db)
+	roomService := service.NewRoomService(db)
+	messageService := service.NewMessageService(db)
+	attachmentService := service.NewAttachmentService(db, blobStore, conf)
+	moderationService := service.NewModerationService(db, logger)
+	reviewService := service.NewReviewService(db)
+	reportService := service.NewReportService(db)
+	auditService := service.NewAuditService(db, logger)
+	if err := userService.PromoteAdmins(conf.ADMIN_USER_IDS); err != nil {
+		log.Fatalln(err)
+	}
+	unfurlWorker := service.NewUnfurlWorker(db, unfurl.NewFetcher(unfurl.Options{
+		Timeout:     conf.UNFURL_TIMEOUT,
+		MaxBodySize: int64(conf.UNFURL_MAX_SIZE),
+	}), service.NewUnfurlOptions(conf), logger)
+
+	managerOptions.RoomLoader = roomService.RoomLoader(managerOptions.Room)
+	managerOptions.MessageStore = messageService
+	managerOptions.MentionResolver = userService
+	managerOptions.LinkUnfurler = unfurlWorker
+	managerOptions.ModerationLoader = moderationService.LoadModeration
+	managerOptions.FlaggedMessageStore = reviewService
+	managerOptions.AuditLogger = auditService
+	managerOptions.Logger = logger
+	roomManager = service.InitRoomManager(managerOptions)
+	unfurlWorker.Start(roomManager)
+	service.RegisterManagerMetrics(roomManager)
+
+	userHandler := handler.NewUserHandler(userService, auditService)
+	authHandler := handler.NewAuthHandler(rtService, userService, auditService, conf)
+	roomHandler := handler.NewRoomHandler(roomManager, userService, roomService, messageService)
+	dmHandler := handler.NewDMHandler(roomManager, roomService)
+	attachmentHandler := handler.NewAttachmentHandler(attachmentService, roomService, roomManager)
+	moderationHandler := handler.NewModerationHandler(roomManager, roomService, messageService, moderationService, reviewService, reportService)
+	reportHandler := handler.NewReportHandler(reportService, roomService)
+	auditHandler := handler.NewAuditHandler(auditService)
+	adminHandler := handler.NewAdminHandler(roomManager, auditService)
+	healthHandler := handler.NewHealthHandler(db, roomManager, conf, version)
+
 	adapter := adapterHTML.NewGinHTMLAdapter(roomManager)
-	router := gin.Default()
+	router := gin.New()
+	router.Use(handler.RequestIDMiddleware(logger), handler.TracingMiddleware(), handler.AccessLogMiddleware(), handler.MetricsMiddleware(), gin.Recovery())
 	router.SetHTMLTemplate(adapter.Template)
+	if err := router.SetTrustedProxies(conf.TRUSTED_PROXIES); err != nil {
+		log.Fatalln(err)
+	}
+	messageLimit := handler.RateLimitMiddleware(limiter, rules.Messages)
+
+	router.GET("/metrics", gin.WrapH(metrics.Default))
+	router.GET("/healthz", healthHandler.Healthz)
+	router.GET("/readyz", healthHandler.Readyz)
+	router.GET("/admin/status", authHandler.AuthMiddleware(), handler.AdminMiddleware(), healthHandler.Status)
+
+	// L'inscription reste publique, le reste demande d'être connecté
+	router.POST("/api/v1/user/", userHandler.CreateUser)
+	userApi := router.Group("/api/v1/user", authHandler.AuthMiddleware())
+	userApi.GET("/:id", userHandler.GetUser)
+	userApi.GET("/", userHandler.GetUsers)
+	userApi.PUT("/:id", handler.SelfOrAdminMiddleware(), userHandler.UpdateUser)
+	userApi.DELETE("/:id", handler.SelfOrAdminMiddleware(), userHandler.DeleteUser)
+
+	authApi := router.Group("/api/v1/auth")
+	authApi.POST("/login", authHandler.Login)
+
+	roomApi := router.Group("/api/v1/rooms", authHandler.AuthMiddleware(), roomHandler.AccessMiddleware())
+	roomApi.GET("/:roomid/stream", roomHandler.Stream)
+	roomApi.GET("/:roomid/presence", roomHandler.GetPresence)
+	roomApi.POST("/:roomid/read", roomHandler.MarkRead)
+	roomApi.POST("/:roomid/typing", roomHandler.Typing)
+	roomApi.GET("/:roomid/messages", roomHandler.GetMessages)
+	roomApi.POST("/:roomid/messages", messageLimit, roomHandler.PostMessage)
+	roomApi.PATCH("/:roomid/messages/:id", roomHandler.EditMessage)
+	roomApi.DELETE("/:roomid/messages/:id", roomHandler.DeleteMessage)
+	roomApi.GET("/:roomid/messages/:id/edits", roomHandler.GetMessageEdits)
+	roomApi.GET("/:roomid/messages/:id/thread", roomHandler.GetThread)
+	roomApi.GET("/:roomid/messages/:id/thread/stream", roomHandler.StreamThread)
+	roomApi.PUT("/:roomid/messages/:id/reactions/:emoji", roomHandler.AddReaction)
+	roomApi.DELETE("/:roomid/messages/:id/reactions/:emoji", roomHandler.RemoveReaction)
+	roomApi.POST("/:roomid/attachments", handler.RateLimitMiddleware(limiter, rules.Uploads), attachmentHandler.Upload)
+	roomApi.GET("/:roomid/attachments/:id", attachmentHandler.Download)
+
+	moderationApi := roomApi.Group("/:roomid/moderation", moderationHandler.ModeratorMiddleware())
+	moderationApi.GET("/log", moderationHandler.GetLog)
+	moderationApi.GET("/restrictions", moderationHandler.GetRestrictions)
+	moderationApi.POST("/kick/:userId", moderationHandler.Kick)
+	moderationApi.POST("/ban/:userId", moderationHandler.Ban)
+	moderationApi.DELETE("/ban/:userId", moderationHandler.Unban)
+	moderationApi.POST("/mute/:userId", moderationHandler.Mute)
+	moderationApi.DELETE("/mute/:userId", moderationHandler.Unmute)
+	moderationApi.PUT("/slowmode", moderationHandler.SlowMode)
+	moderationApi.GET("/flagged", moderationHandler.GetFlagged)
+	moderationApi.POST("/flagged/:id/approve", moderationHandler.ApproveFlagged)
+	moderationApi.POST("/flagged/:id/remove", moderationHandler.RemoveFlagged)
+	moderationApi.GET("/reports", moderationHandler.GetReports)
+	moderationApi.POST("/reports/:id/dismiss", moderationHandler.DismissReport)
+	moderationApi.POST("/reports/:id/delete", moderationHandler.DeleteReported)
+	moderationApi.POST("/reports/:id/mute", moderationHandler.MuteReported)
+	moderationApi.POST("/reports/:id/ban", moderationHandler.BanReported)
+
+	// Seuls le propriétaire et les administrateurs nomment les modérateurs
+	moderatorsApi := roomApi.Group("/:roomid/moderators", moderationHandler.OwnerMiddleware())
+	moderatorsApi.PUT("/:userId", moderationHandler.GrantModerator)
+	moderatorsApi.DELETE("/:userId", moderationHandler.RevokeModerator)
+
+	reportApi := router.Group("/api/v1/reports", authHandler.AuthMiddleware())
+	reportApi.POST("/", reportHandler.CreateReport)
+
+	adminApi := router.Group("/api/v1/admin", authHandler.AuthMiddleware(), handler.AdminMiddleware())
+	adminApi.GET("/audit", auditHandler.GetEvents)
+	adminApi.GET("/rooms", adminHandler.GetRooms)
+	adminApi.GET("/rooms/:roomid/listeners", adminHandler.GetListeners)
+	adminApi.DELETE("/rooms/:roomid/listeners/:id", adminHandler.Disconnect)
+	adminApi.POST("/rooms/:roomid/close", adminHandler.CloseRoom)
+	adminApi.PUT("/rooms/:roomid/owner/:userId", moderationHandler.SetOwner)
+	adminApi.POST("/announcements", adminHandler.Announce)
+
+	dmApi := router.Group("/api/v1/dm", authHandler.AuthMiddleware())
+	dmApi.POST("/", dmHandler.StartGroupConversation)
+	dmApi.POST("/:userId", dmHandler.StartConversation)
+
+	meApi := router.Group("/api/v1/me", authHandler.AuthMiddleware())
+	meApi.GET("/conversations", dmHandler.GetConversations)
+	meApi.GET("/stream", roomHandler.StreamAll)
+	meApi.GET("/rooms", roomHandler.GetRooms)
+	meApi.GET("/mentions", roomHandler.GetMentions)
+
+	htmlRoom := router.Group("/", handler.PublicRoomMiddleware())
+	htmlRoom.GET("/room/:roomid", adapter.GetRoom)
+	// La page n'envoie que le cookie jwt : l'auteur vient de l'authentification, pas du formulaire
+	htmlRoom.POST("/room/:roomid", authHandler.AuthMiddleware(), messageLimit, roomHandler.HTMLPost)
+	htmlRoom.DELETE("/room/:roomid", authHandler.AuthMiddleware(), handler.AdminMiddleware(), adminHandler.DeleteRoom)
+	htmlRoom.GET("/stream/:roomid", roomHandler.HTMLStream)
+
+	// annulé à l'arrêt pour terminer les streams, qui ne finissent jamais d'eux-mêmes
+	baseCtx, stopStreams := context.WithCancel(context.Background())
+	server := &http.Server{
+		Addr:        fmt.Sprintf(":%v", 8080),
+		Handler:     router,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalln(err)
+		}
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+
+	// readyz échoue pendant SHUTDOWN_DRAIN, le temps que le load balancer retire le serveur
+	logger.Info("shutting down", "drain", conf.SHUTDOWN_DRAIN)
+	healthHandler.ShutDown()
+	time.Sleep(conf.SHUTDOWN_DRAIN)
 
-	router.GET("/room/:roomid", adapter.GetRoom)
-	router.POST("/room/:roomid", adapter.PostRoom)
-	router.DELETE("/room/:roomid", adapter.DeleteRoom)
-	router.GET("/stream/:roomid", adapter.Stream)
+	timeout := conf.SHUTDOWN_TIMEOUT
+	if timeout <= 0 {
+		timeout = 15 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	router.Run(fmt.Sprintf(":%v", 8080))
+	stopStreams()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("shutdown", "error", err)
+	}
+	tracer.Shutdown(ctx)
 }
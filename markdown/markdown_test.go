@@ -0,0 +1,119 @@
+package markdown
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+		ok   bool
+	}{
+		{"https://example.com/a?b=c", "https://example.com/a?b=c", true},
+		{"HTTP://example.com", "http://example.com", true},
+		{"mailto:alice@example.com", "mailto:alice@example.com", true},
+		{"javascript:alert(1)", "", false},
+		{"JaVaScRiPt:alert(1)", "", false},
+		{"javascript&#58;alert(1)", "", false},
+		{"&#106;avascript:alert(1)", "", false},
+		{"java\tscript:alert(1)", "", false},
+		{"\x01javascript:alert(1)", "", false},
+		{" javascript:alert(1)", "", false},
+		{"data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==", "", false},
+		{"DATA:text/html,<script>", "", false},
+		{"vbscript:msgbox(1)", "", false},
+		{"file:///etc/passwd", "", false},
+		{"//evil.example.com", "", false},
+		{"https:evil.example.com", "", false},
+		{"/relative", "", false},
+		{`https://example.com/"onmouseover="alert(1)`, "", false},
+		{"https://example.com/<script>", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := SafeURL(tt.raw)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("SafeURL(%q) = %q, %v, want %q, %v", tt.raw, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"html is text", `<script>alert("x")</script>`, `<p>&lt;script&gt;alert(&#34;x&#34;)&lt;/script&gt;</p>`},
+		{"attribute injection", `<img src=x onerror=alert(1)>`, `<p>&lt;img src=x onerror=alert(1)&gt;</p>`},
+		{"entities are escaped", "&lt;b&gt; &amp;", "<p>&amp;lt;b&amp;gt; &amp;amp;</p>"},
+		{"bold", "**bold**", "<p><strong>bold</strong></p>"},
+		{"italic", "*a* and _b_", "<p><em>a</em> and <em>b</em></p>"},
+		{"italic in bold", "**bold *and italic* bold**", "<p><strong>bold <em>and italic</em> bold</strong></p>"},
+		{"bold in italic", "*italic **and bold** italic*", "<p><em>italic <strong>and bold</strong> italic</em></p>"},
+		{"bold and italic", "***both***", "<p><strong><em>both</em></strong></p>"},
+		{"unclosed", "**not bold", "<p>**not bold</p>"},
+		{"snake_case", "snake_case_name", "<p>snake_case_name</p>"},
+		{"escaped", `\*not italic\*`, "<p>*not italic*</p>"},
+		{"code span", "`**not bold** <b>`", "<p><code>**not bold** &lt;b&gt;</code></p>"},
+		{"code in bold", "**`x`**", "<p><strong><code>x</code></strong></p>"},
+		{"code block", "```\n<b>\n**x**\n```", "<pre><code>&lt;b&gt;\n**x**</code></pre>"},
+		{"link", "[site](https://example.com)", `<p><a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">site</a></p>`},
+		{"link label escaped", "[<b>](https://example.com)", `<p><a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">&lt;b&gt;</a></p>`},
+		{"javascript link", "[x](javascript:alert(1))", "<p>[x](javascript:alert(1))</p>"},
+		{"mixed case javascript link", "[x](JAVASCRIPT:alert(1))", "<p>[x](JAVASCRIPT:alert(1))</p>"},
+		{"data link", "[x](data:text/html,hi)", "<p>[x](data:text/html,hi)</p>"},
+		{"autolink", "see https://example.com.", `<p>see <a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">https://example.com</a>.</p>`},
+		{"line break", "a\nb", "<p>a<br>b</p>"},
+		{"list", "- a\n- b", "<ul><li>a</li><li>b</li></ul>"},
+		{"ordered list", "3. a\n4. b", `<ol start="3"><li>a</li><li>b</li></ol>`},
+	}
+	for _, tt := range tests {
+		if got := RenderHTML(Parse(tt.text)); got != tt.want {
+			t.Errorf("%s: RenderHTML(%q) = %q, want %q", tt.name, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRenderHTMLChecksLinksAgain(t *testing.T) {
+	// Un arbre construit ailleurs que par Parse
+	node := &Node{Type: Link, Href: "javascript:alert(1)", Children: []*Node{{Type: Text, Text: "x"}}}
+	if got := RenderHTML(node); got != "x" {
+		t.Errorf("got %q, want the text of the link only", got)
+	}
+}
+
+func TestDeepNestingStaysText(t *testing.T) {
+	text := strings.Repeat("*", 40) + "x" + strings.Repeat("*", 40)
+	depth := 0
+	var walk func(*Node, int)
+	walk = func(n *Node, d int) {
+		if d > depth {
+			depth = d
+		}
+		for _, child := range n.Children {
+			walk(child, d+1)
+		}
+	}
+	walk(Parse(text), 0)
+	if depth > maxDepth+3 {
+		t.Errorf("nesting of %d nodes", depth)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	got := PlainText(Parse("**Hello** *world*\n\n- [site](https://example.com)\n- `code`"))
+	if want := "Hello world\n\n- site\n- code"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	got := Links(Parse("[a](https://a.example) https://b.example and https://a.example"))
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,67 @@
+package markdown
+
+type NodeType string
+
+const (
+	Document  NodeType = "document"
+	Paragraph NodeType = "paragraph"
+	List      NodeType = "list"
+	ListItem  NodeType = "list_item"
+	CodeBlock NodeType = "code_block"
+	Text      NodeType = "text"
+	Strong    NodeType = "strong"
+	Emphasis  NodeType = "emphasis"
+	Code      NodeType = "code"
+	Link      NodeType = "link"
+	LineBreak NodeType = "line_break"
+)
+
+/*
+Node is an element of a parsed message. Text holds the content of the
+text, code and code_block nodes, the other nodes hold their content in Children.
+*/
+type Node struct {
+	Type NodeType `json:"type"`
+	Text string   `json:"text,omitempty"`
+	// Target of a link, always an allowed URL
+	Href string `json:"href,omitempty"`
+	// For lists, whether the items are numbered and the number of the first one
+	Ordered  bool    `json:"ordered,omitempty"`
+	Start    int     `json:"start,omitempty"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+func (n *Node) append(child *Node) {
+	// Les textes consécutifs sont fusionnés pour garder un arbre compact
+	if child.Type == Text && len(n.Children) > 0 {
+		last := n.Children[len(n.Children)-1]
+		if last.Type == Text {
+			last.Text += child.Text
+			return
+		}
+	}
+	n.Children = append(n.Children, child)
+}
+
+// Links returns the targets of the links of the message, without duplicates
+func Links(node *Node) []string {
+	links := []string{}
+	seen := map[string]bool{}
+
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n == nil {
+			return
+		}
+		if n.Type == Link && !seen[n.Href] {
+			seen[n.Href] = true
+			links = append(links, n.Href)
+		}
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(node)
+
+	return links
+}
@@ -0,0 +1,266 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Nesting of bold, italic and links deeper than this is kept as text
+const maxDepth = 8
+
+var (
+	bulletItem  = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	orderedItem = regexp.MustCompile(`^ {0,3}(\d{1,9})[.)][ \t]+(.*)$`)
+)
+
+/*
+Parse parses the Markdown subset allowed in messages: paragraphs, line breaks,
+bullet and numbered lists, fenced code blocks, **bold**, *italic*, `code`,
+[links](https://example.com) and bare URLs. Anything else, HTML included, is kept as text.
+
+Parameters:
+  - text (string): the text of a message
+
+Returns:
+  - (*Node): the document node
+*/
+func Parse(text string) *Node {
+	doc := &Node{Type: Document}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+
+	var paragraph, list *Node
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			paragraph, list = nil, nil
+			code := []string{}
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			doc.append(&Node{Type: CodeBlock, Text: strings.Join(code, "\n")})
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			paragraph, list = nil, nil
+			continue
+		}
+
+		if match := bulletItem.FindStringSubmatch(line); match != nil {
+			paragraph = nil
+			if list == nil || list.Ordered {
+				list = &Node{Type: List}
+				doc.append(list)
+			}
+			list.append(&Node{Type: ListItem, Children: parseInline(match[1], 0)})
+			continue
+		}
+
+		if match := orderedItem.FindStringSubmatch(line); match != nil {
+			paragraph = nil
+			if list == nil || !list.Ordered {
+				start, _ := strconv.Atoi(match[1])
+				list = &Node{Type: List, Ordered: true, Start: start}
+				doc.append(list)
+			}
+			list.append(&Node{Type: ListItem, Children: parseInline(match[2], 0)})
+			continue
+		}
+
+		list = nil
+		if paragraph == nil {
+			paragraph = &Node{Type: Paragraph}
+			doc.append(paragraph)
+		} else {
+			paragraph.append(&Node{Type: LineBreak})
+		}
+		for _, child := range parseInline(strings.TrimSpace(line), 0) {
+			paragraph.append(child)
+		}
+	}
+
+	return doc
+}
+
+// parseInline parses the bold, italic, code and links of a line
+func parseInline(s string, depth int) []*Node {
+	parent := &Node{}
+	text := strings.Builder{}
+	flush := func() {
+		if text.Len() > 0 {
+			parent.append(&Node{Type: Text, Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+
+		if c == '\\' && i+1 < len(s) && isPunct(s[i+1]) {
+			text.WriteByte(s[i+1])
+			i += 2
+			continue
+		}
+
+		if c == '`' {
+			if end := strings.IndexByte(s[i+1:], '`'); end > 0 {
+				flush()
+				parent.append(&Node{Type: Code, Text: s[i+1 : i+1+end]})
+				i += end + 2
+				continue
+			}
+		}
+
+		if depth < maxDepth {
+			if (c == '*' || c == '_') && strings.HasPrefix(s[i:], strings.Repeat(string(c), 2)) {
+				if end := closing(s, i, i+2, string([]byte{c, c})); end > 0 {
+					flush()
+					parent.append(&Node{Type: Strong, Children: parseInline(s[i+2:end], depth+1)})
+					i = end + 2
+					continue
+				}
+			}
+
+			if c == '*' || c == '_' {
+				if end := closing(s, i, i+1, string(c)); end > 0 {
+					flush()
+					parent.append(&Node{Type: Emphasis, Children: parseInline(s[i+1:end], depth+1)})
+					i = end + 1
+					continue
+				}
+			}
+
+			if c == '[' {
+				if node, n := parseLink(s[i:], depth); node != nil {
+					flush()
+					parent.append(node)
+					i += n
+					continue
+				}
+			}
+		}
+
+		if strings.IndexByte("hHwW", c) >= 0 && (i == 0 || !isWord(s[i-1])) {
+			if href, n := autolink(s[i:]); n > 0 {
+				flush()
+				parent.append(&Node{Type: Link, Href: href, Children: []*Node{{Type: Text, Text: s[i : i+n]}}})
+				i += n
+				continue
+			}
+		}
+
+		text.WriteByte(c)
+		i++
+	}
+	flush()
+
+	return parent.Children
+}
+
+/*
+closing returns the position of the delimiter closing the one at start, or -1.
+The content must not start or end with a space, and an underscore inside a word
+(such as in snake_case) neither opens nor closes anything.
+*/
+func closing(s string, start int, content int, delim string) int {
+	if content >= len(s) || s[content] == ' ' {
+		return -1
+	}
+	underscore := delim[0] == '_'
+	if underscore && start > 0 && isWord(s[start-1]) {
+		return -1
+	}
+
+	for i := content + 1; i+len(delim) <= len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != delim[0] {
+			continue
+		}
+		// Une suite de délimiteurs compte comme un tout : ** ne ferme pas un * simple
+		run := i
+		for run < len(s) && s[run] == delim[0] {
+			run++
+		}
+		if s[i-1] == ' ' || run-i < len(delim) || (len(delim) == 1 && run-i == 2) {
+			i = run - 1
+			continue
+		}
+		if underscore && run < len(s) && isWord(s[run]) {
+			i = run - 1
+			continue
+		}
+		// La fin de la suite ferme, ***x*** donne un gras contenant un italique
+		return run - len(delim)
+	}
+
+	return -1
+}
+
+// parseLink parses a [text](url) link at the start of s, returning the node and the length read
+func parseLink(s string, depth int) (*Node, int) {
+	end := strings.Index(s, "](")
+	if end < 0 {
+		return nil, 0
+	}
+	stop := strings.IndexByte(s[end+2:], ')')
+	if stop < 0 {
+		return nil, 0
+	}
+
+	href, ok := SafeURL(strings.TrimSpace(s[end+2 : end+2+stop]))
+	if !ok {
+		return nil, 0
+	}
+
+	label := s[1:end]
+	if strings.TrimSpace(label) == "" {
+		label = href
+	}
+
+	return &Node{Type: Link, Href: href, Children: parseInline(label, depth+1)}, end + 3 + stop
+}
+
+// autolink detects a bare http://, https:// or www. URL at the start of s
+func autolink(s string) (string, int) {
+	lower := strings.ToLower(s)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") && !strings.HasPrefix(lower, "www.") {
+		return "", 0
+	}
+
+	n := strings.IndexAny(s, " \t<>\"`")
+	if n < 0 {
+		n = len(s)
+	}
+	// La ponctuation finale fait partie de la phrase, pas de l'URL
+	for n > 0 && strings.IndexByte(".,:;!?'*_", s[n-1]) >= 0 {
+		n--
+	}
+	if n > 0 && s[n-1] == ')' && strings.Count(s[:n], "(") < strings.Count(s[:n], ")") {
+		n--
+	}
+
+	raw := s[:n]
+	if strings.HasPrefix(lower, "www.") {
+		raw = "https://" + raw
+	}
+
+	href, ok := SafeURL(raw)
+	if !ok {
+		return "", 0
+	}
+
+	return href, n
+}
+
+func isWord(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || c >= 0x80
+}
+
+func isPunct(c byte) bool {
+	return strings.IndexByte("\\`*_{}[]()#+-.!<>|~", c) >= 0
+}
@@ -0,0 +1,144 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Only these schemes can be linked to, javascript: and data: URLs stay text
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+/*
+SafeURL checks that the URL can be linked to, that is an absolute http, https or mailto URL.
+
+Returns:
+  - (string): the normalised URL
+  - (bool): false if the URL is not allowed
+*/
+func SafeURL(raw string) (string, bool) {
+	if raw == "" || strings.ContainsAny(raw, " \t\r\n<>\"'`") {
+		return "", false
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	if u.Scheme != "mailto" && u.Host == "" {
+		return "", false
+	}
+
+	return u.String(), true
+}
+
+/*
+RenderHTML renders a parsed message as HTML. Only the tags of the allowed subset
+(p, br, strong, em, code, pre, a, ul, ol, li) are produced and every text is
+escaped, so the result can be inserted as is in a page.
+*/
+func RenderHTML(node *Node) string {
+	b := &strings.Builder{}
+	renderHTML(b, node)
+	return b.String()
+}
+
+func renderHTML(b *strings.Builder, node *Node) {
+	if node == nil {
+		return
+	}
+
+	switch node.Type {
+	case Text:
+		b.WriteString(html.EscapeString(node.Text))
+	case Code:
+		b.WriteString("<code>" + html.EscapeString(node.Text) + "</code>")
+	case CodeBlock:
+		b.WriteString("<pre><code>" + html.EscapeString(node.Text) + "</code></pre>")
+	case LineBreak:
+		b.WriteString("<br>")
+	case Paragraph:
+		wrapHTML(b, "<p>", "</p>", node)
+	case Strong:
+		wrapHTML(b, "<strong>", "</strong>", node)
+	case Emphasis:
+		wrapHTML(b, "<em>", "</em>", node)
+	case ListItem:
+		wrapHTML(b, "<li>", "</li>", node)
+	case List:
+		if !node.Ordered {
+			wrapHTML(b, "<ul>", "</ul>", node)
+		} else if node.Start > 1 {
+			wrapHTML(b, `<ol start="`+strconv.Itoa(node.Start)+`">`, "</ol>", node)
+		} else {
+			wrapHTML(b, "<ol>", "</ol>", node)
+		}
+	case Link:
+		// L'arbre peut venir d'ailleurs que de Parse, l'URL est vérifiée à nouveau
+		href, ok := SafeURL(node.Href)
+		if !ok {
+			wrapHTML(b, "", "", node)
+			return
+		}
+		wrapHTML(b, `<a href="`+html.EscapeString(href)+`" rel="nofollow noopener noreferrer" target="_blank">`, "</a>", node)
+	default:
+		wrapHTML(b, "", "", node)
+	}
+}
+
+func wrapHTML(b *strings.Builder, openTag string, closeTag string, node *Node) {
+	b.WriteString(openTag)
+	for _, child := range node.Children {
+		renderHTML(b, child)
+	}
+	b.WriteString(closeTag)
+}
+
+// PlainText renders a parsed message as text, without any Markdown syntax
+func PlainText(node *Node) string {
+	b := &strings.Builder{}
+	renderText(b, node)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func renderText(b *strings.Builder, node *Node) {
+	if node == nil {
+		return
+	}
+
+	switch node.Type {
+	case Text, Code:
+		b.WriteString(node.Text)
+	case CodeBlock:
+		b.WriteString(node.Text + "\n\n")
+	case LineBreak:
+		b.WriteString("\n")
+	case Paragraph:
+		renderChildren(b, node)
+		b.WriteString("\n\n")
+	case List:
+		for i, item := range node.Children {
+			if node.Ordered {
+				b.WriteString(strconv.Itoa(node.Start+i) + ". ")
+			} else {
+				b.WriteString("- ")
+			}
+			renderChildren(b, item)
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	default:
+		renderChildren(b, node)
+	}
+}
+
+func renderChildren(b *strings.Builder, node *Node) {
+	for _, child := range node.Children {
+		renderText(b, child)
+	}
+}
@@ -0,0 +1,197 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"sort"
+	"sync/atomic"
+)
+
+// DefBuckets are the default histogram buckets, in seconds, for request durations
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// atomicFloat is a float64 updated without lock
+type atomicFloat struct {
+	bits uint64
+}
+
+func (f *atomicFloat) add(v float64) {
+	for {
+		old := atomic.LoadUint64(&f.bits)
+		next := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&f.bits, old, next) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) set(v float64) {
+	atomic.StoreUint64(&f.bits, math.Float64bits(v))
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&f.bits))
+}
+
+// Counter is a value that only goes up
+type Counter struct {
+	series
+	value atomicFloat
+}
+
+func (c *Counter) Inc() {
+	c.value.add(1)
+}
+
+// Add increases the counter, negative values are ignored
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.value.add(v)
+	}
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	family
+}
+
+// NewCounterVec creates a counter and registers it on the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newFamily(name, help, labels)}
+	Default.register(c)
+	return c
+}
+
+// With returns the counter of the label values, in the order of the labels
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.get(values, func(s series) interface{} { return &Counter{series: s} }).(*Counter)
+}
+
+func (c *CounterVec) describe() (string, string, string) {
+	return c.name, c.help, "counter"
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.each(func(s interface{}) {
+		counter := s.(*Counter)
+		fmt.Fprintf(w, "%s%s %s\n", c.name, counter.labels, formatFloat(counter.value.load()))
+	})
+}
+
+// Gauge is a value that goes up and down
+type Gauge struct {
+	series
+	value atomicFloat
+}
+
+func (g *Gauge) Set(v float64) {
+	g.value.set(v)
+}
+
+func (g *Gauge) Add(v float64) {
+	g.value.add(v)
+}
+
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	family
+}
+
+// NewGaugeVec creates a gauge and registers it on the Default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newFamily(name, help, labels)}
+	Default.register(g)
+	return g
+}
+
+// With returns the gauge of the label values, in the order of the labels
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.get(values, func(s series) interface{} { return &Gauge{series: s} }).(*Gauge)
+}
+
+func (g *GaugeVec) describe() (string, string, string) {
+	return g.name, g.help, "gauge"
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.each(func(s interface{}) {
+		gauge := s.(*Gauge)
+		fmt.Fprintf(w, "%s%s %s\n", g.name, gauge.labels, formatFloat(gauge.value.load()))
+	})
+}
+
+// Histogram counts observations in buckets
+type Histogram struct {
+	series
+	upper  []float64
+	counts []uint64
+	count  uint64
+	sum    atomicFloat
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.add(v)
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	family
+	buckets []float64
+}
+
+/*
+NewHistogramVec creates a histogram and registers it on the Default registry.
+
+Parameters:
+  - name (string): the name of the metric
+  - help (string): what it measures
+  - buckets ([]float64): the upper bounds of the buckets, DefBuckets when nil, +Inf is implied
+  - labels (...string): the names of the labels
+*/
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	upper := append([]float64{}, buckets...)
+	sort.Float64s(upper)
+
+	h := &HistogramVec{newFamily(name, help, labels), upper}
+	Default.register(h)
+	return h
+}
+
+// With returns the histogram of the label values, in the order of the labels
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.get(values, func(s series) interface{} {
+		return &Histogram{series: s, upper: h.buckets, counts: make([]uint64, len(h.buckets))}
+	}).(*Histogram)
+}
+
+func (h *HistogramVec) describe() (string, string, string) {
+	return h.name, h.help, "histogram"
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.each(func(s interface{}) {
+		histogram := s.(*Histogram)
+		count := atomic.LoadUint64(&histogram.count)
+		var cumulative uint64
+		for i, upper := range histogram.upper {
+			cumulative += atomic.LoadUint64(&histogram.counts[i])
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(histogram.labels, "le", formatFloat(upper)), cumulative)
+		}
+		// Une observation en cours peut être comptée dans un bucket et pas encore dans le total
+		if cumulative > count {
+			count = cumulative
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, withLabel(histogram.labels, "le", "+Inf"), count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, histogram.labels, formatFloat(histogram.sum.load()))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, histogram.labels, count)
+	})
+}
@@ -0,0 +1,101 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrape writes the registry as it is served on /metrics
+func scrape(t *testing.T, r *Registry) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("content type %q, want the Prometheus text format", w.Header().Get("Content-Type"))
+	}
+	return w.Body.String()
+}
+
+func TestCounters(t *testing.T) {
+	r := NewRegistry()
+	c := &CounterVec{newFamily("requests_total", "Requests\nhandled.", []string{"code"})}
+	r.register(c)
+	c.With("200").Inc()
+	c.With("200").Add(2.5)
+	c.With("200").Add(-10)
+	c.With("500").Inc()
+
+	want := "# HELP requests_total Requests\\nhandled.\n" +
+		"# TYPE requests_total counter\n" +
+		"requests_total{code=\"200\"} 3.5\n" +
+		"requests_total{code=\"500\"} 1\n"
+	if got := scrape(t, r); got != want {
+		t.Errorf("scraped\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestGaugesRefreshedOnCollect(t *testing.T) {
+	r := NewRegistry()
+	g := &GaugeVec{newFamily("listeners", "Listeners open.", nil)}
+	r.register(g)
+	g.With().Add(3)
+	g.With().Add(-1)
+
+	collected := 0
+	r.OnCollect(func() {
+		collected++
+		g.With().Set(float64(10 * collected))
+	})
+	if got := scrape(t, r); !strings.Contains(got, "# TYPE listeners gauge\nlisteners 10\n") {
+		t.Errorf("scraped %q, want the gauge set by the hook", got)
+	}
+	if got := scrape(t, r); !strings.Contains(got, "listeners 20\n") {
+		t.Errorf("scraped %q, want the hook called on every scrape", got)
+	}
+}
+
+func TestHistogramBucketsAreCumulative(t *testing.T) {
+	r := NewRegistry()
+	h := &HistogramVec{newFamily("duration_seconds", "Durations.", []string{"route"}), []float64{0.1, 1}}
+	r.register(h)
+	for _, v := range []float64{0.05, 0.1, 0.5, 3} {
+		h.With("/rooms").Observe(v)
+	}
+
+	want := "duration_seconds_bucket{route=\"/rooms\",le=\"0.1\"} 2\n" +
+		"duration_seconds_bucket{route=\"/rooms\",le=\"1\"} 3\n" +
+		"duration_seconds_bucket{route=\"/rooms\",le=\"+Inf\"} 4\n" +
+		"duration_seconds_sum{route=\"/rooms\"} 3.65\n" +
+		"duration_seconds_count{route=\"/rooms\"} 4\n"
+	if got := scrape(t, r); !strings.HasSuffix(got, want) {
+		t.Errorf("scraped\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelValuesEscaped(t *testing.T) {
+	if got := renderLabels([]string{"path", "user"}, []string{`a"b\c`, "x\ny"}); got != `{path="a\"b\\c",user="x\ny"}` {
+		t.Errorf("labels rendered as %s", got)
+	}
+	if got := withLabel("", "le", "1"); got != `{le="1"}` {
+		t.Errorf("bucket label without other labels rendered as %s", got)
+	}
+}
+
+func TestRegistryMisuse(t *testing.T) {
+	r := NewRegistry()
+	r.register(&CounterVec{newFamily("requests_total", "", nil)})
+	for name, misuse := range map[string]func(){
+		"name registered twice": func() { r.register(&GaugeVec{newFamily("requests_total", "", nil)}) },
+		"missing label value":   func() { (&CounterVec{newFamily("errors_total", "", []string{"code"})}).With() },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			misuse()
+		}()
+	}
+}
@@ -0,0 +1,180 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry the New* functions register on, served on /metrics
+var Default = NewRegistry()
+
+// collector is a metric family written in the Prometheus text format
+type collector interface {
+	describe() (name, help, kind string)
+	write(w *bufio.Writer)
+}
+
+/*
+Registry holds metric families and writes them in the Prometheus text
+exposition format. Families are written in the order they were registered.
+*/
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+	hooks      []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: map[string]bool{},
+	}
+}
+
+// register adds a family, a name registered twice is a programming error
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name, _, _ := c.describe()
+	if r.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	r.names[name] = true
+	r.collectors = append(r.collectors, c)
+}
+
+/*
+OnCollect adds a function called before every scrape, to refresh the gauges
+whose value is read from elsewhere, such as the state of the room manager.
+*/
+func (r *Registry) OnCollect(hook func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.hooks = append(r.hooks, hook)
+}
+
+// ServeHTTP writes every family of the registry, it makes Registry the /metrics handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(out, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(out, "# TYPE %s %s\n", name, kind)
+		c.write(out)
+	}
+	out.Flush()
+}
+
+// series is a set of label values, rendered once as {name="value",...}
+type series struct {
+	key    string
+	labels string
+}
+
+// family indexes the series of a metric by their label values
+type family struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]interface{}
+}
+
+func newFamily(name, help string, labels []string) family {
+	return family{
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: map[string]interface{}{},
+	}
+}
+
+// get returns the series of the label values, created by create on first use
+func (f *family) get(values []string, create func(series) interface{}) interface{} {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s := create(series{key: key, labels: renderLabels(f.labels, values)})
+	f.series[key] = s
+	return s
+}
+
+// each calls fn on every series, sorted by label values so that scrapes are stable
+func (f *family) each(fn func(interface{})) {
+	f.mu.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	all := make([]interface{}, len(keys))
+	for i, key := range keys {
+		all[i] = f.series[key]
+	}
+	f.mu.Unlock()
+
+	for _, s := range all {
+		fn(s)
+	}
+}
+
+func renderLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + escapeLabel(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withLabel adds a label to rendered labels, for the le label of the histogram buckets
+func withLabel(labels, name, value string) string {
+	pair := name + `="` + escapeLabel(value) + `"`
+	if labels == "" {
+		return "{" + pair + "}"
+	}
+	return labels[:len(labels)-1] + "," + pair + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
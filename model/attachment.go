@@ -0,0 +1,18 @@
+package model
+
+import "gorm.io/gorm"
+
+/*
+Attachment is a file uploaded in a room, its content is kept in the BlobStore under Key.
+It stays unattached (MessageId nil) until its author references it in a message.
+*/
+type Attachment struct {
+	gorm.Model
+	RoomId      string `json:"roomId" gorm:"size:191;index"`
+	UserId      string `json:"userId" gorm:"size:191"`
+	MessageId   *uint  `json:"messageId" gorm:"index"`
+	Key         string `json:"-"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
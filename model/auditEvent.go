@@ -0,0 +1,79 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AuditLogin            = "auth.login"
+	AuditLoginFailed      = "auth.login_failed"
+	AuditTokenRefreshed   = "auth.token_refreshed"
+	AuditRefreshFailed    = "auth.refresh_failed"
+	AuditUserUpdated      = "user.updated"
+	AuditUserDeleted      = "user.deleted"
+	AuditRoomDeleted      = "room.deleted"
+	AuditRoomClosed       = "room.closed"
+	AuditModerationPrefix = "moderation."
+	// Actions of the administrators on the running rooms
+	AuditListenerDisconnected = "admin.listener_disconnected"
+	AuditAnnouncement         = "admin.announcement"
+)
+
+// ErrAuditAppendOnly is returned when an audit event is about to be changed or deleted
+var ErrAuditAppendOnly = errors.New("audit events cannot be changed")
+
+/*
+AuditEvent is an entry of the audit log, kept for security-relevant actions.
+The log is append-only: the hooks below reject any update or delete through GORM.
+*/
+type AuditEvent struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"timestamp" gorm:"index"`
+	// The user at the origin of the action, nil when unknown such as for a failed login
+	ActorId *uint  `json:"actorId" gorm:"index"`
+	Action  string `json:"action" gorm:"size:64;index"`
+	// What the action was done on, such as "user:12" or "room:general"
+	Target    string        `json:"target" gorm:"size:191"`
+	IP        string        `json:"ip" gorm:"size:64"`
+	UserAgent string        `json:"userAgent" gorm:"size:255"`
+	Metadata  AuditMetadata `json:"metadata" gorm:"type:text"`
+}
+
+// AuditMetadata holds the details of an audit event, stored as JSON
+type AuditMetadata map[string]interface{}
+
+func (m AuditMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func (m *AuditMetadata) Scan(value interface{}) error {
+	switch data := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(data, m)
+	case string:
+		return json.Unmarshal([]byte(data), m)
+	}
+
+	return errors.New("invalid audit metadata")
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditAppendOnly
+}
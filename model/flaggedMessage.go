@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRemoved  = "removed"
+)
+
+// FlaggedMessage is a message flagged by the content filters, waiting for a moderator to review it
+type FlaggedMessage struct {
+	gorm.Model
+	MessageId uint   `json:"messageId" gorm:"index"`
+	RoomId    string `json:"roomId" gorm:"size:191;index"`
+	UserId    string `json:"userId" gorm:"size:191"`
+	// Text of the message when it was flagged
+	Text         string     `json:"text"`
+	Reasons      string     `json:"reasons"`
+	Status       string     `json:"status" gorm:"size:16;index"`
+	ReviewedById *uint      `json:"reviewedById"`
+	ReviewedAt   *time.Time `json:"reviewedAt"`
+}
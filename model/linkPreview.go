@@ -0,0 +1,21 @@
+package model
+
+import "time"
+
+/*
+LinkPreview caches the preview of a URL posted in messages, shared by every message linking to it.
+A URL that could not be previewed is kept with Failed set, so that it is not fetched again
+before the cache expires.
+*/
+type LinkPreview struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"-"`
+	UpdatedAt   time.Time `json:"-"`
+	URLHash     string    `json:"-" gorm:"size:64;uniqueIndex"`
+	URL         string    `json:"url" gorm:"size:2048"`
+	Title       string    `json:"title" gorm:"size:1024"`
+	Description string    `json:"description" gorm:"size:4096"`
+	Image       string    `json:"image" gorm:"size:2048"`
+	SiteName    string    `json:"siteName" gorm:"size:1024"`
+	Failed      bool      `json:"-"`
+}
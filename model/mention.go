@@ -0,0 +1,10 @@
+package model
+
+// Mention records that a message mentions a user
+type Mention struct {
+	ID        uint `json:"-" gorm:"primarykey"`
+	MessageId uint `json:"messageId" gorm:"index"`
+	UserId    uint `json:"userId" gorm:"index"`
+	Offset    int  `json:"offset"`
+	Length    int  `json:"length"`
+}
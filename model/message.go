@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"github.com/riri95500/go-chat/markdown"
+	"gorm.io/gorm"
+)
+
+/*
+Message is a chat message posted in a room. Deleting a message is a soft delete,
+the row stays as a tombstone with DeletedAt and DeletedById set.
+A reply references the first message of its thread with ParentId.
+*/
+type Message struct {
+	gorm.Model
+	RoomId      string        `json:"roomId" gorm:"size:191;index"`
+	UserId      string        `json:"userId" gorm:"size:191;index"`
+	Text        string        `json:"text"`
+	ParentId    *uint         `json:"parentId" gorm:"index"`
+	QuoteId     *uint         `json:"quoteId"`
+	ReplyCount  int           `json:"replyCount"`
+	EditedAt    *time.Time    `json:"editedAt"`
+	DeletedById *uint         `json:"deletedById"`
+	Edits       []MessageEdit `json:"-"`
+	Mentions    []Mention     `json:"mentions"`
+	Attachments []Attachment  `json:"attachments"`
+	Previews    []LinkPreview `json:"previews" gorm:"many2many:message_previews"`
+	// Filled on history queries only
+	Reactions []ReactionSummary `json:"reactions" gorm:"-"`
+	// Text parsed as Markdown, filled on queries
+	Content *markdown.Node `json:"content,omitempty" gorm:"-"`
+}
+
+// MessageEdit keeps the text a message had before one of its edits
+type MessageEdit struct {
+	gorm.Model
+	MessageId uint   `json:"messageId" gorm:"index"`
+	Text      string `json:"text"`
+}
+
+type MessageUpdateDTO struct {
+	Text string `json:"text"`
+}
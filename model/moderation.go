@@ -0,0 +1,56 @@
+package model
+
+import "time"
+
+const (
+	ModerationKick     = "kick"
+	ModerationBan      = "ban"
+	ModerationUnban    = "unban"
+	ModerationMute     = "mute"
+	ModerationUnmute   = "unmute"
+	ModerationSlowMode = "slow_mode"
+	// Actions taken on reports, which only go to the log
+	ModerationDeleteMessage = "delete_message"
+	ModerationDismissReport = "dismiss_report"
+	// Changes of the role of a member, only an administrator sets the owner
+	ModerationGrantModerator  = "grant_moderator"
+	ModerationRevokeModerator = "revoke_moderator"
+	ModerationSetOwner        = "set_owner"
+)
+
+// ModerationAction is an entry of the moderation log of a room
+type ModerationAction struct {
+	ID          uint      `json:"id" gorm:"primarykey"`
+	CreatedAt   time.Time `json:"createdAt"`
+	RoomId      string    `json:"roomId" gorm:"size:191;index"`
+	ModeratorId uint      `json:"moderatorId"`
+	Action      string    `json:"action" gorm:"size:32"`
+	// The user targeted, nil for the actions on the whole room such as slow mode
+	UserId *uint `json:"userId"`
+	// The message concerned, for a deleted message
+	MessageId *uint `json:"messageId"`
+	// End of a ban or a mute, nil when it has no end
+	ExpiresAt *time.Time `json:"expiresAt"`
+	// Interval in seconds of the slow mode, 0 when it is disabled
+	SlowMode int    `json:"slowMode"`
+	Reason   string `json:"reason" gorm:"size:1024"`
+}
+
+/*
+RoomRestriction is a ban or a mute (Kind ModerationBan or ModerationMute) of a user in a room
+which is still in force. Lifting it deletes the row, expired rows are ignored.
+*/
+type RoomRestriction struct {
+	ID        uint       `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	RoomId    string     `json:"roomId" gorm:"size:191;uniqueIndex:idx_restriction"`
+	UserId    uint       `json:"userId" gorm:"uniqueIndex:idx_restriction"`
+	Kind      string     `json:"kind" gorm:"size:16;uniqueIndex:idx_restriction"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// Active tells if the restriction is still in force at the given time
+func (r *RoomRestriction) Active(now time.Time) bool {
+	return r.ExpiresAt == nil || now.Before(*r.ExpiresAt)
+}
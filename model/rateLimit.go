@@ -0,0 +1,10 @@
+package model
+
+import "time"
+
+// RateLimitBucket is a token bucket shared by the replicas through the database
+type RateLimitBucket struct {
+	BucketKey string    `gorm:"size:191;primaryKey"`
+	Tokens    float64   `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false;index"`
+}
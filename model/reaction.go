@@ -0,0 +1,23 @@
+package model
+
+import "time"
+
+/*
+Reaction is an emoji added by a user on a message.
+A user can add each emoji only once per message, enforced by the unique index.
+*/
+type Reaction struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"createdAt"`
+	MessageId uint      `json:"messageId" gorm:"uniqueIndex:idx_reaction"`
+	UserId    uint      `json:"userId" gorm:"uniqueIndex:idx_reaction"`
+	Emoji     string    `json:"emoji" gorm:"size:64;uniqueIndex:idx_reaction"`
+}
+
+// ReactionSummary aggregates the reactions of a message for one emoji
+type ReactionSummary struct {
+	Emoji string `json:"emoji"`
+	Count int    `json:"count"`
+	// Whether the user asking reacted with this emoji
+	Me bool `json:"me"`
+}
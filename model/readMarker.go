@@ -0,0 +1,22 @@
+package model
+
+import "time"
+
+// ReadMarker is the last message of a room read by a user
+type ReadMarker struct {
+	ID                uint      `json:"-" gorm:"primarykey"`
+	UpdatedAt         time.Time `json:"updatedAt"`
+	UserId            uint      `json:"userId" gorm:"uniqueIndex:idx_read_marker"`
+	RoomId            string    `json:"roomId" gorm:"size:191;uniqueIndex:idx_read_marker"`
+	LastReadMessageId uint      `json:"lastReadMessageId"`
+}
+
+// RoomSummary is a room of a user as shown in their room listing
+type RoomSummary struct {
+	RoomId            string    `json:"roomId"`
+	Kind              string    `json:"kind"`
+	Role              string    `json:"role"`
+	LastActivityAt    time.Time `json:"lastActivityAt"`
+	LastReadMessageId uint      `json:"lastReadMessageId"`
+	UnreadCount       int       `json:"unreadCount"`
+}
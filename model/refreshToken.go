@@ -1,6 +1,7 @@
 package model
 
 import (
+	"log/slog"
 	"time"
 
 	"gorm.io/gorm"
@@ -14,6 +15,11 @@ type RefreshToken struct {
 	Hash   string `json:"hash" gorm:"<-:create unique"`
 }
 
+// LogValue keeps the hash out of the logs, it is the token itself
+func (rt *RefreshToken) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(rt.ID)), slog.Int("userId", rt.UserId))
+}
+
 func (rt *RefreshToken) BeforeCreate(tx *gorm.DB) (err error) {
 	rt.CreatedAt = time.Now()
 	rt.UpdatedAt = time.Now()
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReportPending   = "pending"
+	ReportDismissed = "dismissed"
+	ReportResolved  = "resolved"
+)
+
+/*
+Report is a complaint of a user about a message or another user of a room,
+queued for the moderators of the room. Resolution is the action the moderator took.
+*/
+type Report struct {
+	gorm.Model
+	ReporterId uint   `json:"reporterId" gorm:"index"`
+	RoomId     string `json:"roomId" gorm:"size:191;index"`
+	// The message reported, nil when a user is reported
+	MessageId *uint `json:"messageId"`
+	// The user reported, the author of the message for a message
+	UserId       uint       `json:"userId"`
+	Reason       string     `json:"reason" gorm:"size:1024"`
+	Status       string     `json:"status" gorm:"size:16;index"`
+	Resolution   string     `json:"resolution" gorm:"size:32"`
+	ResolvedById *uint      `json:"resolvedById"`
+	ResolvedAt   *time.Time `json:"resolvedAt"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	RoomKindPublic = "room"
+	RoomKindDirect = "dm"
+)
+
+/*
+Room is a persisted room. Public rooms are open to anyone while direct
+conversations (Kind RoomKindDirect) are restricted to their members.
+*/
+type Room struct {
+	gorm.Model
+	RoomId         string    `json:"roomId" gorm:"size:191;uniqueIndex"`
+	Kind           string    `json:"kind"`
+	LastActivityAt time.Time `json:"lastActivityAt" gorm:"index"`
+	// Minimum delay in seconds between two messages of a user, 0 when slow mode is disabled
+	SlowMode int `json:"slowMode"`
+	// Limits of the room replacing the global ones, 0 keeps the global limit
+	MaxSubscribers int `json:"maxSubscribers"`
+	MaxMessageSize int `json:"maxMessageSize"`
+	HistoryDepth   int `json:"historyDepth"`
+	BufferSize     int `json:"bufferSize"`
+	// "block" or "drop", empty keeps the global policy
+	SlowConsumerPolicy string       `json:"slowConsumerPolicy" gorm:"size:16"`
+	Members            []RoomMember `json:"members,omitempty" gorm:"foreignKey:RoomId;references:RoomId"`
+}
+
+// IsDirect tells if the room is a direct conversation
+func (r *Room) IsDirect() bool {
+	return r.Kind == RoomKindDirect
+}
@@ -0,0 +1,23 @@
+package model
+
+import "gorm.io/gorm"
+
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// RoomMember records that a user joined a room, and their role in it
+type RoomMember struct {
+	gorm.Model
+	RoomId string `json:"roomId" gorm:"size:191;uniqueIndex:idx_room_user"`
+	UserId uint   `json:"userId" gorm:"uniqueIndex:idx_room_user"`
+	User   User   `json:"-" gorm:"constraint:OnUpdate:CASCADE,OnDelete:CASCADE;"`
+	Role   string `json:"role"`
+}
+
+// IsModerator tells if the member can moderate the room
+func (m *RoomMember) IsModerator() bool {
+	return m.Role == RoleOwner || m.Role == RoleModerator
+}
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"log/slog"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -10,8 +11,16 @@ import (
 // swagger:model
 type User struct {
 	gorm.Model
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	Email    string  `json:"email" gorm:"size:191;uniqueIndex"`
+	Username *string `json:"username" gorm:"size:191;uniqueIndex"`
+	Password string  `json:"-"`
+	// Global administrator, granted through ADMIN_USER_IDS only
+	Admin bool `json:"admin"`
+}
+
+// LogValue keeps the password hash and the email out of the logs
+func (u *User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Uint64("id", uint64(u.ID)), slog.Bool("admin", u.Admin))
 }
 
 /*
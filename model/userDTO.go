@@ -2,9 +2,11 @@ package model
 
 type UserCreateDTO struct {
 	Email    string `json:"email"`
+	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
 type UserUpdateDTO struct {
-	Email string `json:"email"`
+	Email    string `json:"email"`
+	Username string `json:"username"`
 }
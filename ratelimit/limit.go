@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket refilled with Rate tokens per second and holding at most Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited tells if the limit lets every request through
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// Bucket is the token bucket of Key, filled according to Limit
+type Bucket struct {
+	Key   string
+	Limit Limit
+}
+
+type Limiter interface {
+	/*
+		Allow takes a token from every bucket, or from none of them: when one bucket is empty,
+		the request is refused without spending the tokens of the others, and retryAfter tells
+		when every bucket will have a token again.
+	*/
+	Allow(ctx context.Context, buckets ...Bucket) (allowed bool, retryAfter time.Duration, err error)
+}
+
+/*
+take refills a bucket holding tokens since updated, then takes a token from it.
+It returns the tokens left and, when the bucket is empty, how long to wait for a token.
+*/
+func take(limit Limit, tokens float64, updated time.Time, now time.Time) (float64, bool, time.Duration) {
+	elapsed := now.Sub(updated).Seconds()
+	if elapsed > 0 {
+		tokens = math.Min(float64(limit.Burst), tokens+elapsed*limit.Rate)
+	}
+
+	if tokens >= 1 {
+		return tokens - 1, true, 0
+	}
+
+	wait := time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+	return tokens, false, wait
+}
+
+/*
+ParseLimit parses a limit written "<count>/<unit>[:burst]", such as "30/m:10" for 30 requests
+per minute with bursts of 10. The unit is s, m or h, the burst is the count when omitted.
+An empty string, "0" or "off" gives an unlimited Limit.
+*/
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "off" {
+		return Limit{}, nil
+	}
+
+	spec, burstSpec, hasBurst := strings.Cut(s, ":")
+	countSpec, unit, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit: %q", s)
+	}
+
+	count, err := strconv.Atoi(countSpec)
+	if err != nil || count <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit: %q", s)
+	}
+
+	var period time.Duration
+	switch unit {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		return Limit{}, fmt.Errorf("invalid rate limit unit: %q", s)
+	}
+
+	limit := Limit{
+		Rate:  float64(count) / period.Seconds(),
+		Burst: count,
+	}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burstSpec)
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit burst: %q", s)
+		}
+	}
+
+	return limit, nil
+}
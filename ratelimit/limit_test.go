@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	limit := Limit{Rate: 1, Burst: 3}
+	start := time.Now()
+
+	tests := []struct {
+		name       string
+		tokens     float64
+		elapsed    time.Duration
+		wantTokens float64
+		wantOk     bool
+		wantWait   time.Duration
+	}{
+		{"full bucket", 3, 0, 2, true, 0},
+		{"last token", 1, 0, 0, true, 0},
+		{"empty bucket", 0, 0, 0, false, time.Second},
+		{"half a token", 0, 500 * time.Millisecond, 0.5, false, 500 * time.Millisecond},
+		{"refilled", 0, 2 * time.Second, 1, true, 0},
+		{"refilled up to the burst", 0, time.Hour, 2, true, 0},
+	}
+	for _, tt := range tests {
+		tokens, ok, wait := take(limit, tt.tokens, start, start.Add(tt.elapsed))
+		if tokens != tt.wantTokens || ok != tt.wantOk || wait != tt.wantWait {
+			t.Errorf("%s: got %v, %v, %v, want %v, %v, %v", tt.name, tokens, ok, wait, tt.wantTokens, tt.wantOk, tt.wantWait)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Limit
+		wantErr bool
+	}{
+		{"", Limit{}, false},
+		{"off", Limit{}, false},
+		{"0", Limit{}, false},
+		{"30/m", Limit{Rate: 0.5, Burst: 30}, false},
+		{"30/m:10", Limit{Rate: 0.5, Burst: 10}, false},
+		{" 2/s ", Limit{Rate: 2, Burst: 2}, false},
+		{"3600/h:1", Limit{Rate: 1, Burst: 1}, false},
+		{"30", Limit{}, true},
+		{"30/d", Limit{}, true},
+		{"-1/m", Limit{}, true},
+		{"x/m", Limit{}, true},
+		{"30/m:0", Limit{}, true},
+		{"30/m:x", Limit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseLimit(%q) error %v", tt.spec, err)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+	if !(Limit{}).Unlimited() {
+		t.Error("the zero Limit is limited")
+	}
+}
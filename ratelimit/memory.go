@@ -0,0 +1,87 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// MemoryLimiter keeps the buckets in memory, each replica then has its own limits
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (l *MemoryLimiter) Allow(ctx context.Context, buckets ...Bucket) (bool, time.Duration, error) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > sweepInterval {
+		l.sweep(now)
+	}
+
+	allowed := true
+	var retryAfter time.Duration
+	left := make([]float64, len(buckets))
+	for i, b := range buckets {
+		if b.Limit.Unlimited() {
+			continue
+		}
+		tokens, updated := float64(b.Limit.Burst), now
+		if current, ok := l.buckets[b.Key]; ok {
+			tokens, updated = current.tokens, current.updated
+		}
+
+		var ok bool
+		var wait time.Duration
+		left[i], ok, wait = take(b.Limit, tokens, updated, now)
+		if !ok {
+			allowed = false
+			retryAfter = max(retryAfter, wait)
+		}
+	}
+	// Refusée, la requête ne consomme rien
+	if !allowed {
+		return false, retryAfter, nil
+	}
+
+	for i, b := range buckets {
+		if b.Limit.Unlimited() {
+			continue
+		}
+		l.buckets[b.Key] = &bucket{
+			tokens:  left[i],
+			updated: now,
+			limit:   b.Limit,
+		}
+	}
+
+	return true, 0, nil
+}
+
+// sweep forgets the buckets that are full again, they are recreated full when needed
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
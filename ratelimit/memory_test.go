@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiterSpendsTheBurst(t *testing.T) {
+	l := NewMemoryLimiter()
+	bucket := Bucket{Key: "user", Limit: Limit{Rate: 1.0 / 60, Burst: 2}}
+
+	for i := 0; i < 2; i++ {
+		if allowed, _, _ := l.Allow(context.Background(), bucket); !allowed {
+			t.Fatalf("request %d refused within the burst", i+1)
+		}
+	}
+	allowed, retryAfter, err := l.Allow(context.Background(), bucket)
+	if err != nil || allowed {
+		t.Fatalf("request beyond the burst: %v, %v", allowed, err)
+	}
+	if retryAfter <= 59*time.Second || retryAfter > time.Minute {
+		t.Errorf("retry after %v, want about a minute", retryAfter)
+	}
+
+	// Les autres clés ont leur propre bucket
+	if allowed, _, _ := l.Allow(context.Background(), Bucket{Key: "other", Limit: bucket.Limit}); !allowed {
+		t.Error("another key shares the bucket")
+	}
+}
+
+func TestMemoryLimiterTakesFromAllBucketsOrNone(t *testing.T) {
+	l := NewMemoryLimiter()
+	ip := Bucket{Key: "ip", Limit: Limit{Rate: 1.0 / 60, Burst: 2}}
+	user := Bucket{Key: "user", Limit: Limit{Rate: 1.0 / 60, Burst: 1}}
+
+	if allowed, _, _ := l.Allow(context.Background(), ip, user); !allowed {
+		t.Fatal("first request refused")
+	}
+	// Le bucket de l'utilisateur est vide : celui de l'IP ne doit rien perdre
+	for i := 0; i < 3; i++ {
+		if allowed, _, _ := l.Allow(context.Background(), ip, user); allowed {
+			t.Fatal("request allowed by an empty bucket")
+		}
+	}
+	if allowed, _, _ := l.Allow(context.Background(), ip); !allowed {
+		t.Error("the refused requests spent the tokens of the IP")
+	}
+	if allowed, _, _ := l.Allow(context.Background(), ip); allowed {
+		t.Error("the IP bucket holds more than its burst")
+	}
+}
+
+func TestMemoryLimiterUnlimited(t *testing.T) {
+	l := NewMemoryLimiter()
+	for i := 0; i < 100; i++ {
+		if allowed, _, _ := l.Allow(context.Background(), Bucket{Key: "user"}); !allowed {
+			t.Fatal("an unlimited bucket refused a request")
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("%d buckets kept for unlimited keys", len(l.buckets))
+	}
+}
+
+func TestMemoryLimiterSweepsFullBuckets(t *testing.T) {
+	l := NewMemoryLimiter()
+	l.Allow(context.Background(), Bucket{Key: "fast", Limit: Limit{Rate: 1000, Burst: 1}})
+	l.Allow(context.Background(), Bucket{Key: "slow", Limit: Limit{Rate: 1.0 / 3600, Burst: 1}})
+
+	l.sweep(time.Now().Add(time.Second))
+	if _, ok := l.buckets["fast"]; ok {
+		t.Error("a full bucket was kept")
+	}
+	if _, ok := l.buckets["slow"]; !ok {
+		t.Error("an empty bucket was forgotten")
+	}
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/riri95500/go-chat/config"
+	"gorm.io/gorm"
+)
+
+// Rule is the rate limit of a route
+type Rule struct {
+	// Name of the route, the routes sharing a name share their buckets
+	Name string
+	// Limit of every authenticated user in each room
+	User Limit
+	// Limit of every client IP, all rooms together
+	IP Limit
+	// Limits replacing User in some rooms
+	Rooms map[string]Limit
+}
+
+// UserLimit returns the limit of the users in the room
+func (r Rule) UserLimit(roomid string) Limit {
+	if limit, ok := r.Rooms[roomid]; ok {
+		return limit
+	}
+
+	return r.User
+}
+
+// Rules are the rate limits of the routes
+type Rules struct {
+	// Sending messages, through the API and the HTML adapter
+	Messages Rule
+	// Uploading attachments
+	Uploads Rule
+}
+
+/*
+NewRules builds the Rules from the application Config. By default a user can send
+30 messages per minute in each room with bursts of 10, and an IP 120 per minute with
+bursts of 30. Uploads are limited to 10 per minute per user and 30 per minute per IP.
+RATE_LIMIT_ROOMS replaces the message limit of the users in some rooms, it is written
+"roomid=limit,roomid=limit" with the limits written as for ParseLimit.
+*/
+func NewRules(conf *config.Config) (Rules, error) {
+	rules := Rules{
+		Messages: Rule{Name: "messages", Rooms: map[string]Limit{}},
+		Uploads:  Rule{Name: "uploads"},
+	}
+
+	specs := []struct {
+		value    string
+		fallback string
+		limit    *Limit
+	}{
+		{conf.RATE_LIMIT_MESSAGES, "30/m:10", &rules.Messages.User},
+		{conf.RATE_LIMIT_MESSAGES_IP, "120/m:30", &rules.Messages.IP},
+		{conf.RATE_LIMIT_UPLOADS, "10/m:5", &rules.Uploads.User},
+		{conf.RATE_LIMIT_UPLOADS_IP, "30/m:10", &rules.Uploads.IP},
+	}
+	for _, spec := range specs {
+		value := spec.value
+		if value == "" {
+			value = spec.fallback
+		}
+
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return rules, err
+		}
+		*spec.limit = limit
+	}
+
+	for _, entry := range conf.RATE_LIMIT_ROOMS {
+		roomid, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return rules, fmt.Errorf("invalid room rate limit: %q", entry)
+		}
+
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return rules, err
+		}
+		rules.Messages.Rooms[strings.TrimSpace(roomid)] = limit
+	}
+
+	return rules, nil
+}
+
+/*
+NewLimiter creates the Limiter chosen by RATE_LIMIT_STORE: "memory" (the default) keeps
+the buckets in each replica, "db" shares them between replicas through the database.
+The buckets of the database unused for an hour are purged every hour.
+*/
+func NewLimiter(conf *config.Config, db *gorm.DB, logger *slog.Logger) (Limiter, error) {
+	switch conf.RATE_LIMIT_STORE {
+	case "", "memory":
+		return NewMemoryLimiter(), nil
+	case "db":
+		limiter := NewStoreLimiter(db)
+		go func() {
+			for range time.Tick(time.Hour) {
+				if err := limiter.Purge(time.Now().Add(-time.Hour)); err != nil {
+					logger.Error("rate limit buckets not purged", "error", err)
+				}
+			}
+		}()
+		return limiter, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store: %q", conf.RATE_LIMIT_STORE)
+	}
+}
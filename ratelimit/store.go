@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+/*
+StoreLimiter keeps the buckets in the database so that every replica shares them.
+Each request locks its bucket rows for the time of a transaction.
+*/
+type StoreLimiter struct {
+	db *gorm.DB
+}
+
+func NewStoreLimiter(db *gorm.DB) *StoreLimiter {
+	return &StoreLimiter{
+		db: db,
+	}
+}
+
+func (l *StoreLimiter) Allow(ctx context.Context, buckets ...Bucket) (bool, time.Duration, error) {
+	limits := map[string]Limit{}
+	keys := []string{}
+	for _, b := range buckets {
+		if !b.Limit.Unlimited() {
+			limits[b.Key] = b.Limit
+			keys = append(keys, b.Key)
+		}
+	}
+	if len(keys) == 0 {
+		return true, 0, nil
+	}
+	// Verrouillées toujours dans le même ordre, deux requêtes ne s'interbloquent pas
+	sort.Strings(keys)
+
+	allowed := true
+	var retryAfter time.Duration
+	err := l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		missing := []model.RateLimitBucket{}
+		for _, key := range keys {
+			missing = append(missing, model.RateLimitBucket{
+				BucketKey: key,
+				Tokens:    float64(limits[key].Burst),
+				UpdatedAt: now,
+			})
+		}
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&missing).Error
+		if err != nil {
+			return err
+		}
+
+		var rows []model.RateLimitBucket
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("bucket_key IN ?", keys).Order("bucket_key").Find(&rows).Error
+		if err != nil {
+			return err
+		}
+
+		left := map[string]float64{}
+		for _, b := range rows {
+			tokens, ok, wait := take(limits[b.BucketKey], b.Tokens, b.UpdatedAt, now)
+			left[b.BucketKey] = tokens
+			if !ok {
+				allowed = false
+				retryAfter = max(retryAfter, wait)
+			}
+		}
+		// Refusée, la requête ne consomme rien
+		if !allowed {
+			return nil
+		}
+
+		for key, tokens := range left {
+			err := tx.Model(&model.RateLimitBucket{}).Where("bucket_key = ?", key).Updates(map[string]interface{}{
+				"tokens":     tokens,
+				"updated_at": now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, 0, err
+	}
+
+	return allowed, retryAfter, nil
+}
+
+// Purge deletes the buckets not used since the given date, to run from time to time
+func (l *StoreLimiter) Purge(before time.Time) error {
+	return l.db.Where("updated_at < ?", before).Delete(&model.RateLimitBucket{}).Error
+}
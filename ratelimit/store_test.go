@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/internal/fakedb"
+)
+
+// storeLimiter answers the bucket rows with the tokens left in each, last updated now
+func storeLimiter(t *testing.T, tokens map[string]float64) (*StoreLimiter, *fakedb.DB) {
+	t.Helper()
+	now := time.Now()
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, "FROM `rate_limit_buckets`") {
+			return nil, nil
+		}
+		rows := [][]driver.Value{}
+		for _, arg := range args {
+			key, _ := arg.(string)
+			if left, ok := tokens[key]; ok {
+				rows = append(rows, []driver.Value{key, left, now})
+			}
+		}
+		return []string{"bucket_key", "tokens", "updated_at"}, rows
+	})
+	return NewStoreLimiter(db), fake
+}
+
+func TestStoreLimiter(t *testing.T) {
+	limit := Limit{Rate: 1.0 / 60, Burst: 5}
+	tests := []struct {
+		name        string
+		tokens      map[string]float64
+		wantAllowed bool
+	}{
+		{"both buckets have tokens", map[string]float64{"ip": 3, "user": 1}, true},
+		{"the user bucket is empty", map[string]float64{"ip": 3, "user": 0}, false},
+		{"the IP bucket is empty", map[string]float64{"ip": 0, "user": 4}, false},
+	}
+	for _, tt := range tests {
+		l, fake := storeLimiter(t, tt.tokens)
+		allowed, retryAfter, err := l.Allow(context.Background(), Bucket{"user", limit}, Bucket{"ip", limit})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if allowed != tt.wantAllowed {
+			t.Errorf("%s: allowed %v", tt.name, allowed)
+		}
+		if !allowed && retryAfter <= 0 {
+			t.Errorf("%s: refused without a delay", tt.name)
+		}
+		// Une requête refusée ne consomme aucun bucket
+		if spent := fake.Ran("UPDATE `rate_limit_buckets`"); spent != tt.wantAllowed {
+			t.Errorf("%s: tokens spent %v", tt.name, spent)
+		}
+		if !fake.Ran("FOR UPDATE") {
+			t.Errorf("%s: the buckets were not locked", tt.name)
+		}
+	}
+}
+
+func TestStoreLimiterUnlimited(t *testing.T) {
+	l, fake := storeLimiter(t, nil)
+	allowed, _, err := l.Allow(context.Background(), Bucket{Key: "user"})
+	if err != nil || !allowed {
+		t.Errorf("unlimited bucket: %v, %v", allowed, err)
+	}
+	if fake.Ran("rate_limit_buckets") {
+		t.Error("an unlimited bucket was stored")
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrRoomNotRunning    = errors.New("room is not running")
+	ErrListenerNotFound  = errors.New("listener not found")
+	ErrEmptyAnnouncement = errors.New("announcement is empty")
+)
+
+// RoomInfo describes a running room to the administrators
+type RoomInfo struct {
+	RoomId string `json:"roomId"`
+	// Listeners open in the room, anonymous ones included
+	Listeners int `json:"listeners"`
+	// Distinct users having a listener open
+	Users int `json:"users"`
+	// Messages broadcast since the room started, it restarts after being idle
+	Messages          int       `json:"messages"`
+	MessagesPerMinute int       `json:"messagesPerMinute"`
+	LastActivity      time.Time `json:"lastActivity"`
+	// Interval in seconds of the slow mode, 0 when disabled
+	SlowMode int `json:"slowMode"`
+}
+
+// ListenerInfo describes a listener open in a room to the administrators
+type ListenerInfo struct {
+	Id string `json:"id"`
+	// Empty for an anonymous listener
+	UserId      string    `json:"userId,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	ConnectedAt time.Time `json:"connectedAt"`
+}
+
+// inspectRequest asks for every running room, or for the listeners of one room when RoomId is set
+type inspectRequest struct {
+	RoomId    string
+	rooms     chan []RoomInfo
+	listeners chan []ListenerInfo
+}
+
+type disconnectRequest struct {
+	RoomId     string
+	ListenerId string
+	err        chan error
+}
+
+// announceRequest sends a system notice to RoomId, or to every room when it is empty
+type announceRequest struct {
+	RoomId string
+	Text   string
+	rooms  chan int
+}
+
+/*
+Rooms returns the running rooms, the most recently active first.
+The user rooms, which only carry notifications, are left out.
+*/
+func (m *manager) Rooms() []RoomInfo {
+	req := &inspectRequest{
+		rooms: make(chan []RoomInfo, 1),
+	}
+	m.inspect <- req
+	return <-req.rooms
+}
+
+/*
+Listeners returns the listeners open in the room, the oldest first.
+
+Parameters:
+  - roomid (string): the room
+
+Returns:
+  - ([]ListenerInfo): the listeners
+  - (error): ErrRoomNotRunning if the room is not running
+*/
+func (m *manager) Listeners(roomid string) ([]ListenerInfo, error) {
+	req := &inspectRequest{
+		RoomId:    roomid,
+		listeners: make(chan []ListenerInfo, 1),
+	}
+	m.inspect <- req
+	listeners := <-req.listeners
+	if listeners == nil {
+		return nil, ErrRoomNotRunning
+	}
+
+	return listeners, nil
+}
+
+/*
+Disconnect closes a listener of the room, the stream using it stops as if the
+room was full. The client is free to connect again.
+
+Parameters:
+  - roomid (string): the room
+  - listenerId (string): the Id of the listener, as returned by Listeners
+
+Returns:
+  - (error): ErrRoomNotRunning, or ErrListenerNotFound if the listener is already closed
+*/
+func (m *manager) Disconnect(roomid, listenerId string) error {
+	req := &disconnectRequest{
+		RoomId:     roomid,
+		ListenerId: listenerId,
+		err:        make(chan error, 1),
+	}
+	m.disconnect <- req
+	return <-req.err
+}
+
+/*
+Announce sends a system notice from the server to the listeners of a running room.
+
+Parameters:
+  - roomid (string): the room
+  - text (string): the notice
+
+Returns:
+  - (error): ErrEmptyAnnouncement, or ErrRoomNotRunning if nobody would receive it
+*/
+func (m *manager) Announce(roomid, text string) error {
+	if strings.TrimSpace(text) == "" {
+		return ErrEmptyAnnouncement
+	}
+
+	req := &announceRequest{
+		RoomId: roomid,
+		Text:   text,
+		rooms:  make(chan int, 1),
+	}
+	m.announce <- req
+	if <-req.rooms == 0 {
+		return ErrRoomNotRunning
+	}
+
+	return nil
+}
+
+/*
+AnnounceAll sends a system notice from the server to every running room but the user rooms,
+so that a user streaming several rooms gets it once per room. An empty text is not sent.
+It returns the number of rooms reached.
+*/
+func (m *manager) AnnounceAll(text string) int {
+	if strings.TrimSpace(text) == "" {
+		return 0
+	}
+
+	req := &announceRequest{
+		Text:  text,
+		rooms: make(chan int, 1),
+	}
+	m.announce <- req
+	return <-req.rooms
+}
+
+/*
+CloseRoom closes a running room like DeleteBroadcast, its listeners receive a
+room.closed event with the reason. It is not audited, the caller knows the administrator.
+
+Returns:
+  - (error): ErrRoomNotRunning if the room is not running
+*/
+func (m *manager) CloseRoom(roomid, reason string) error {
+	req := &closeRequest{
+		RoomId: roomid,
+		Reason: reason,
+		err:    make(chan error, 1),
+	}
+	m.delete <- req
+	return <-req.err
+}
+
+func (m *manager) inspectRooms(req *inspectRequest) {
+	now := time.Now()
+	if req.listeners != nil {
+		r, ok := m.roomChannels[req.RoomId]
+		if !ok {
+			req.listeners <- nil
+			return
+		}
+		listeners := []ListenerInfo{}
+		for _, listener := range r.listeners {
+			listeners = append(listeners, ListenerInfo{
+				Id:          listener.Id,
+				UserId:      listener.UserId,
+				IP:          listener.IP,
+				ConnectedAt: listener.ConnectedAt,
+			})
+		}
+		sort.Slice(listeners, func(i, j int) bool { return listeners[i].ConnectedAt.Before(listeners[j].ConnectedAt) })
+		req.listeners <- listeners
+		return
+	}
+
+	rooms := []RoomInfo{}
+	for roomid, r := range m.roomChannels {
+		if strings.HasPrefix(roomid, UserRoomPrefix) {
+			continue
+		}
+		rooms = append(rooms, RoomInfo{
+			RoomId:            roomid,
+			Listeners:         len(r.listeners),
+			Users:             len(r.presence),
+			Messages:          r.messageCount,
+			MessagesPerMinute: r.messageRate.perMinute(now),
+			LastActivity:      r.lastActivity,
+			SlowMode:          int(r.slowMode / time.Second),
+		})
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].LastActivity.After(rooms[j].LastActivity) })
+	req.rooms <- rooms
+}
+
+func (m *manager) disconnectListener(req *disconnectRequest) {
+	r, ok := m.roomChannels[req.RoomId]
+	if !ok {
+		req.err <- ErrRoomNotRunning
+		return
+	}
+	for _, listener := range r.listeners {
+		if listener.Id == req.ListenerId {
+			m.deregister(listener)
+			req.err <- nil
+			return
+		}
+	}
+	req.err <- ErrListenerNotFound
+}
+
+func (m *manager) announceSystem(req *announceRequest) {
+	targets := m.roomChannels
+	if req.RoomId != "" {
+		targets = map[string]*room{}
+		if r, ok := m.roomChannels[req.RoomId]; ok {
+			targets[req.RoomId] = r
+		}
+	}
+
+	rooms := 0
+	for roomid, r := range targets {
+		if req.RoomId == "" && strings.HasPrefix(roomid, UserRoomPrefix) {
+			continue
+		}
+		// L'annonce ne compte pas comme une activité, une room inactive reste collectable
+		r.broadcaster.Submit(NewEvent(EventSystem, roomid, "", SystemPayload{
+			Text: req.Text,
+		}))
+		rooms++
+	}
+	req.rooms <- rooms
+}
+
+// rateCounter counts events over the last minute, in one second buckets
+type rateCounter struct {
+	buckets [60]int
+	// Dernière seconde comptée, en secondes Unix
+	last int64
+}
+
+func (c *rateCounter) add(now time.Time) {
+	c.advance(now)
+	c.buckets[now.Unix()%60]++
+}
+
+func (c *rateCounter) perMinute(now time.Time) int {
+	c.advance(now)
+	total := 0
+	for _, count := range c.buckets {
+		total += count
+	}
+	return total
+}
+
+// advance empties the buckets of the seconds elapsed since the last call
+func (c *rateCounter) advance(now time.Time) {
+	second := now.Unix()
+	if second <= c.last {
+		return
+	}
+	if second-c.last >= 60 {
+		c.buckets = [60]int{}
+	} else {
+		for s := c.last + 1; s <= second; s++ {
+			c.buckets[s%60] = 0
+		}
+	}
+	c.last = second
+}
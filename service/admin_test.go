@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/model"
+)
+
+// closedListener waits for the listener to be closed, failing the test after a second
+func closedListener(t *testing.T, listener chan interface{}) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-listener:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("listener still open")
+		}
+	}
+}
+
+func TestRateCounter(t *testing.T) {
+	c := &rateCounter{}
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 3; i++ {
+		c.add(start)
+	}
+	c.add(start.Add(30 * time.Second))
+	if got := c.perMinute(start.Add(30 * time.Second)); got != 4 {
+		t.Errorf("%d messages in the last minute, want 4", got)
+	}
+	// Les secondes sorties de la fenêtre sont oubliées
+	if got := c.perMinute(start.Add(60 * time.Second)); got != 1 {
+		t.Errorf("%d messages a minute later, want the last one", got)
+	}
+	if got := c.perMinute(start.Add(10 * time.Minute)); got != 0 {
+		t.Errorf("%d messages after a long silence, want 0", got)
+	}
+	// Une heure passée ne réécrit pas les compteurs
+	c.add(start.Add(10 * time.Minute))
+	if got := c.perMinute(start); got != 1 {
+		t.Errorf("%d messages read back in time, want 1", got)
+	}
+}
+
+func TestRoomsDescribeRunningRooms(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	general := m.OpenUserListener("general", "1", "192.0.2.1")
+	m.OpenUserListener("general", "1", "192.0.2.2")
+	m.OpenListener("random")
+	m.OpenUserListener(UserRoomPrefix+"1", "1", "192.0.2.1")
+	waitListeners(t, m, 4)
+	if event := nextEvent(t, general); event.Type != EventJoined {
+		t.Fatalf("got %s, want the arrival of user 1", event.Type)
+	}
+
+	moderated(t, m, general, &Moderation{Action: model.ModerationSlowMode, RoomId: "general", ModeratorId: "1", SlowMode: time.Minute})
+	if err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "general", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, general); event.Type != EventMessage {
+		t.Fatalf("got %s, want the message", event.Type)
+	}
+
+	rooms := m.Rooms()
+	if len(rooms) != 2 || rooms[0].RoomId != "general" || rooms[1].RoomId != "random" {
+		t.Fatalf("rooms %+v, want general then random and no user room", rooms)
+	}
+	if rooms[0].Listeners != 2 || rooms[0].Users != 1 || rooms[0].Messages != 1 || rooms[0].MessagesPerMinute != 1 || rooms[0].SlowMode != 60 {
+		t.Errorf("general described as %+v", rooms[0])
+	}
+
+	listeners, err := m.Listeners("general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 2 || listeners[0].IP != "192.0.2.1" || listeners[1].IP != "192.0.2.2" || listeners[0].UserId != "1" {
+		t.Errorf("listeners %+v, want both of user 1, the oldest first", listeners)
+	}
+	if _, err := m.Listeners("lobby"); err != ErrRoomNotRunning {
+		t.Errorf("listeners of a stopped room: error %v, want %v", err, ErrRoomNotRunning)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	listener := m.OpenUserListener("general", "2", "192.0.2.1")
+	waitListeners(t, m, 1)
+	listeners, err := m.Listeners("general")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Disconnect("lobby", listeners[0].Id); err != ErrRoomNotRunning {
+		t.Errorf("disconnect in a stopped room: error %v, want %v", err, ErrRoomNotRunning)
+	}
+	if err := m.Disconnect("general", listeners[0].Id); err != nil {
+		t.Fatal(err)
+	}
+	closedListener(t, listener)
+	if err := m.Disconnect("general", listeners[0].Id); err != ErrListenerNotFound {
+		t.Errorf("second disconnect: error %v, want %v", err, ErrListenerNotFound)
+	}
+}
+
+func TestAnnounce(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	general := m.OpenListener("general")
+	random := m.OpenListener("random")
+	user := m.OpenUserListener(UserRoomPrefix+"1", "1", "")
+	waitListeners(t, m, 3)
+	if event := nextEvent(t, user); event.Type != EventJoined {
+		t.Fatalf("got %s, want the arrival of user 1", event.Type)
+	}
+
+	if err := m.Announce("general", "  "); err != ErrEmptyAnnouncement {
+		t.Errorf("empty announcement: error %v, want %v", err, ErrEmptyAnnouncement)
+	}
+	if err := m.Announce("lobby", "maintenance"); err != ErrRoomNotRunning {
+		t.Errorf("announcement in a stopped room: error %v, want %v", err, ErrRoomNotRunning)
+	}
+	if err := m.Announce("general", "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, general)
+	if payload, ok := event.Payload.(SystemPayload); event.Type != EventSystem || !ok || payload.Text != "maintenance" || event.Actor != "" {
+		t.Errorf("announced as %+v", event)
+	}
+	noEvent(t, random)
+
+	if got := m.AnnounceAll(""); got != 0 {
+		t.Errorf("empty announcement sent to %d rooms", got)
+	}
+	if got := m.AnnounceAll("restart"); got != 2 {
+		t.Errorf("announcement sent to %d rooms, want general and random", got)
+	}
+	for _, listener := range []chan interface{}{general, random} {
+		if event := nextEvent(t, listener); event.Type != EventSystem {
+			t.Errorf("got %s, want the announcement", event.Type)
+		}
+	}
+	noEvent(t, user)
+}
+
+func TestCloseRoom(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	if err := m.CloseRoom("lobby", "maintenance"); err != ErrRoomNotRunning {
+		t.Errorf("closing a stopped room: error %v, want %v", err, ErrRoomNotRunning)
+	}
+	if err := m.CloseRoom("general", "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	event := nextEvent(t, listener)
+	if payload, ok := event.Payload.(RoomClosedPayload); event.Type != EventRoomClosed || !ok || payload.Reason != "maintenance" {
+		t.Errorf("closed with %+v", event)
+	}
+	if rooms := m.Rooms(); len(rooms) != 0 {
+		t.Errorf("rooms %+v still running", rooms)
+	}
+}
+
+func TestClosingARoomClosesFullListeners(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.BufferSize = 2
+	options.Room.SlowConsumerPolicy = broadcast.Drop
+	m := startManager(t, options)
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	// Le lecteur ne lit pas : room.closed ne trouve plus de place dans sa channel
+	for i := 0; i < cap(listener); i++ {
+		m.Submit("1", "general", "message")
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := m.CloseRoom("general", "maintenance"); err != nil {
+		t.Fatal(err)
+	}
+	closedListener(t, listener)
+	waitListeners(t, m, 0)
+
+	// Le handler SSE ferme encore son listener en partant, sans effet
+	m.CloseListener("general", listener)
+	if err := m.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
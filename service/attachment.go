@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kjk/betterguid"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/storage"
+	"gorm.io/gorm"
+)
+
+// MaxAttachments is the maximum number of attachments of a single message
+const MaxAttachments = 10
+
+var (
+	ErrAttachmentTooLarge  = errors.New("the attachment is too large")
+	ErrAttachmentType      = errors.New("this type of file is not allowed")
+	ErrUnknownAttachment   = errors.New("the attachment does not exist or is already used")
+	ErrTooManyAttachments  = errors.New("too many attachments")
+	defaultAttachmentTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp", "application/pdf", "text/plain"}
+)
+
+const defaultAttachmentMaxSize = 10 << 20
+
+// Attachment describes a file attached to a message, its content is downloaded separately
+type Attachment struct {
+	Id          string `json:"id"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+}
+
+type AttachmentService struct {
+	db           *gorm.DB
+	store        storage.BlobStore
+	maxSize      int64
+	allowedTypes []string
+}
+
+/*
+NewAttachmentService creates the AttachmentService storing the files in store.
+ATTACHMENT_MAX_SIZE (10MB by default) limits the size of a file and
+ATTACHMENT_ALLOWED_TYPES the accepted types, "image/*" accepting every image.
+*/
+func NewAttachmentService(db *gorm.DB, store storage.BlobStore, conf *config.Config) *AttachmentService {
+	s := &AttachmentService{
+		db:           db,
+		store:        store,
+		maxSize:      defaultAttachmentMaxSize,
+		allowedTypes: defaultAttachmentTypes,
+	}
+	if conf.ATTACHMENT_MAX_SIZE > 0 {
+		s.maxSize = int64(conf.ATTACHMENT_MAX_SIZE)
+	}
+	if len(conf.ATTACHMENT_ALLOWED_TYPES) > 0 {
+		s.allowedTypes = conf.ATTACHMENT_ALLOWED_TYPES
+	}
+
+	return s
+}
+
+// MaxSize returns the maximum size in bytes of an attachment
+func (s *AttachmentService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// allowed tells if the media type is one of the allowed types
+func (s *AttachmentService) allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range s.allowedTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+Upload stores a file uploaded in a room. Its type is detected from its content,
+the one announced by the client is ignored. The attachment is then referenced by a message of its author.
+
+Parameters:
+  - ctx (context.Context): the context of the upload
+  - roomid (string): the room the file is uploaded in
+  - userid (string): the user uploading the file
+  - filename (string): the name of the file given by the client
+  - r (io.Reader): the content of the file
+  - size (int64): the size of the file
+
+Returns:
+  - (*model.Attachment): the attachment, not yet attached to a message
+  - (error): ErrAttachmentTooLarge, ErrAttachmentType, or an error of the store or the database
+*/
+func (s *AttachmentService) Upload(ctx context.Context, roomid string, userid string, filename string, r io.Reader, size int64) (*model.Attachment, error) {
+	if size > s.maxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	// http.DetectContentType n'utilise que les 512 premiers octets
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if !s.allowed(contentType) {
+		return nil, ErrAttachmentType
+	}
+
+	attachment := &model.Attachment{
+		RoomId:      roomid,
+		UserId:      userid,
+		Key:         "attachments/" + betterguid.New(),
+		Filename:    cleanFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+	}
+
+	content := io.MultiReader(bytes.NewReader(head), io.LimitReader(r, size-int64(n)))
+	err = s.store.Put(ctx, attachment.Key, content, size, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Create(attachment).Error
+	if err != nil {
+		s.store.Delete(ctx, attachment.Key)
+		return nil, err
+	}
+
+	return attachment, nil
+}
+
+// cleanFilename keeps only the base name of the file, without any directory
+func cleanFilename(filename string) string {
+	name := filepath.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" {
+		return "file"
+	}
+	if len(name) > 191 {
+		name = name[len(name)-191:]
+	}
+
+	return name
+}
+
+/*
+GetAttachment retrieves an attachment of a room. An attachment not yet used by a message
+is only visible to its author, and the attachments of deleted messages are not found.
+
+Parameters:
+  - roomid (string): the room the file was uploaded in
+  - id (int): the ID of the attachment
+  - userid (string): the user asking for the attachment
+
+Returns:
+  - (*model.Attachment): the attachment
+  - (error): gorm.ErrRecordNotFound if the attachment cannot be seen by the user
+*/
+func (s *AttachmentService) GetAttachment(roomid string, id int, userid string) (*model.Attachment, error) {
+	var attachment model.Attachment
+	err := s.db.Where("room_id = ?", roomid).First(&attachment, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if attachment.MessageId == nil {
+		if attachment.UserId != userid {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return &attachment, nil
+	}
+
+	err = s.db.Select("id").First(&model.Message{}, *attachment.MessageId).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &attachment, nil
+}
+
+// Open opens the content of the attachment, the caller must close it
+func (s *AttachmentService) Open(ctx context.Context, attachment *model.Attachment) (io.ReadCloser, error) {
+	return s.store.Get(ctx, attachment.Key)
+}
+
+// attachmentIds converts the ids referenced by a message, rejecting the invalid ones
+func attachmentIds(ids []string) ([]uint, error) {
+	if len(ids) > MaxAttachments {
+		return nil, ErrTooManyAttachments
+	}
+
+	seen := map[uint]bool{}
+	result := []uint{}
+	for _, id := range ids {
+		value, err := strconv.Atoi(id)
+		if err != nil || value <= 0 {
+			return nil, ErrUnknownAttachment
+		}
+		if !seen[uint(value)] {
+			seen[uint(value)] = true
+			result = append(result, uint(value))
+		}
+	}
+
+	return result, nil
+}
+
+/*
+attachToMessage links the uploads of the author to their message in the transaction.
+Every attachment must have been uploaded by the author in the same room and not be used yet.
+*/
+func attachToMessage(tx *gorm.DB, msg *model.Message, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	result := tx.Model(&model.Attachment{}).
+		Where("id IN ? AND room_id = ? AND user_id = ? AND message_id IS NULL", ids, msg.RoomId, msg.UserId).
+		Update("message_id", msg.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if int(result.RowsAffected) != len(ids) {
+		return ErrUnknownAttachment
+	}
+
+	return tx.Where("message_id = ?", msg.ID).Order("id").Find(&msg.Attachments).Error
+}
+
+func attachmentPayloads(attachments []model.Attachment) []Attachment {
+	payloads := []Attachment{}
+	for _, attachment := range attachments {
+		payloads = append(payloads, Attachment{
+			Id:          strconv.Itoa(int(attachment.ID)),
+			Filename:    attachment.Filename,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+		})
+	}
+
+	return payloads
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+)
+
+// AuditLogger appends events to the audit log, failures are logged and never stop the action audited
+type AuditLogger interface {
+	Audit(event *model.AuditEvent)
+}
+
+// AuditQuery filters the audit log, zero values are ignored
+type AuditQuery struct {
+	ActorId uint
+	Action  string
+	Since   time.Time
+	Until   time.Time
+	// Only events with a lower ID are returned, 0 for the most recent ones
+	Before int
+	Limit  int
+}
+
+type AuditService struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewAuditService(db *gorm.DB, logger *slog.Logger) *AuditService {
+	return &AuditService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Audit saves the event, it makes AuditService usable as the AuditLogger of the handlers and the room manager
+func (s *AuditService) Audit(event *model.AuditEvent) {
+	err := s.db.Create(event).Error
+	if err != nil {
+		s.logger.Error("audit event lost", "action", event.Action, "target", event.Target, "error", err)
+	}
+}
+
+/*
+GetEvents returns the events of the audit log matching the query, most recent first.
+
+Parameters:
+  - query (AuditQuery): the filters and the page
+
+Returns:
+  - ([]*model.AuditEvent): the events
+  - (error): a database error
+*/
+func (s *AuditService) GetEvents(query AuditQuery) ([]*model.AuditEvent, error) {
+	events := []*model.AuditEvent{}
+	tx := s.db.Model(&model.AuditEvent{})
+	if query.ActorId > 0 {
+		tx = tx.Where("actor_id = ?", query.ActorId)
+	}
+	if query.Action != "" {
+		tx = tx.Where("action = ?", query.Action)
+	}
+	if !query.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		tx = tx.Where("created_at < ?", query.Until)
+	}
+	if query.Before > 0 {
+		tx = tx.Where("id < ?", query.Before)
+	}
+
+	err := tx.Order("id DESC").Limit(query.Limit).Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// auditActor converts a user id of the room manager, nil for the server or an unknown user
+func auditActor(userid string) *uint {
+	id, err := strconv.Atoi(userid)
+	if err != nil || id <= 0 {
+		return nil
+	}
+
+	actor := uint(id)
+	return &actor
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"database/sql/driver"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+)
+
+// auditLog is an AuditLogger keeping the events in memory
+type auditLog struct {
+	mu     sync.Mutex
+	events []*model.AuditEvent
+}
+
+func (l *auditLog) Audit(event *model.AuditEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *auditLog) recorded() []*model.AuditEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*model.AuditEvent{}, l.events...)
+}
+
+func TestAuditSavesEvents(t *testing.T) {
+	db, fake := fakedb.Open(t, nil)
+	s := NewAuditService(db, slog.Default())
+	s.Audit(&model.AuditEvent{Action: model.AuditLogin, Target: "user:1", Metadata: model.AuditMetadata{"ip": "127.0.0.1"}})
+	if !fake.Ran("INSERT INTO `audit_events`") {
+		t.Error("event not saved")
+	}
+}
+
+func TestAuditEventsAreAppendOnly(t *testing.T) {
+	db, fake := fakedb.Open(t, nil)
+	event := &model.AuditEvent{ID: 1, Action: model.AuditLogin}
+	if err := db.Model(event).Update("action", model.AuditLoginFailed).Error; err != model.ErrAuditAppendOnly {
+		t.Errorf("update: error %v, want %v", err, model.ErrAuditAppendOnly)
+	}
+	if err := db.Delete(event).Error; err != model.ErrAuditAppendOnly {
+		t.Errorf("delete: error %v, want %v", err, model.ErrAuditAppendOnly)
+	}
+	if fake.Ran("UPDATE") || fake.Ran("DELETE") {
+		t.Error("audit event changed")
+	}
+}
+
+func TestAuditMetadataRoundTrip(t *testing.T) {
+	metadata := model.AuditMetadata{"fields": []interface{}{"email"}, "rooms": float64(2)}
+	value, err := metadata.Value()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scanned model.AuditMetadata
+	if err := scanned.Scan([]byte(value.(string))); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scanned, metadata) {
+		t.Errorf("scanned %v, want %v", scanned, metadata)
+	}
+
+	var empty model.AuditMetadata
+	if value, _ := empty.Value(); value != nil {
+		t.Errorf("nil metadata stored as %v", value)
+	}
+	if err := scanned.Scan(nil); err != nil || scanned != nil {
+		t.Errorf("NULL scanned as %v, %v", scanned, err)
+	}
+	if err := scanned.Scan(12); err == nil {
+		t.Error("invalid metadata scanned")
+	}
+}
+
+func TestGetEventsFilters(t *testing.T) {
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if strings.Contains(query, "FROM `audit_events`") {
+			return fakedb.Rows([]string{"id", "action", "target", "metadata"}, int64(3), model.AuditLogin, "user:1", `{"ip":"127.0.0.1"}`)
+		}
+		return nil, nil
+	})
+	s := NewAuditService(db, slog.Default())
+	events, err := s.GetEvents(AuditQuery{
+		ActorId: 1,
+		Action:  model.AuditLogin,
+		Since:   time.Now().Add(-time.Hour),
+		Until:   time.Now(),
+		Before:  10,
+		Limit:   50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Metadata["ip"] != "127.0.0.1" {
+		t.Errorf("events %+v, want event 3 with its metadata", events)
+	}
+	for _, part := range []string{"actor_id = ?", "action = ?", "created_at >= ?", "created_at < ?", "id < ?", "ORDER BY id DESC"} {
+		if !fake.Ran(part) {
+			t.Errorf("%s not in the query", part)
+		}
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	for userid, want := range map[string]uint{"12": 12, "": 0, "0": 0, "-1": 0, "server": 0} {
+		actor := auditActor(userid)
+		if (want == 0) != (actor == nil) || (actor != nil && *actor != want) {
+			t.Errorf("auditActor(%q) = %v, want %d", userid, actor, want)
+		}
+	}
+}
+
+func TestModerationAudited(t *testing.T) {
+	audit := &auditLog{}
+	options := DefaultManagerOptions()
+	options.AuditLogger = audit
+	m := startManager(t, options)
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	moderated(t, m, listener, &Moderation{Action: model.ModerationMute, RoomId: "general", ModeratorId: "1", UserId: "2", Reason: "spam"})
+	// Les suppressions de messages et les signalements écartés sont seulement audités
+	m.Moderate(&Moderation{Action: model.ModerationDeleteMessage, RoomId: "general", ModeratorId: "1", UserId: "2", MessageId: "7"})
+	noEvent(t, listener)
+	// La suppression de la room est auditée par le handler, qui connait son auteur
+	m.DeleteBroadcast("general")
+
+	events := audit.recorded()
+	if len(events) != 2 {
+		t.Fatalf("%d events audited, want 2", len(events))
+	}
+	if events[0].Action != model.AuditModerationPrefix+model.ModerationMute || events[0].Target != "user:2" ||
+		events[0].ActorId == nil || *events[0].ActorId != 1 || events[0].Metadata["reason"] != "spam" {
+		t.Errorf("mute audited as %+v", events[0])
+	}
+	if events[1].Action != model.AuditModerationPrefix+model.ModerationDeleteMessage || events[1].Metadata["message"] != "7" {
+		t.Errorf("deletion of the message audited as %+v", events[1])
+	}
+}
+
+func TestPromoteAdmins(t *testing.T) {
+	db, fake := fakedb.Open(t, nil)
+	if err := NewUserService(db).PromoteAdmins([]uint{1}); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.Ran("id NOT IN") || !fake.Ran("id IN") || fake.Ran("email") {
+		t.Error("administrators not revoked then granted")
+	}
+
+	db, fake = fakedb.Open(t, nil)
+	if err := NewUserService(db).PromoteAdmins(nil); err != nil {
+		t.Fatal(err)
+	}
+	if fake.Ran("id IN") {
+		t.Error("administrators granted from no id")
+	}
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"time"
+
+	"github.com/kjk/betterguid"
+	"github.com/riri95500/go-chat/markdown"
+	"github.com/riri95500/go-chat/tracing"
+	"github.com/riri95500/go-chat/unfurl"
+)
+
+// EventVersion is the version of the Event envelope, bumped on breaking changes only
+const EventVersion = 1
+
+// EventType tells the listeners what kind of event they receive, it is used as the SSE event name
+type EventType string
+
+const (
+	// A chat message, the only event kept in the room history
+	EventMessage EventType = "message"
+	// A message was edited by its author
+	EventMessageEdited EventType = "message.edited"
+	// A message was deleted
+	EventMessageDeleted EventType = "message.deleted"
+	// A user added a reaction on a message
+	EventReactionAdded EventType = "reaction.added"
+	// A user removed their reaction from a message
+	EventReactionRemoved EventType = "reaction.removed"
+	// A user read the room up to a message
+	EventRead EventType = "receipt.read"
+	// A user opened their first listener in the room
+	EventJoined EventType = "presence.joined"
+	// A user closed their last listener in the room
+	EventLeft EventType = "presence.left"
+	// A user started or stopped typing, ephemeral
+	EventTyping EventType = "typing"
+	// The user was mentioned in a message, sent to the user room
+	EventMention EventType = "mention"
+	// The user was added to a room, sent to the user room
+	EventRoomJoined EventType = "room.joined"
+	// The links of a message were previewed, after the message itself
+	EventMessageUnfurled EventType = "message.unfurled"
+	// A moderator kicked, banned or muted a user, or changed the slow mode, also sent to the user room of a kicked or banned user
+	EventModeration EventType = "moderation"
+	// The room was deleted, listeners should stop
+	EventRoomClosed EventType = "room.closed"
+	// A notice from the server
+	EventSystem EventType = "system"
+)
+
+/*
+Event is the envelope of everything sent to the listeners of a room.
+Clients must ignore the event types they do not know.
+*/
+type Event struct {
+	Version   int         `json:"version"`
+	Type      EventType   `json:"type"`
+	Id        string      `json:"id"`
+	RoomId    string      `json:"room"`
+	Timestamp time.Time   `json:"timestamp"`
+	Actor     string      `json:"actor,omitempty"`
+	Payload   interface{} `json:"payload,omitempty"`
+	// Span of the request the event comes from, to trace its fan-out
+	trace tracing.SpanContext
+}
+
+type MessagePayload struct {
+	Text        string       `json:"text"`
+	ParentId    string       `json:"parentId,omitempty"`
+	QuoteId     string       `json:"quoteId,omitempty"`
+	Mentions    []Mention    `json:"mentions,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Text parsed as Markdown, and rendered without its syntax
+	Content *markdown.Node `json:"content,omitempty"`
+	Plain   string         `json:"plain"`
+}
+
+type MentionPayload struct {
+	RoomId    string `json:"roomId"`
+	MessageId string `json:"messageId,omitempty"`
+}
+
+type MessageEditedPayload struct {
+	MessageId string         `json:"messageId"`
+	Text      string         `json:"text"`
+	Content   *markdown.Node `json:"content,omitempty"`
+	EditedAt  time.Time      `json:"editedAt"`
+}
+
+type MessageUnfurledPayload struct {
+	MessageId string           `json:"messageId"`
+	Previews  []unfurl.Preview `json:"previews"`
+}
+
+type ModerationPayload struct {
+	RoomId string `json:"roomId"`
+	Action string `json:"action"`
+	UserId string `json:"userId,omitempty"`
+	// End of a ban or a mute, absent when it has no end
+	Until *time.Time `json:"until,omitempty"`
+	// Interval in seconds of the slow mode
+	SlowMode int    `json:"slowMode,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+type MessageDeletedPayload struct {
+	MessageId string `json:"messageId"`
+}
+
+type ReactionPayload struct {
+	MessageId string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+}
+
+type ReadPayload struct {
+	MessageId string `json:"messageId"`
+}
+
+type TypingPayload struct {
+	Typing bool `json:"typing"`
+}
+
+type RoomClosedPayload struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+type RoomJoinedPayload struct {
+	RoomId string `json:"roomId"`
+}
+
+type SystemPayload struct {
+	Text string `json:"text"`
+}
+
+/*
+NewEvent creates an event with a new unique id, timestamped now.
+
+Parameters:
+  - eventType (EventType): the kind of event
+  - roomid (string): the room the event belongs to
+  - actor (string): the id of the user at the origin of the event, empty for the server
+  - payload (interface{}): the data of the event, depending on its type
+
+Returns:
+  - (*Event): the event
+*/
+func NewEvent(eventType EventType, roomid, actor string, payload interface{}) *Event {
+	return &Event{
+		Version:   EventVersion,
+		Type:      eventType,
+		Id:        betterguid.New(),
+		RoomId:    roomid,
+		Timestamp: time.Now(),
+		Actor:     actor,
+		Payload:   payload,
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventEnvelope(t *testing.T) {
+	event := NewEvent(EventMessageDeleted, "general", "1", MessageDeletedPayload{MessageId: "42"})
+	other := NewEvent(EventMessageDeleted, "general", "1", nil)
+	if event.Id == "" || event.Id == other.Id {
+		t.Errorf("events need a unique id, got %q and %q", event.Id, other.Id)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]interface{}{
+		"version": float64(EventVersion),
+		"type":    "message.deleted",
+		"room":    "general",
+		"actor":   "1",
+	}
+	for key, value := range want {
+		if envelope[key] != value {
+			t.Errorf("%s = %v, want %v", key, envelope[key], value)
+		}
+	}
+	if _, ok := envelope["timestamp"]; !ok {
+		t.Error("timestamp is missing")
+	}
+	payload, ok := envelope["payload"].(map[string]interface{})
+	if !ok || payload["messageId"] != "42" {
+		t.Errorf("payload = %v", envelope["payload"])
+	}
+	// Le contexte de trace n'est pas envoyé aux clients
+	if len(envelope) != 7 {
+		t.Errorf("unexpected fields in %s", data)
+	}
+}
+
+func TestServerEventsHaveNoActor(t *testing.T) {
+	data, err := json.Marshal(NewEvent(EventRoomClosed, "general", "", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var envelope map[string]interface{}
+	json.Unmarshal(data, &envelope)
+	if _, ok := envelope["actor"]; ok {
+		t.Errorf("server event has an actor: %s", data)
+	}
+	if _, ok := envelope["payload"]; ok {
+		t.Errorf("event without payload has one: %s", data)
+	}
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMessageRejected is wrapped by the errors of the messages rejected by a MessageFilter
+var ErrMessageRejected = errors.New("message rejected")
+
+type FilterAction int
+
+const (
+	// The message goes through unchanged
+	FilterAllow FilterAction = iota
+	// The text of the message is replaced by the Text of the FilterResult
+	FilterRewrite
+	// The message goes through but is queued for moderator review
+	FilterFlag
+	// The message is refused, the sender gets the Reason
+	FilterReject
+)
+
+/*
+ParseFilterAction converts an action name ("rewrite", "flag" or "reject") into a FilterAction.
+An empty string gives the fallback.
+*/
+func ParseFilterAction(s string, fallback FilterAction) (FilterAction, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return fallback, nil
+	case "allow":
+		return FilterAllow, nil
+	case "rewrite":
+		return FilterRewrite, nil
+	case "flag":
+		return FilterFlag, nil
+	case "reject":
+		return FilterReject, nil
+	default:
+		return fallback, errors.New("unknown filter action: " + s)
+	}
+}
+
+type FilterResult struct {
+	Action FilterAction
+	// New text of the message, for FilterRewrite
+	Text string
+	// Why the message was flagged or rejected
+	Reason string
+}
+
+/*
+MessageFilter checks a message before it is saved and broadcast. Filters run on the
+goroutines of the senders and must be safe for concurrent use.
+*/
+type MessageFilter interface {
+	Filter(message *Message) FilterResult
+}
+
+/*
+SentMessageFilter is a MessageFilter which compares a message with the previous messages of
+its author. Edits skip it, and it is told of every new message once saved and broadcast, with
+the text its Filter saw, so that a rejected message or one which was not sent is not remembered.
+*/
+type SentMessageFilter interface {
+	MessageFilter
+	Sent(message *Message, text string)
+}
+
+// filteredText is the text a SentMessageFilter saw, kept until the message is sent
+type filteredText struct {
+	filter SentMessageFilter
+	text   string
+}
+
+// FlaggedMessageStore queues the messages flagged by the filters for moderator review
+type FlaggedMessageStore interface {
+	FlagMessage(message *Message, reasons []string) error
+}
+
+/*
+filterMessage runs the filters in order. A rewrite changes the text seen by the next
+filters, a rejection stops the chain. The reasons of the flags are returned.
+An edit skips the SentMessageFilters, the text seen by them is kept in the message for sentMessage.
+*/
+func filterMessage(filters []MessageFilter, message *Message, edit bool) ([]string, error) {
+	flags := []string{}
+	message.filtered = nil
+	for _, filter := range filters {
+		sent, remembers := filter.(SentMessageFilter)
+		if remembers && edit {
+			continue
+		}
+		if remembers {
+			message.filtered = append(message.filtered, filteredText{filter: sent, text: message.Text})
+		}
+		result := filter.Filter(message)
+		switch result.Action {
+		case FilterRewrite:
+			message.Text = result.Text
+		case FilterFlag:
+			flags = append(flags, result.Reason)
+		case FilterReject:
+			return nil, rejection(result.Reason)
+		}
+	}
+
+	return flags, nil
+}
+
+// sentMessage tells the SentMessageFilters that the message they let through was sent
+func sentMessage(message *Message) {
+	for _, filtered := range message.filtered {
+		filtered.filter.Sent(message, filtered.text)
+	}
+}
+
+func rejection(reason string) error {
+	if reason == "" {
+		return ErrMessageRejected
+	}
+
+	return fmt.Errorf("%w: %s", ErrMessageRejected, reason)
+}
@@ -0,0 +1,294 @@
+package service
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/markdown"
+)
+
+/*
+NewMessageFilters builds the filter chain from the application Config, in this order:
+  - FILTER_MAX_LENGTH: rejects the messages longer than this number of characters
+  - FILTER_DUPLICATE_WINDOW: rejects a message repeated by its author in a room within this window,
+    30s by default, a negative duration disables it
+  - FILTER_REGEXP_FILE: a file with one regular expression per line, FILTER_REGEXP_ACTION (reject by default)
+    is applied to the messages matching one of them
+  - FILTER_PROFANITY_WORDS: a list of words, FILTER_PROFANITY_ACTION (rewrite by default) is applied
+    to the messages containing one of them, a rewrite masks the words
+  - FILTER_LINK_ALLOWLIST: a list of domains, FILTER_LINK_ACTION (reject by default) is applied to the
+    messages linking to other domains
+*/
+func NewMessageFilters(conf *config.Config) ([]MessageFilter, error) {
+	filters := []MessageFilter{}
+
+	if conf.FILTER_MAX_LENGTH > 0 {
+		filters = append(filters, &MaxLengthFilter{Max: conf.FILTER_MAX_LENGTH})
+	}
+
+	window := 30 * time.Second
+	if conf.FILTER_DUPLICATE_WINDOW != 0 {
+		window = conf.FILTER_DUPLICATE_WINDOW
+	}
+	if window > 0 {
+		filters = append(filters, NewDuplicateFilter(window))
+	}
+
+	if conf.FILTER_REGEXP_FILE != "" {
+		patterns, err := readPatterns(conf.FILTER_REGEXP_FILE)
+		if err != nil {
+			return nil, err
+		}
+		action, err := ParseFilterAction(conf.FILTER_REGEXP_ACTION, FilterReject)
+		if err != nil {
+			return nil, err
+		}
+		filter, err := NewRegexpFilter(patterns, action)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(conf.FILTER_PROFANITY_WORDS) > 0 {
+		action, err := ParseFilterAction(conf.FILTER_PROFANITY_ACTION, FilterRewrite)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, NewProfanityFilter(conf.FILTER_PROFANITY_WORDS, action))
+	}
+
+	if len(conf.FILTER_LINK_ALLOWLIST) > 0 {
+		action, err := ParseFilterAction(conf.FILTER_LINK_ACTION, FilterReject)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, &LinkAllowlistFilter{Domains: conf.FILTER_LINK_ALLOWLIST, Action: action})
+	}
+
+	return filters, nil
+}
+
+// readPatterns reads one pattern per line, ignoring the empty lines and the ones starting with #
+func readPatterns(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	patterns := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			patterns = append(patterns, line)
+		}
+	}
+
+	return patterns, scanner.Err()
+}
+
+// mask replaces every character of the match by a star
+func mask(match string) string {
+	return strings.Repeat("*", utf8.RuneCountInString(match))
+}
+
+// MaxLengthFilter rejects the messages longer than Max characters
+type MaxLengthFilter struct {
+	Max int
+}
+
+func (f *MaxLengthFilter) Filter(message *Message) FilterResult {
+	if utf8.RuneCountInString(message.Text) <= f.Max {
+		return FilterResult{}
+	}
+
+	return FilterResult{
+		Action: FilterReject,
+		Reason: "the message is longer than " + strconv.Itoa(f.Max) + " characters",
+	}
+}
+
+// ProfanityFilter applies its action to the messages containing one of its words, whatever their case
+type ProfanityFilter struct {
+	pattern *regexp.Regexp
+	action  FilterAction
+}
+
+func NewProfanityFilter(words []string, action FilterAction) *ProfanityFilter {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+
+	return &ProfanityFilter{
+		pattern: regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`),
+		action:  action,
+	}
+}
+
+func (f *ProfanityFilter) Filter(message *Message) FilterResult {
+	if !f.pattern.MatchString(message.Text) {
+		return FilterResult{}
+	}
+
+	return FilterResult{
+		Action: f.action,
+		Text:   f.pattern.ReplaceAllStringFunc(message.Text, mask),
+		Reason: "inappropriate language",
+	}
+}
+
+// RegexpFilter applies its action to the messages matching one of its patterns
+type RegexpFilter struct {
+	patterns []*regexp.Regexp
+	action   FilterAction
+}
+
+func NewRegexpFilter(patterns []string, action FilterAction) (*RegexpFilter, error) {
+	f := &RegexpFilter{
+		action: action,
+	}
+	for _, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, compiled)
+	}
+
+	return f, nil
+}
+
+func (f *RegexpFilter) Filter(message *Message) FilterResult {
+	text := message.Text
+	matched := false
+	for _, pattern := range f.patterns {
+		if pattern.MatchString(text) {
+			matched = true
+			text = pattern.ReplaceAllStringFunc(text, mask)
+		}
+	}
+	if !matched {
+		return FilterResult{}
+	}
+
+	return FilterResult{
+		Action: f.action,
+		Text:   text,
+		Reason: "the message contains forbidden content",
+	}
+}
+
+/*
+DuplicateFilter rejects a message identical to the previous message of its author
+in the same room, when it is sent within the window. As a SentMessageFilter, it only
+remembers the messages which were sent, and leaves the edits alone.
+*/
+type DuplicateFilter struct {
+	window    time.Duration
+	mu        sync.Mutex
+	last      map[string]duplicateEntry
+	lastSweep time.Time
+}
+
+type duplicateEntry struct {
+	text string
+	at   time.Time
+}
+
+func NewDuplicateFilter(window time.Duration) *DuplicateFilter {
+	return &DuplicateFilter{
+		window:    window,
+		last:      make(map[string]duplicateEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+func (f *DuplicateFilter) Filter(message *Message) FilterResult {
+	now := time.Now()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if now.Sub(f.lastSweep) > f.window {
+		for k, entry := range f.last {
+			if now.Sub(entry.at) > f.window {
+				delete(f.last, k)
+			}
+		}
+		f.lastSweep = now
+	}
+
+	previous, ok := f.last[duplicateKey(message)]
+	if ok && previous.text == duplicateText(message.Text) && now.Sub(previous.at) <= f.window {
+		return FilterResult{
+			Action: FilterReject,
+			Reason: "the same message was just sent",
+		}
+	}
+
+	return FilterResult{}
+}
+
+func (f *DuplicateFilter) Sent(message *Message, text string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.last[duplicateKey(message)] = duplicateEntry{text: duplicateText(text), at: time.Now()}
+}
+
+func duplicateKey(message *Message) string {
+	return message.RoomId + "\x00" + message.UserId
+}
+
+// La casse et les espaces ne suffisent pas à distinguer deux messages
+func duplicateText(text string) string {
+	return strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// LinkAllowlistFilter applies Action to the messages linking to a domain which is not in Domains or one of their subdomains
+type LinkAllowlistFilter struct {
+	Domains []string
+	Action  FilterAction
+}
+
+func (f *LinkAllowlistFilter) Filter(message *Message) FilterResult {
+	for _, link := range markdown.Links(markdown.Parse(message.Text)) {
+		u, err := url.Parse(link)
+		if err != nil || u.Scheme == "mailto" {
+			continue
+		}
+
+		host := strings.ToLower(u.Hostname())
+		if !f.allowed(host) {
+			return FilterResult{
+				Action: f.Action,
+				Text:   message.Text,
+				Reason: "links to " + host + " are not allowed",
+			}
+		}
+	}
+
+	return FilterResult{}
+}
+
+func (f *LinkAllowlistFilter) allowed(host string) bool {
+	for _, domain := range f.Domains {
+		domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
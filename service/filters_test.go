@@ -0,0 +1,176 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/config"
+)
+
+func TestFilters(t *testing.T) {
+	regexp, err := NewRegexpFilter([]string{`\d{4}-\d{4}-\d{4}-\d{4}`}, FilterReject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		filter MessageFilter
+		text   string
+		want   FilterResult
+	}{
+		{"short enough", &MaxLengthFilter{Max: 5}, "héllo", FilterResult{}},
+		{"too long", &MaxLengthFilter{Max: 5}, "hello!", FilterResult{Action: FilterReject, Reason: "the message is longer than 5 characters"}},
+		{"clean words", NewProfanityFilter([]string{"darn"}, FilterRewrite), "darning socks", FilterResult{}},
+		{"profanity masked", NewProfanityFilter([]string{"darn"}, FilterRewrite), "Darn it, darn", FilterResult{Action: FilterRewrite, Text: "**** it, ****", Reason: "inappropriate language"}},
+		{"profanity flagged", NewProfanityFilter([]string{"darn"}, FilterFlag), "darn", FilterResult{Action: FilterFlag, Text: "****", Reason: "inappropriate language"}},
+		{"no card number", regexp, "call 1234", FilterResult{}},
+		{"card number", regexp, "1234-5678-9012-3456", FilterResult{Action: FilterReject, Text: "*******************", Reason: "the message contains forbidden content"}},
+		{"allowed link", &LinkAllowlistFilter{Domains: []string{"example.com"}, Action: FilterReject}, "see https://docs.example.com/a and mailto:a@b.c", FilterResult{}},
+		{"other link", &LinkAllowlistFilter{Domains: []string{"example.com"}, Action: FilterReject}, "see [this](https://evil-example.com)", FilterResult{Action: FilterReject, Text: "see [this](https://evil-example.com)", Reason: "links to evil-example.com are not allowed"}},
+	}
+	for _, tt := range tests {
+		got := tt.filter.Filter(&Message{UserId: "1", RoomId: "general", Text: tt.text})
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := NewRegexpFilter([]string{"("}, FilterReject); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestDuplicateFilter(t *testing.T) {
+	f := NewDuplicateFilter(time.Minute)
+	send := func(userid, roomid, text string) FilterAction {
+		message := &Message{UserId: userid, RoomId: roomid, Text: text}
+		result := f.Filter(message)
+		if result.Action == FilterAllow {
+			f.Sent(message, text)
+		}
+		return result.Action
+	}
+
+	if send("1", "general", "hello") != FilterAllow {
+		t.Error("first message rejected")
+	}
+	if send("1", "general", " HELLO ") != FilterReject {
+		t.Error("repeated message allowed")
+	}
+	if send("2", "general", "hello") != FilterAllow || send("1", "random", "hello") != FilterAllow {
+		t.Error("the message of another user or room rejected")
+	}
+	if send("1", "general", "bye") != FilterAllow || send("1", "general", "hello") != FilterAllow {
+		t.Error("a message sent again after another one rejected")
+	}
+
+	// Un message filtré mais jamais envoyé n'est pas retenu
+	f.Filter(&Message{UserId: "3", RoomId: "general", Text: "lost"})
+	if send("3", "general", "lost") != FilterAllow {
+		t.Error("message rejected as a repeat of a message which was not sent")
+	}
+
+	f = NewDuplicateFilter(time.Nanosecond)
+	f.Sent(&Message{UserId: "1", RoomId: "general"}, "hello")
+	time.Sleep(time.Millisecond)
+	if f.Filter(&Message{UserId: "1", RoomId: "general", Text: "hello"}).Action != FilterAllow {
+		t.Error("message repeated after the window rejected")
+	}
+}
+
+func TestFilterChain(t *testing.T) {
+	seen := []string{}
+	record := filterFunc(func(message *Message) FilterResult {
+		seen = append(seen, message.Text)
+		return FilterResult{}
+	})
+	filters := []MessageFilter{
+		NewProfanityFilter([]string{"darn"}, FilterRewrite),
+		record,
+		filterFunc(func(message *Message) FilterResult { return FilterResult{Action: FilterFlag, Reason: "first"} }),
+		filterFunc(func(message *Message) FilterResult { return FilterResult{Action: FilterFlag, Reason: "second"} }),
+	}
+
+	message := &Message{Text: "darn it"}
+	flags, err := filterMessage(filters, message, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.Text != "**** it" || !reflect.DeepEqual(seen, []string{"**** it"}) {
+		t.Errorf("text %q, seen %q: the rewrite is not passed along", message.Text, seen)
+	}
+	if !reflect.DeepEqual(flags, []string{"first", "second"}) {
+		t.Errorf("flags %q", flags)
+	}
+
+	// Un rejet arrête la chaîne
+	seen = nil
+	reject := filterFunc(func(message *Message) FilterResult { return FilterResult{Action: FilterReject, Reason: "no"} })
+	_, err = filterMessage([]MessageFilter{reject, record}, &Message{Text: "hello"}, false)
+	if !errors.Is(err, ErrMessageRejected) || err.Error() != "message rejected: no" {
+		t.Errorf("error %v, want the rejection with its reason", err)
+	}
+	if len(seen) != 0 {
+		t.Error("the filters after a rejection ran")
+	}
+}
+
+func TestParseFilterAction(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    FilterAction
+		wantErr bool
+	}{
+		{"", FilterFlag, false},
+		{"allow", FilterAllow, false},
+		{" Rewrite ", FilterRewrite, false},
+		{"flag", FilterFlag, false},
+		{"REJECT", FilterReject, false},
+		{"ban", FilterFlag, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseFilterAction(tt.s, FilterFlag)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("ParseFilterAction(%q) = %v, %v", tt.s, got, err)
+		}
+	}
+}
+
+func TestNewMessageFilters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "patterns")
+	os.WriteFile(path, []byte("# cartes\n\n\\d{16}\n"), 0o600)
+
+	filters, err := NewMessageFilters(&config.Config{
+		FILTER_MAX_LENGTH:      100,
+		FILTER_REGEXP_FILE:     path,
+		FILTER_PROFANITY_WORDS: []string{"darn"},
+		FILTER_LINK_ALLOWLIST:  []string{"example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, filter := range filters {
+		names = append(names, reflect.TypeOf(filter).Elem().Name())
+	}
+	want := []string{"MaxLengthFilter", "DuplicateFilter", "RegexpFilter", "ProfanityFilter", "LinkAllowlistFilter"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("filters %v, want %v", names, want)
+	}
+
+	filters, err = NewMessageFilters(&config.Config{FILTER_DUPLICATE_WINDOW: -1})
+	if err != nil || len(filters) != 0 {
+		t.Errorf("got %d filters, %v, want none", len(filters), err)
+	}
+	if _, err := NewMessageFilters(&config.Config{FILTER_PROFANITY_WORDS: []string{"darn"}, FILTER_PROFANITY_ACTION: "ban"}); err == nil || !strings.Contains(err.Error(), "ban") {
+		t.Errorf("unknown action: error %v", err)
+	}
+	if _, err := NewMessageFilters(&config.Config{FILTER_REGEXP_FILE: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("missing pattern file accepted")
+	}
+}
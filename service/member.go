@@ -0,0 +1,423 @@
+package service
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// DirectRoomPrefix starts the id of every direct conversation
+const DirectRoomPrefix = "dm:"
+
+// UserRoomPrefix starts the id of the rooms receiving the notifications of a single user
+const UserRoomPrefix = "user:"
+
+// MaxDirectParticipants is the maximum number of users in a group conversation
+const MaxDirectParticipants = 8
+
+var (
+	ErrNotParticipant      = errors.New("this conversation is restricted to its participants")
+	ErrInvalidParticipants = errors.New("a conversation needs between 2 and 8 distinct users")
+	ErrNoRoles             = errors.New("private rooms have no owner nor moderators")
+)
+
+type RoomService struct {
+	db *gorm.DB
+}
+
+func NewRoomService(db *gorm.DB) *RoomService {
+	return &RoomService{
+		db: db,
+	}
+}
+
+/*
+RoomLoader gives the persisted rooms their own limits in the room manager,
+the limits left at 0 in a room, and an empty or unknown policy, keep the value of defaults.
+
+Parameters:
+  - defaults (RoomOptions): the options of the rooms without limits of their own
+
+Returns:
+  - (RoomLoader): the loader, to set in ManagerOptions
+*/
+func (s *RoomService) RoomLoader(defaults RoomOptions) RoomLoader {
+	return func(roomid string) (RoomOptions, bool) {
+		var room model.Room
+		err := s.db.Where("room_id = ?", roomid).First(&room).Error
+		if err != nil {
+			return defaults, false
+		}
+
+		options := defaults
+		if room.MaxSubscribers > 0 {
+			options.MaxSubscribers = room.MaxSubscribers
+		}
+		if room.MaxMessageSize > 0 {
+			options.MaxMessageSize = room.MaxMessageSize
+		}
+		if room.HistoryDepth > 0 {
+			options.HistoryDepth = room.HistoryDepth
+		}
+		if room.BufferSize > 0 {
+			options.BufferSize = room.BufferSize
+		}
+		// Une politique inconnue garde celle par défaut
+		if room.SlowConsumerPolicy != "" {
+			if policy, err := broadcast.ParseSlowConsumerPolicy(room.SlowConsumerPolicy); err == nil {
+				options.SlowConsumerPolicy = policy
+			}
+		}
+
+		return options, true
+	}
+}
+
+// IsPrivateRoomId tells if the room id is reserved to rooms with restricted access
+func IsPrivateRoomId(roomid string) bool {
+	return strings.HasPrefix(roomid, DirectRoomPrefix) || strings.HasPrefix(roomid, UserRoomPrefix)
+}
+
+// UserRoomId returns the id of the room where the notifications of the user are published
+func UserRoomId(userid string) string {
+	return UserRoomPrefix + userid
+}
+
+/*
+DirectRoomId returns the id of the conversation between the given users.
+The id only depends on the set of users, so a conversation is always reused.
+*/
+func DirectRoomId(userIds []uint) string {
+	ids := []string{}
+	for _, id := range dedupe(userIds) {
+		ids = append(ids, strconv.Itoa(int(id)))
+	}
+
+	return DirectRoomPrefix + strings.Join(ids, "-")
+}
+
+// dedupe returns the sorted distinct ids
+func dedupe(userIds []uint) []uint {
+	seen := map[uint]bool{}
+	ids := []uint{}
+	for _, id := range userIds {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+/*
+Join makes the user a member of the room if they are not one yet, direct
+conversations can only be joined by their participants.
+Members always join with the member role: an administrator appoints the owner,
+who appoints the moderators.
+
+Parameters:
+  - roomid (string): the room to join
+  - userId (uint): the ID of the user joining
+
+Returns:
+  - (*model.RoomMember): the membership of the user
+  - (error): ErrNotParticipant for a conversation of other users, ErrBanned, or a database error
+*/
+func (s *RoomService) Join(roomid string, userId uint) (*model.RoomMember, error) {
+	banned, err := s.IsBanned(roomid, userId)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, ErrBanned
+	}
+
+	member, err := s.GetMember(roomid, userId)
+	if err == nil {
+		return member, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if IsPrivateRoomId(roomid) {
+		return nil, ErrNotParticipant
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		room, err := getOrCreateRoom(tx, roomid, model.RoomKindPublic)
+		if err != nil {
+			return err
+		}
+
+		// Deux arrivées simultanées ne créent qu'une adhésion, la seconde est ignorée
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.RoomMember{
+			RoomId: room.RoomId,
+			UserId: userId,
+			Role:   model.RoleMember,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetMember(roomid, userId)
+}
+
+// getOrCreateRoom returns the room, creating it if needed even when two requests race to do it
+func getOrCreateRoom(tx *gorm.DB, roomid string, kind string) (*model.Room, error) {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Room{
+		RoomId:         roomid,
+		Kind:           kind,
+		LastActivityAt: time.Now(),
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var room model.Room
+	err = tx.Where("room_id = ?", roomid).First(&room).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+// GetMember returns the membership of the user in the room, gorm.ErrRecordNotFound if they never joined
+func (s *RoomService) GetMember(roomid string, userId uint) (*model.RoomMember, error) {
+	var member model.RoomMember
+	err := s.db.Where("room_id = ? AND user_id = ?", roomid, userId).First(&member).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// IsBanned tells if the user has a ban in force in the room
+func (s *RoomService) IsBanned(roomid string, userId uint) (bool, error) {
+	var count int64
+	err := s.db.Model(&model.RoomRestriction{}).
+		Where("room_id = ? AND user_id = ? AND kind = ?", roomid, userId, model.ModerationBan).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Count(&count).Error
+
+	return count > 0, err
+}
+
+// IsModerator tells if the user is an owner or a moderator of the room
+func (s *RoomService) IsModerator(roomid string, userId uint) bool {
+	member, err := s.GetMember(roomid, userId)
+	if err != nil {
+		return false
+	}
+
+	return member.IsModerator()
+}
+
+// IsOwner tells if the user is the owner of the room
+func (s *RoomService) IsOwner(roomid string, userId uint) bool {
+	member, err := s.GetMember(roomid, userId)
+	if err != nil {
+		return false
+	}
+
+	return member.Role == model.RoleOwner
+}
+
+// CanAccess tells if the user can read and write in the room, private rooms are restricted to their members
+func (s *RoomService) CanAccess(roomid string, userId uint) bool {
+	if !IsPrivateRoomId(roomid) {
+		return true
+	}
+
+	_, err := s.GetMember(roomid, userId)
+	return err == nil
+}
+
+// GetUserRoomIds returns the ids of the rooms the user is a member of
+func (s *RoomService) GetUserRoomIds(userId uint) ([]string, error) {
+	roomids := []string{}
+	err := s.db.Model(&model.RoomMember{}).Where("user_id = ?", userId).Pluck("room_id", &roomids).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return roomids, nil
+}
+
+/*
+StartConversation returns the direct conversation between the given users,
+creating it on first use.
+
+Parameters:
+  - userIds ([]uint): the IDs of the participants, including the user starting the conversation
+
+Returns:
+  - (*model.Room): the conversation, with its members and their user
+  - (error): ErrInvalidParticipants, gorm.ErrRecordNotFound if a user does not exist, or a database error
+*/
+func (s *RoomService) StartConversation(userIds []uint) (*model.Room, error) {
+	ids := dedupe(userIds)
+	if len(ids) < 2 || len(ids) > MaxDirectParticipants {
+		return nil, ErrInvalidParticipants
+	}
+
+	var count int64
+	err := s.db.Model(&model.User{}).Where("id IN ?", ids).Count(&count).Error
+	if err != nil {
+		return nil, err
+	}
+	if int(count) != len(ids) {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	roomid := DirectRoomId(ids)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		room, err := getOrCreateRoom(tx, roomid, model.RoomKindDirect)
+		if err != nil {
+			return err
+		}
+
+		members := make([]model.RoomMember, len(ids))
+		for i, id := range ids {
+			members[i] = model.RoomMember{
+				RoomId: room.RoomId,
+				UserId: id,
+				Role:   model.RoleMember,
+			}
+		}
+
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var room model.Room
+	err = s.db.Preload("Members.User").Where("room_id = ?", roomid).First(&room).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &room, nil
+}
+
+/*
+GetConversations returns the direct conversations of the user, the most recently active first.
+
+Parameters:
+  - userId (uint): the ID of the user
+
+Returns:
+  - ([]*model.Room): the conversations, with their members and their user
+  - (error): a database error
+*/
+func (s *RoomService) GetConversations(userId uint) ([]*model.Room, error) {
+	rooms := []*model.Room{}
+	err := s.db.Preload("Members.User").
+		Joins("JOIN room_members ON room_members.room_id = rooms.room_id AND room_members.deleted_at IS NULL").
+		Where("room_members.user_id = ? AND rooms.kind = ?", userId, model.RoomKindDirect).
+		Order("rooms.last_activity_at DESC").
+		Find(&rooms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
+
+/*
+GetUserRooms returns the rooms of the user, the most recently active first, with
+the number of messages posted by others since the last one the user read.
+
+Parameters:
+  - userId (uint): the ID of the user
+
+Returns:
+  - ([]*model.RoomSummary): the rooms
+  - (error): a database error
+*/
+func (s *RoomService) GetUserRooms(userId uint) ([]*model.RoomSummary, error) {
+	rooms := []*model.RoomSummary{}
+	err := s.db.Table("room_members").
+		Select(`rooms.room_id, rooms.kind, room_members.role, rooms.last_activity_at,
+			COALESCE(read_markers.last_read_message_id, 0) AS last_read_message_id,
+			(SELECT COUNT(*) FROM messages WHERE messages.room_id = rooms.room_id AND messages.deleted_at IS NULL
+				AND messages.id > COALESCE(read_markers.last_read_message_id, 0) AND messages.user_id <> ?) AS unread_count`, strconv.Itoa(int(userId))).
+		Joins("JOIN rooms ON rooms.room_id = room_members.room_id AND rooms.deleted_at IS NULL").
+		Joins("LEFT JOIN read_markers ON read_markers.room_id = room_members.room_id AND read_markers.user_id = room_members.user_id").
+		Where("room_members.user_id = ? AND room_members.deleted_at IS NULL", userId).
+		Order("rooms.last_activity_at DESC").
+		Scan(&rooms).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return rooms, nil
+}
+
+/*
+MarkRead moves the read marker of the user in the room up to the given message.
+The marker never goes back, and a messageId of 0 marks the whole room as read.
+
+Parameters:
+  - roomid (string): the room
+  - userId (uint): the ID of the user
+  - messageId (uint): the last message read, 0 for the last message of the room
+
+Returns:
+  - (uint): the ID of the last message read after the update
+  - (error): gorm.ErrRecordNotFound if the message is not in the room, or a database error
+*/
+func (s *RoomService) MarkRead(roomid string, userId uint, messageId uint) (uint, error) {
+	if messageId == 0 {
+		err := s.db.Model(&model.Message{}).Where("room_id = ?", roomid).Select("COALESCE(MAX(id), 0)").Scan(&messageId).Error
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		// Un message d'une autre room avancerait le marqueur au-delà des messages non lus
+		var count int64
+		err := s.db.Model(&model.Message{}).Where("id = ? AND room_id = ?", messageId, roomid).Count(&count).Error
+		if err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			return 0, gorm.ErrRecordNotFound
+		}
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "room_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"last_read_message_id": gorm.Expr("GREATEST(last_read_message_id, ?)", messageId),
+			"updated_at":           time.Now(),
+		}),
+	}).Create(&model.ReadMarker{
+		UserId:            userId,
+		RoomId:            roomid,
+		LastReadMessageId: messageId,
+	}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var marker model.ReadMarker
+	err = s.db.Where("user_id = ? AND room_id = ?", userId, roomid).First(&marker).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return marker.LastReadMessageId, nil
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"gorm.io/gorm"
+)
+
+func TestDirectRoomId(t *testing.T) {
+	tests := []struct {
+		userIds []uint
+		want    string
+	}{
+		{[]uint{1, 2}, "dm:1-2"},
+		{[]uint{2, 1}, "dm:1-2"},
+		{[]uint{10, 2, 2, 1}, "dm:1-2-10"},
+	}
+	for _, tt := range tests {
+		if got := DirectRoomId(tt.userIds); got != tt.want {
+			t.Errorf("DirectRoomId(%v) = %q, want %q", tt.userIds, got, tt.want)
+		}
+	}
+}
+
+func TestIsPrivateRoomId(t *testing.T) {
+	tests := []struct {
+		roomid string
+		want   bool
+	}{
+		{"general", false},
+		{"dm", false},
+		{DirectRoomId([]uint{1, 2}), true},
+		{UserRoomId("1"), true},
+	}
+	for _, tt := range tests {
+		if got := IsPrivateRoomId(tt.roomid); got != tt.want {
+			t.Errorf("IsPrivateRoomId(%q) = %v, want %v", tt.roomid, got, tt.want)
+		}
+	}
+}
+
+func TestStartConversationNeedsTwoToEightUsers(t *testing.T) {
+	s := NewRoomService(nil)
+	for _, userIds := range [][]uint{nil, {1}, {1, 1}, {1, 2, 3, 4, 5, 6, 7, 8, 9}} {
+		if _, err := s.StartConversation(userIds); err != ErrInvalidParticipants {
+			t.Errorf("StartConversation(%v) error %v, want ErrInvalidParticipants", userIds, err)
+		}
+	}
+}
+
+func TestMarkReadChecksTheRoomOfTheMessage(t *testing.T) {
+	for _, inRoom := range []bool{false, true} {
+		db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+			switch {
+			case strings.Contains(query, "count(*)") && inRoom:
+				return []string{"count"}, [][]driver.Value{{int64(1)}}
+			case strings.Contains(query, "count(*)"):
+				return []string{"count"}, [][]driver.Value{{int64(0)}}
+			case strings.Contains(query, "FROM `read_markers`"):
+				return []string{"user_id", "room_id", "last_read_message_id"}, [][]driver.Value{{int64(1), "general", int64(42)}}
+			}
+			return nil, nil
+		})
+
+		lastRead, err := NewRoomService(db).MarkRead("general", 1, 42)
+		if !inRoom {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("message of another room: error %v, want gorm.ErrRecordNotFound", err)
+			}
+			if fake.Ran("INSERT") {
+				t.Error("message of another room: the read marker was moved")
+			}
+			continue
+		}
+		if err != nil || lastRead != 42 {
+			t.Errorf("message of the room: got %d, %v", lastRead, err)
+		}
+		if !fake.Ran("INSERT INTO `read_markers`") {
+			t.Error("message of the room: the read marker was not moved")
+		}
+	}
+}
+
+func TestRoomLoaderReadsTheOptionsOfTheRoom(t *testing.T) {
+	db, _ := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		if !strings.Contains(query, "FROM `rooms`") {
+			return nil, nil
+		}
+		columns := []string{"room_id", "max_subscribers", "max_message_size", "history_depth", "buffer_size", "slow_consumer_policy"}
+		switch args[0] {
+		case "custom":
+			return columns, [][]driver.Value{{"custom", int64(2), int64(0), int64(30), int64(64), "drop"}}
+		case "unknown-policy":
+			return columns, [][]driver.Value{{"unknown-policy", int64(0), int64(0), int64(0), int64(0), "wait"}}
+		}
+		return nil, nil
+	})
+	defaults := DefaultManagerOptions().Room
+	loader := NewRoomService(db).RoomLoader(defaults)
+
+	options, ok := loader("custom")
+	want := defaults
+	want.MaxSubscribers = 2
+	want.HistoryDepth = 30
+	want.BufferSize = 64
+	want.SlowConsumerPolicy = broadcast.Drop
+	if !ok || options != want {
+		t.Errorf("custom room: got %+v, %v, want %+v", options, ok, want)
+	}
+
+	if options, ok := loader("unknown-policy"); !ok || options != defaults {
+		t.Errorf("room with an unknown policy: got %+v, %v, want the defaults", options, ok)
+	}
+	if options, ok := loader("missing"); ok || options != defaults {
+		t.Errorf("missing room: got %+v, %v, want the defaults and false", options, ok)
+	}
+}
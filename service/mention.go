@@ -0,0 +1,90 @@
+package service
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Mention is a user mentioned in the text of a message, as @email or @username
+type Mention struct {
+	UserId string `json:"userId"`
+	// Position in bytes of the @ in the text
+	Offset int `json:"offset"`
+	// Length in bytes of the mention, @ included
+	Length int `json:"length"`
+}
+
+// MentionResolver finds the users behind the names mentioned in messages
+type MentionResolver interface {
+	// ResolveMentions returns the ID of the user of every email or username that matched a user
+	ResolveMentions(names []string) (map[string]string, error)
+}
+
+// An @ at the start of the text or after a character that cannot be part of an email
+var mentionRegexp = regexp.MustCompile(`(?:^|[^\w.@+-])@([\w.+-]+@[\w-]+(?:\.[\w-]+)+|[\w.-]+)`)
+
+type mentionCandidate struct {
+	name   string
+	offset int
+	length int
+}
+
+// parseMentions returns every @email and @username of the text, with its position
+func parseMentions(text string) []mentionCandidate {
+	candidates := []mentionCandidate{}
+	for _, match := range mentionRegexp.FindAllStringSubmatchIndex(text, -1) {
+		// Le nom sans le point d'une fin de phrase
+		name := strings.TrimRight(text[match[2]:match[3]], ".")
+		if name == "" {
+			continue
+		}
+		candidates = append(candidates, mentionCandidate{
+			name:   name,
+			offset: match[2] - 1,
+			length: len(name) + 1,
+		})
+	}
+
+	return candidates
+}
+
+/*
+resolveMentions parses the mentions of the text and keeps the ones matching a user.
+
+Parameters:
+  - resolver (MentionResolver): finds the users
+  - text (string): the text of the message
+
+Returns:
+  - ([]Mention): the mentions of existing users, in the order of the text
+  - (error): the error of the resolver
+*/
+func resolveMentions(resolver MentionResolver, text string) ([]Mention, error) {
+	candidates := parseMentions(text)
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		names[i] = candidate.name
+	}
+
+	users, err := resolver.ResolveMentions(names)
+	if err != nil {
+		return nil, err
+	}
+
+	mentions := []Mention{}
+	for _, candidate := range candidates {
+		if userid, ok := users[candidate.name]; ok {
+			mentions = append(mentions, Mention{
+				UserId: userid,
+				Offset: candidate.offset,
+				Length: candidate.length,
+			})
+		}
+	}
+
+	return mentions, nil
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		text string
+		want []mentionCandidate
+	}{
+		{"hello", []mentionCandidate{}},
+		{"@bob hi", []mentionCandidate{{"bob", 0, 4}}},
+		{"hi @bob.", []mentionCandidate{{"bob", 3, 4}}},
+		{"ask @alice@example.com", []mentionCandidate{{"alice@example.com", 4, 18}}},
+		{"@a and @b", []mentionCandidate{{"a", 0, 2}, {"b", 7, 2}}},
+		{"mail me at bob@example.com", []mentionCandidate{}},
+		{"@.", []mentionCandidate{}},
+	}
+	for _, tt := range tests {
+		if got := parseMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseMentions(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+// mapResolver knows the users of its map, by email or username
+type mapResolver map[string]string
+
+func (r mapResolver) ResolveMentions(names []string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, name := range names {
+		if userid, ok := r[name]; ok {
+			users[name] = userid
+		}
+	}
+	return users, nil
+}
+
+func TestResolveMentionsKeepsKnownUsers(t *testing.T) {
+	mentions, err := resolveMentions(mapResolver{"bob": "2"}, "@bob and @nobody")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Mention{{UserId: "2", Offset: 0, Length: 4}}
+	if !reflect.DeepEqual(mentions, want) {
+		t.Errorf("got %v, want %v", mentions, want)
+	}
+}
+
+func TestMentionsNotifyTheUserRooms(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.MentionResolver = mapResolver{"alice": "1", "bob": "2"}
+	m := startManager(t, options)
+
+	alice := m.OpenListener(UserRoomId("1"))
+	bob := m.OpenListener(UserRoomId("2"))
+	waitListeners(t, m, 2)
+
+	err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "general", Text: "@bob @bob and me @alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event := nextEvent(t, bob)
+	payload, ok := event.Payload.(MentionPayload)
+	if event.Type != EventMention || !ok || payload.RoomId != "general" || event.Actor != "1" {
+		t.Errorf("got %+v, want a mention in general by 1", event)
+	}
+	// Une seule notification par message, et aucune pour l'auteur
+	noEvent(t, bob)
+	noEvent(t, alice)
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/riri95500/go-chat/markdown"
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotAuthor        = errors.New("only the author can edit this message")
+	ErrUnknownParent    = errors.New("the replied message does not exist in this room")
+	ErrUnknownQuote     = errors.New("the quoted message does not exist in this room")
+	ErrInvalidMessageId = errors.New("invalid message id")
+)
+
+type MessageService struct {
+	db *gorm.DB
+}
+
+func NewMessageService(db *gorm.DB) *MessageService {
+	return &MessageService{
+		db: db,
+	}
+}
+
+/*
+CreateMessage saves a message submitted to the room manager and sets its Id.
+It makes MessageService usable as the MessageStore of the manager.
+A reply to a reply is attached to the first message of the thread, whose reply count is increased.
+The referenced attachments are linked to the message, which then describes them in Attachments.
+
+Parameters:
+  - message (*Message): the message to save
+
+Returns:
+  - (error): ErrUnknownParent or ErrUnknownQuote if a referenced message is not in the room,
+    ErrUnknownAttachment or ErrTooManyAttachments, or an error if the message could not be saved
+*/
+func (s *MessageService) CreateMessage(message *Message) error {
+	msg := &model.Message{
+		RoomId: message.RoomId,
+		UserId: message.UserId,
+		Text:   message.Text,
+	}
+
+	attachments, err := attachmentIds(message.AttachmentIds)
+	if err != nil {
+		return err
+	}
+
+	for _, mention := range message.Mentions {
+		userId, err := strconv.Atoi(mention.UserId)
+		if err != nil {
+			continue
+		}
+		msg.Mentions = append(msg.Mentions, model.Mention{
+			UserId: uint(userId),
+			Offset: mention.Offset,
+			Length: mention.Length,
+		})
+	}
+
+	if message.QuoteId != "" {
+		quote, err := s.getReferenced(message.RoomId, message.QuoteId, ErrUnknownQuote)
+		if err != nil {
+			return err
+		}
+		msg.QuoteId = &quote.ID
+	}
+
+	if message.ParentId != "" {
+		parent, err := s.getReferenced(message.RoomId, message.ParentId, ErrUnknownParent)
+		if err != nil {
+			return err
+		}
+		if parent.ParentId != nil {
+			msg.ParentId = parent.ParentId
+		} else {
+			msg.ParentId = &parent.ID
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(msg).Error
+		if err != nil {
+			return err
+		}
+
+		err = attachToMessage(tx, msg, attachments)
+		if err != nil {
+			return err
+		}
+
+		// Sert à trier les conversations par dernière activité
+		err = tx.Model(&model.Room{}).Where("room_id = ?", msg.RoomId).Update("last_activity_at", msg.CreatedAt).Error
+		if err != nil {
+			return err
+		}
+
+		if msg.ParentId == nil {
+			return nil
+		}
+
+		return tx.Model(&model.Message{}).Where("id = ?", *msg.ParentId).Update("reply_count", gorm.Expr("reply_count + 1")).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	message.Id = strconv.Itoa(int(msg.ID))
+	message.Attachments = attachmentPayloads(msg.Attachments)
+	if msg.ParentId != nil {
+		message.ParentId = strconv.Itoa(int(*msg.ParentId))
+	}
+
+	return nil
+}
+
+// getReferenced gets a message referenced by another one, notFound is returned if it is not in the room
+func (s *MessageService) getReferenced(roomid string, id string, notFound error) (*model.Message, error) {
+	messageId, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, ErrInvalidMessageId
+	}
+
+	msg, err := s.GetMessage(roomid, messageId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, notFound
+	}
+
+	return msg, err
+}
+
+/*
+GetMessages returns the messages of a room which are not replies, most recent first.
+Deleted messages are returned as tombstones, without their text.
+The text of the others is parsed in Content.
+
+Parameters:
+  - roomid (string): the room
+  - before (int): only messages with a lower ID are returned, 0 for the most recent ones
+  - limit (int): the maximum number of messages
+
+Returns:
+  - ([]*model.Message): the messages
+  - (error): a database error
+*/
+func (s *MessageService) GetMessages(roomid string, before int, limit int) ([]*model.Message, error) {
+	messages := []*model.Message{}
+	query := s.db.Unscoped().Where("room_id = ? AND parent_id IS NULL", roomid)
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	err := query.Preload("Mentions").Preload("Attachments").Preload("Previews").Order("id DESC").Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return present(messages), nil
+}
+
+/*
+GetThread returns the first message of a thread and its replies, oldest first.
+
+Parameters:
+  - roomid (string): the room
+  - id (int): the ID of the first message of the thread
+
+Returns:
+  - (*model.Message): the first message of the thread
+  - ([]*model.Message): the replies
+  - (error): gorm.ErrRecordNotFound if there is no such message in the room
+*/
+func (s *MessageService) GetThread(roomid string, id int) (*model.Message, []*model.Message, error) {
+	var parent model.Message
+	err := s.db.Unscoped().Preload("Mentions").Preload("Attachments").Preload("Previews").Where("room_id = ?", roomid).First(&parent, id).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replies := []*model.Message{}
+	err = s.db.Unscoped().Preload("Mentions").Preload("Attachments").Preload("Previews").Where("parent_id = ?", parent.ID).Order("id").Find(&replies).Error
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return present([]*model.Message{&parent})[0], present(replies), nil
+}
+
+// present parses the text of the messages, the deleted ones lose their text and attachments
+func present(messages []*model.Message) []*model.Message {
+	for _, msg := range messages {
+		if msg.DeletedAt.Valid {
+			msg.Text = ""
+			msg.Attachments = nil
+			msg.Previews = nil
+			continue
+		}
+		msg.Content = markdown.Parse(msg.Text)
+	}
+
+	return messages
+}
+
+/*
+GetMessage retrieves a message of a room by its ID. Deleted messages are not found.
+
+Parameters:
+  - roomid (string): the room the message was posted in
+  - id (int): the ID of the message
+
+Returns:
+  - (*model.Message): the message
+  - (error): gorm.ErrRecordNotFound if there is no such message in the room
+*/
+func (s *MessageService) GetMessage(roomid string, id int) (*model.Message, error) {
+	var msg model.Message
+	err := s.db.Where("room_id = ?", roomid).First(&msg, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+/*
+UpdateMessage saves the edit of a message checked by the room manager, keeping the previous
+text in its edit history and replacing its mentions. Only the author of the message can edit it.
+It makes MessageService usable as the MessageStore of the manager.
+
+Parameters:
+  - message (*Message): the message with its Id, room, author, new text and mentions
+
+Returns:
+  - ([]string): the users mentioned by the new text and not by the previous one
+  - (error): ErrInvalidMessageId, gorm.ErrRecordNotFound if the message is not in the room,
+    ErrNotAuthor if the user is not the author, or a database error
+*/
+func (s *MessageService) UpdateMessage(message *Message) ([]string, error) {
+	id, err := strconv.Atoi(message.Id)
+	if err != nil {
+		return nil, ErrInvalidMessageId
+	}
+
+	msg, err := s.GetMessage(message.RoomId, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.UserId != message.UserId {
+		return nil, ErrNotAuthor
+	}
+
+	previous := []model.Mention{}
+	err = s.db.Where("message_id = ?", msg.ID).Find(&previous).Error
+	if err != nil {
+		return nil, err
+	}
+	mentioned := map[uint]bool{}
+	for _, mention := range previous {
+		mentioned[mention.UserId] = true
+	}
+
+	mentions := []model.Mention{}
+	newlyMentioned := []string{}
+	for _, mention := range message.Mentions {
+		userId, err := strconv.Atoi(mention.UserId)
+		if err != nil {
+			continue
+		}
+		mentions = append(mentions, model.Mention{
+			MessageId: msg.ID,
+			UserId:    uint(userId),
+			Offset:    mention.Offset,
+			Length:    mention.Length,
+		})
+		if !mentioned[uint(userId)] {
+			mentioned[uint(userId)] = true
+			newlyMentioned = append(newlyMentioned, mention.UserId)
+		}
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&model.MessageEdit{
+			MessageId: msg.ID,
+			Text:      msg.Text,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		msg.Text = message.Text
+		msg.EditedAt = &now
+		err = tx.Save(msg).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("message_id = ?", msg.ID).Delete(&model.Mention{}).Error
+		if err != nil || len(mentions) == 0 {
+			return err
+		}
+
+		return tx.Create(&mentions).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newlyMentioned, nil
+}
+
+/*
+DeleteMessage soft deletes a message, leaving a tombstone recording who deleted it.
+
+Parameters:
+  - msg (*model.Message): the message to delete
+  - deletedBy (uint): the ID of the user deleting the message
+*/
+func (s *MessageService) DeleteMessage(msg *model.Message, deletedBy uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(msg).Update("deleted_by_id", deletedBy).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Delete(msg).Error
+	})
+}
+
+// GetEdits returns the edit history of a message, oldest first
+func (s *MessageService) GetEdits(id uint) ([]*model.MessageEdit, error) {
+	edits := []*model.MessageEdit{}
+	err := s.db.Where("message_id = ?", id).Order("id").Find(&edits).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return edits, nil
+}
+
+/*
+GetMentions returns the messages mentioning the user, most recent first,
+leaving out the direct conversations the user is not part of.
+
+Parameters:
+  - userId (uint): the ID of the user
+  - before (int): only messages with a lower ID are returned, 0 for the most recent ones
+  - limit (int): the maximum number of messages
+
+Returns:
+  - ([]*model.Message): the messages
+  - (error): a database error
+*/
+func (s *MessageService) GetMentions(userId uint, before int, limit int) ([]*model.Message, error) {
+	messages := []*model.Message{}
+	query := s.db.Preload("Mentions").Preload("Attachments").Preload("Previews").
+		Where("id IN (?)", s.db.Model(&model.Mention{}).Select("message_id").Where("user_id = ?", userId)).
+		// Les mentions dans une conversation dont l'utilisateur ne fait pas partie sont ignorées
+		Where("room_id NOT LIKE ? OR room_id IN (?)", DirectRoomPrefix+"%", s.db.Model(&model.RoomMember{}).Select("room_id").Where("user_id = ?", userId))
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	err := query.Order("id DESC").Limit(limit).Find(&messages).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return present(messages), nil
+}
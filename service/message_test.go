@@ -0,0 +1,66 @@
+package service
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/riri95500/go-chat/internal/fakedb"
+)
+
+// messageService holds message 7 of user 1 in general, which mentions user 2
+func messageService(t *testing.T) (*MessageService, *fakedb.DB) {
+	t.Helper()
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "FROM `messages`"):
+			return fakedb.Rows([]string{"id", "room_id", "user_id", "text"}, int64(7), "general", "1", "hi @bob")
+		case strings.Contains(query, "FROM `mentions`"):
+			return fakedb.Rows([]string{"id", "message_id", "user_id", "offset", "length"}, int64(1), int64(7), int64(2), int64(3), int64(4))
+		}
+		return nil, nil
+	})
+	return NewMessageService(db), fake
+}
+
+func TestUpdateMessage(t *testing.T) {
+	s, fake := messageService(t)
+	newlyMentioned, err := s.UpdateMessage(&Message{
+		Id:       "7",
+		UserId:   "1",
+		RoomId:   "general",
+		Text:     "hi @bob and @carol",
+		Mentions: []Mention{{UserId: "2", Offset: 3, Length: 4}, {UserId: "3", Offset: 12, Length: 6}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(newlyMentioned, []string{"3"}) {
+		t.Errorf("newly mentioned %v, want only user 3", newlyMentioned)
+	}
+	for _, part := range []string{"INSERT INTO `message_edits`", "UPDATE `messages`", "DELETE FROM `mentions`", "INSERT INTO `mentions`"} {
+		if !fake.Ran(part) {
+			t.Errorf("%s not run", part)
+		}
+	}
+}
+
+func TestUpdateMessageRefusesOtherEdits(t *testing.T) {
+	tests := []struct {
+		message *Message
+		want    error
+	}{
+		{&Message{Id: "7", UserId: "2", RoomId: "general", Text: "mine now"}, ErrNotAuthor},
+		{&Message{Id: "abc", UserId: "1", RoomId: "general", Text: "hello"}, ErrInvalidMessageId},
+	}
+	for _, tt := range tests {
+		s, fake := messageService(t)
+		if _, err := s.UpdateMessage(tt.message); err != tt.want {
+			t.Errorf("edit of message %s by %s: error %v, want %v", tt.message.Id, tt.message.UserId, err, tt.want)
+		}
+		if fake.Ran("UPDATE") {
+			t.Errorf("edit of message %s by %s saved", tt.message.Id, tt.message.UserId)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"time"
+
+	"github.com/riri95500/go-chat/metrics"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+var (
+	broadcastSubmitted = metrics.NewCounterVec("chat_broadcast_submitted_total",
+		"Events submitted to the room broadcasters, rejected when their buffer was full.", "result")
+	broadcastDropped = metrics.NewCounterVec("chat_broadcast_dropped_total",
+		"Events skipped for listeners too slow to receive them, with the drop policy.")
+	managerRooms = metrics.NewGaugeVec("chat_rooms",
+		"Rooms running in the manager, including the user rooms.")
+	managerListeners = metrics.NewGaugeVec("chat_listeners",
+		"Listeners open in every room.")
+	managerQueue = metrics.NewGaugeVec("chat_manager_queue_depth",
+		"Requests waiting in a control channel of the room manager.", "channel")
+)
+
+// Les séries existent dès le démarrage, à 0
+func init() {
+	broadcastSubmitted.With("accepted")
+	broadcastSubmitted.With("rejected")
+	broadcastDropped.With()
+}
+
+// broadcastObserver counts the events of every room broadcaster
+type broadcastObserver struct{}
+
+func (broadcastObserver) Submitted(accepted bool) {
+	if accepted {
+		broadcastSubmitted.With("accepted").Inc()
+	} else {
+		broadcastSubmitted.With("rejected").Inc()
+	}
+}
+
+func (broadcastObserver) Dropped() {
+	broadcastDropped.With().Inc()
+}
+
+// Delivered traces the fan-out of the events sent on behalf of a traced request
+func (broadcastObserver) Delivered(m interface{}, subscribers int, start time.Time) {
+	event, ok := m.(*Event)
+	if !ok || !event.trace.IsValid() {
+		return
+	}
+	tracing.Record(event.trace, "broadcast.fanout", start, time.Now(),
+		"room.id", event.RoomId, "event.type", string(event.Type), "event.id", event.Id, "listeners", subscribers)
+}
+
+// RegisterManagerMetrics refreshes the room, listener and queue gauges from the manager on every scrape
+func RegisterManagerMetrics(manager Manager) {
+	metrics.Default.OnCollect(func() {
+		stats := manager.Stats()
+		managerRooms.With().Set(float64(stats.Rooms))
+		managerListeners.With().Set(float64(stats.Listeners))
+		for channel, depth := range stats.Queues {
+			managerQueue.With(channel).Set(float64(depth))
+		}
+	})
+}
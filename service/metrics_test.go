@@ -0,0 +1,54 @@
+package service
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/metrics"
+)
+
+func TestStatsCountRoomsAndListeners(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	general := m.OpenListener("general")
+	m.OpenListener("general")
+	m.OpenListener("random")
+	waitListeners(t, m, 3)
+
+	stats := m.Stats()
+	if stats.Rooms != 2 {
+		t.Errorf("%d rooms, want 2", stats.Rooms)
+	}
+	if _, ok := stats.Queues["messages"]; !ok {
+		t.Errorf("queues %v, want the messages channel", stats.Queues)
+	}
+
+	m.CloseListener("general", general)
+	waitListeners(t, m, 2)
+	m.DeleteBroadcast("random")
+	waitListeners(t, m, 1)
+
+	deadline := time.Now().Add(time.Second)
+	for m.Stats().Rooms != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d rooms after a deletion, want 1", m.Stats().Rooms)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestManagerMetricsRefreshedOnScrape(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	m.OpenListener("general")
+	waitListeners(t, m, 1)
+	RegisterManagerMetrics(m)
+
+	w := httptest.NewRecorder()
+	metrics.Default.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{"\nchat_rooms 1\n", "\nchat_listeners 1\n", `chat_manager_queue_depth{channel="messages"} 0`, `chat_broadcast_submitted_total{result="rejected"}`} {
+		if !strings.Contains(w.Body.String(), line) {
+			t.Errorf("%q not scraped", line)
+		}
+	}
+}
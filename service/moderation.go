@@ -0,0 +1,221 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCannotModerate  = errors.New("this user cannot be moderated")
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+type ModerationService struct {
+	db     *gorm.DB
+	logger *slog.Logger
+}
+
+func NewModerationService(db *gorm.DB, logger *slog.Logger) *ModerationService {
+	return &ModerationService{
+		db:     db,
+		logger: logger,
+	}
+}
+
+/*
+Record saves a moderation action in the moderation log of its room and persists
+its effect: the ban or mute in force, the slow mode of the room, or the role of a member.
+The action is then applied to the running room with Manager.Moderate.
+
+Parameters:
+  - moderation (*Moderation): the action
+
+Returns:
+  - (*model.ModerationAction): the entry of the moderation log
+  - (error): a database error
+*/
+func (s *ModerationService) Record(moderation *Moderation) (*model.ModerationAction, error) {
+	moderatorId, err := strconv.Atoi(moderation.ModeratorId)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &model.ModerationAction{
+		RoomId:      moderation.RoomId,
+		ModeratorId: uint(moderatorId),
+		Action:      moderation.Action,
+		SlowMode:    int(moderation.SlowMode / time.Second),
+		Reason:      moderation.Reason,
+	}
+	if moderation.UserId != "" {
+		userId, err := strconv.Atoi(moderation.UserId)
+		if err != nil {
+			return nil, err
+		}
+		target := uint(userId)
+		entry.UserId = &target
+	}
+	if moderation.MessageId != "" {
+		messageId, err := strconv.Atoi(moderation.MessageId)
+		if err != nil {
+			return nil, ErrInvalidMessageId
+		}
+		message := uint(messageId)
+		entry.MessageId = &message
+	}
+	if !moderation.Until.IsZero() {
+		entry.ExpiresAt = &moderation.Until
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(entry).Error
+		if err != nil {
+			return err
+		}
+
+		switch entry.Action {
+		case model.ModerationBan, model.ModerationMute:
+			return tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "room_id"}, {Name: "user_id"}, {Name: "kind"}},
+				DoUpdates: clause.AssignmentColumns([]string{"expires_at", "updated_at"}),
+			}).Create(&model.RoomRestriction{
+				RoomId:    entry.RoomId,
+				UserId:    *entry.UserId,
+				Kind:      entry.Action,
+				ExpiresAt: entry.ExpiresAt,
+			}).Error
+		case model.ModerationUnban, model.ModerationUnmute:
+			kind := model.ModerationBan
+			if entry.Action == model.ModerationUnmute {
+				kind = model.ModerationMute
+			}
+			return tx.Where("room_id = ? AND user_id = ? AND kind = ?", entry.RoomId, *entry.UserId, kind).Delete(&model.RoomRestriction{}).Error
+		case model.ModerationSlowMode:
+			return tx.Model(&model.Room{}).Where("room_id = ?", entry.RoomId).Update("slow_mode", entry.SlowMode).Error
+		case model.ModerationGrantModerator:
+			return setRole(tx, entry.RoomId, *entry.UserId, model.RoleModerator)
+		case model.ModerationRevokeModerator:
+			return tx.Model(&model.RoomMember{}).
+				Where("room_id = ? AND user_id = ? AND role = ?", entry.RoomId, *entry.UserId, model.RoleModerator).
+				Update("role", model.RoleMember).Error
+		case model.ModerationSetOwner:
+			// L'ancien propriétaire reste modérateur
+			err := tx.Model(&model.RoomMember{}).
+				Where("room_id = ? AND role = ?", entry.RoomId, model.RoleOwner).
+				Update("role", model.RoleModerator).Error
+			if err != nil {
+				return err
+			}
+			return setRole(tx, entry.RoomId, *entry.UserId, model.RoleOwner)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// setRole gives the role to the user in the public room, making them a member if needed
+func setRole(tx *gorm.DB, roomid string, userId uint, role string) error {
+	if _, err := getOrCreateRoom(tx, roomid, model.RoomKindPublic); err != nil {
+		return err
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "room_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"role":       role,
+			"updated_at": time.Now(),
+			"deleted_at": nil,
+		}),
+	}).Create(&model.RoomMember{
+		RoomId: roomid,
+		UserId: userId,
+		Role:   role,
+	}).Error
+}
+
+/*
+LoadModeration returns the slow mode and the bans and mutes in force of a room.
+It is the ModerationLoader of the manager, errors are logged and give an unmoderated room.
+*/
+func (s *ModerationService) LoadModeration(roomid string) RoomModeration {
+	moderation := RoomModeration{
+		Bans:  map[string]time.Time{},
+		Mutes: map[string]time.Time{},
+	}
+
+	var room model.Room
+	err := s.db.Select("slow_mode").Where("room_id = ?", roomid).Take(&room).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Error("slow mode not loaded", "room", roomid, "error", err)
+	}
+	moderation.SlowMode = time.Duration(room.SlowMode) * time.Second
+
+	restrictions, err := s.GetRestrictions(roomid)
+	if err != nil {
+		s.logger.Error("restrictions not loaded", "room", roomid, "error", err)
+	}
+	for _, restriction := range restrictions {
+		until := time.Time{}
+		if restriction.ExpiresAt != nil {
+			until = *restriction.ExpiresAt
+		}
+
+		userid := strconv.Itoa(int(restriction.UserId))
+		if restriction.Kind == model.ModerationBan {
+			moderation.Bans[userid] = until
+		} else {
+			moderation.Mutes[userid] = until
+		}
+	}
+
+	return moderation
+}
+
+// GetRestrictions returns the bans and mutes in force in the room
+func (s *ModerationService) GetRestrictions(roomid string) ([]*model.RoomRestriction, error) {
+	restrictions := []*model.RoomRestriction{}
+	err := s.db.Where("room_id = ? AND (expires_at IS NULL OR expires_at > ?)", roomid, time.Now()).Order("id").Find(&restrictions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return restrictions, nil
+}
+
+/*
+GetLog returns the moderation log of a room, most recent first.
+
+Parameters:
+  - roomid (string): the room
+  - before (int): only entries with a lower ID are returned, 0 for the most recent ones
+  - limit (int): the maximum number of entries
+
+Returns:
+  - ([]*model.ModerationAction): the entries
+  - (error): a database error
+*/
+func (s *ModerationService) GetLog(roomid string, before int, limit int) ([]*model.ModerationAction, error) {
+	entries := []*model.ModerationAction{}
+	query := s.db.Where("room_id = ?", roomid)
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	err := query.Order("id DESC").Limit(limit).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
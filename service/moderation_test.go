@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+)
+
+// filterFunc lets a function stand in for a MessageFilter
+type filterFunc func(message *Message) FilterResult
+
+func (f filterFunc) Filter(message *Message) FilterResult {
+	return f(message)
+}
+
+// moderated applies the moderation and waits for its event, so that the room knows it
+func moderated(t *testing.T, m *manager, listener chan interface{}, moderation *Moderation) {
+	t.Helper()
+	m.Moderate(moderation)
+	if event := nextEvent(t, listener); event.Type != EventModeration {
+		t.Fatalf("got %s, want the moderation event", event.Type)
+	}
+}
+
+func TestModerationLoaderRunsOffManagerGoroutine(t *testing.T) {
+	release := make(chan struct{})
+	options := DefaultManagerOptions()
+	options.ModerationLoader = func(roomid string) RoomModeration {
+		if strings.HasPrefix(roomid, UserRoomPrefix) {
+			t.Errorf("user room %s loaded", roomid)
+		}
+		<-release
+		return RoomModeration{
+			Bans:  map[string]time.Time{"2": {}},
+			Mutes: map[string]time.Time{"3": time.Now().Add(time.Hour)},
+		}
+	}
+	m := startManager(t, options)
+
+	go m.OpenListener("general")
+	m.OpenListener(UserRoomId("1"))
+	waitListeners(t, m, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Ping(ctx); err != nil {
+		t.Fatalf("manager blocked by the loader: %v", err)
+	}
+
+	close(release)
+	waitListeners(t, m, 2)
+	for userid, want := range map[string]error{"1": nil, "2": ErrBanned, "3": ErrMuted} {
+		if err := m.Admit(userid, "general"); err != want {
+			t.Errorf("Admit(%s) = %v, want %v", userid, err, want)
+		}
+	}
+}
+
+func TestModerateWaitsForTheRoomToLoad(t *testing.T) {
+	release := make(chan struct{})
+	options := DefaultManagerOptions()
+	// L'état persisté est lu avant que le slow mode ne soit enregistré
+	options.ModerationLoader = func(roomid string) RoomModeration {
+		<-release
+		return RoomModeration{}
+	}
+	m := startManager(t, options)
+
+	go m.OpenListener("general")
+	time.Sleep(20 * time.Millisecond)
+	m.Moderate(&Moderation{Action: model.ModerationSlowMode, RoomId: "general", ModeratorId: "1", SlowMode: time.Minute})
+	time.Sleep(20 * time.Millisecond)
+
+	close(release)
+	waitListeners(t, m, 1)
+	time.Sleep(20 * time.Millisecond)
+	if rooms := m.Rooms(); len(rooms) != 1 || rooms[0].SlowMode != 60 {
+		t.Errorf("rooms %+v, the slow mode was overwritten by the loaded state", rooms)
+	}
+}
+
+func TestAdmitChecksBansAndMutes(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	moderated(t, m, listener, &Moderation{Action: model.ModerationMute, RoomId: "general", ModeratorId: "1", UserId: "2"})
+	moderated(t, m, listener, &Moderation{Action: model.ModerationBan, RoomId: "general", ModeratorId: "1", UserId: "3"})
+	moderated(t, m, listener, &Moderation{Action: model.ModerationMute, RoomId: "general", ModeratorId: "1", UserId: "4", Until: time.Now().Add(-time.Second)})
+
+	for userid, want := range map[string]error{"1": nil, "2": ErrMuted, "3": ErrBanned, "4": nil} {
+		if err := m.Admit(userid, "general"); err != want {
+			t.Errorf("Admit(%s) = %v, want %v", userid, err, want)
+		}
+	}
+
+	moderated(t, m, listener, &Moderation{Action: model.ModerationUnmute, RoomId: "general", ModeratorId: "1", UserId: "2"})
+	if err := m.Admit("2", "general"); err != nil {
+		t.Errorf("Admit of an unmuted user = %v", err)
+	}
+}
+
+func TestTypingOfMutedUsersIgnored(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	moderated(t, m, listener, &Moderation{Action: model.ModerationMute, RoomId: "general", ModeratorId: "1", UserId: "2"})
+
+	m.Typing("2", "general", true)
+	noEvent(t, listener)
+}
+
+func TestSlowModeSlotTakenByAcceptedMessages(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Filters = []MessageFilter{filterFunc(func(message *Message) FilterResult {
+		if message.Text == "spam" {
+			return FilterResult{Action: FilterReject, Reason: "spam"}
+		}
+		return FilterResult{Action: FilterAllow}
+	})}
+	m := startManager(t, options)
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	moderated(t, m, listener, &Moderation{Action: model.ModerationSlowMode, RoomId: "general", ModeratorId: "1", SlowMode: time.Minute})
+
+	submit := func(text string) error {
+		return m.SubmitMessage(context.Background(), &Message{UserId: "2", RoomId: "general", Text: text})
+	}
+	if err := submit("spam"); !errors.Is(err, ErrMessageRejected) {
+		t.Fatalf("rejected message: %v", err)
+	}
+	if err := submit(strings.Repeat("a", 5000)); err != ErrMessageTooLarge {
+		t.Fatalf("message too large: %v", err)
+	}
+	// Les messages refusés n'ont pas consommé le créneau
+	if err := submit("hello"); err != nil {
+		t.Fatalf("first accepted message: %v", err)
+	}
+	if messageText(t, nextEvent(t, listener)) != "hello" {
+		t.Fatal("the accepted message was not broadcast")
+	}
+	if err := submit("again"); err != ErrSlowMode {
+		t.Errorf("second message = %v, want ErrSlowMode", err)
+	}
+}
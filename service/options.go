@@ -0,0 +1,191 @@
+package service
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/markdown"
+)
+
+// RoomOptions tunes the broadcaster and the limits of a single room
+type RoomOptions struct {
+	// Size of the broadcaster input buffer
+	BufferSize int
+	// Maximum number of listeners, 0 means unlimited
+	MaxSubscribers int
+	// Maximum length in bytes of a message text, 0 means unlimited
+	MaxMessageSize int
+	// Number of past messages replayed to new listeners
+	HistoryDepth int
+	// What to do with listeners that do not keep up
+	SlowConsumerPolicy broadcast.SlowConsumerPolicy
+}
+
+// ManagerOptions holds the global settings of the room manager
+type ManagerOptions struct {
+	// Size of the open, close, delete and messages control channels
+	ControlBufferSize int
+	// Options applied to every room that is not created with its own
+	Room RoomOptions
+	// Rooms without listeners and inactive for this long are reclaimed, except the created ones, 0 or less disables it
+	IdleTTL time.Duration
+	// How often idle rooms are looked for
+	GCInterval time.Duration
+	// Called when a room is used for the first time, so persisted rooms get their own options back
+	RoomLoader RoomLoader
+	// Where messages are saved before being broadcast, nil to keep them in memory only
+	MessageStore MessageStore
+	// Finds the users mentioned in messages, nil to ignore mentions
+	MentionResolver MentionResolver
+	// Previews the links of the stored messages, nil to leave links as they are
+	LinkUnfurler LinkUnfurler
+	// Run in order on every message before it is saved, see MessageFilter
+	Filters []MessageFilter
+	// Where the messages flagged by the filters are queued for review, nil to let them through unnoticed
+	FlaggedMessageStore FlaggedMessageStore
+	// Where moderation actions are audited, nil to audit nothing
+	AuditLogger AuditLogger
+	// Called when a room is created on demand, so that its bans, mutes and slow mode survive
+	ModerationLoader ModerationLoader
+	// Where the manager reports the errors it cannot return
+	Logger *slog.Logger
+	// A typing user who sends no new signal for this long is considered stopped
+	TypingTTL time.Duration
+	// Minimum delay between two typing broadcasts of the same user
+	TypingThrottle time.Duration
+}
+
+/*
+RoomLoader returns the options of a persisted room, ok is false for unknown rooms
+which then use the global options. It runs on a goroutine of its own, the listeners
+and the messages of the room wait for it. The user rooms are never loaded.
+*/
+type RoomLoader func(roomid string) (options RoomOptions, ok bool)
+
+/*
+ModerationLoader returns the moderation state of a persisted room. Like RoomLoader it runs
+on a goroutine of its own, and the user rooms are never loaded.
+*/
+type ModerationLoader func(roomid string) RoomModeration
+
+/*
+DefaultManagerOptions returns the settings the manager has always used:
+100-slot control channels and 10-slot room broadcasters accepting messages
+of at most 4KB, without other limits.
+Rooms left without listeners are reclaimed after 10 minutes.
+Typing indicators expire after 5 seconds and are broadcast at most once per second.
+*/
+func DefaultManagerOptions() ManagerOptions {
+	return ManagerOptions{
+		ControlBufferSize: 100,
+		Room: RoomOptions{
+			BufferSize:         10,
+			MaxMessageSize:     4096,
+			SlowConsumerPolicy: broadcast.Block,
+		},
+		IdleTTL:        10 * time.Minute,
+		GCInterval:     time.Minute,
+		TypingTTL:      5 * time.Second,
+		TypingThrottle: time.Second,
+		Logger:         slog.Default(),
+	}
+}
+
+/*
+NewManagerOptions builds the ManagerOptions from the application Config,
+falling back on DefaultManagerOptions for every value left unset.
+ROOM_IDLE_TTL set to 0 or less disables the collection of idle rooms.
+
+Parameters:
+  - conf (*config.Config): the application configuration
+
+Returns:
+  - (ManagerOptions): the manager settings
+  - (error): an error if the slow consumer policy is unknown
+*/
+func NewManagerOptions(conf *config.Config) (ManagerOptions, error) {
+	options := DefaultManagerOptions()
+
+	if conf.MANAGER_CONTROL_BUFFER_SIZE > 0 {
+		options.ControlBufferSize = conf.MANAGER_CONTROL_BUFFER_SIZE
+	}
+	if conf.ROOM_BUFFER_SIZE > 0 {
+		options.Room.BufferSize = conf.ROOM_BUFFER_SIZE
+	}
+	options.Room.MaxSubscribers = conf.ROOM_MAX_SUBSCRIBERS
+	if conf.ROOM_MAX_MESSAGE_SIZE > 0 {
+		options.Room.MaxMessageSize = conf.ROOM_MAX_MESSAGE_SIZE
+	}
+	options.Room.HistoryDepth = conf.ROOM_HISTORY_DEPTH
+	// Non défini : on garde la valeur par défaut, 0 désactive la collecte
+	if conf.ROOM_IDLE_TTL != nil {
+		options.IdleTTL = *conf.ROOM_IDLE_TTL
+	}
+	if conf.ROOM_GC_INTERVAL > 0 {
+		options.GCInterval = conf.ROOM_GC_INTERVAL
+	}
+	if conf.TYPING_TTL > 0 {
+		options.TypingTTL = conf.TYPING_TTL
+	}
+	if conf.TYPING_THROTTLE > 0 {
+		options.TypingThrottle = conf.TYPING_THROTTLE
+	}
+
+	policy, err := broadcast.ParseSlowConsumerPolicy(conf.ROOM_SLOW_CONSUMER_POLICY)
+	if err != nil {
+		return options, err
+	}
+	options.Room.SlowConsumerPolicy = policy
+
+	return options, nil
+}
+
+func (o RoomOptions) broadcasterOptions() broadcast.Options {
+	return broadcast.Options{
+		BufferSize:         o.BufferSize,
+		HistoryDepth:       o.HistoryDepth,
+		SlowConsumerPolicy: o.SlowConsumerPolicy,
+		Keep:               isHistoryEvent,
+		Revise:             reviseHistory,
+		Observer:           broadcastObserver{},
+	}
+}
+
+// Only messages are replayed to new listeners, presence and typing are ephemeral
+func isHistoryEvent(m interface{}) bool {
+	event, ok := m.(*Event)
+	return ok && event.Type == EventMessage
+}
+
+/*
+reviseHistory applies the edits and deletions to the messages of the history,
+so that a new listener is not replayed a message as it was before.
+*/
+func reviseHistory(entry, m interface{}) (interface{}, bool) {
+	event, ok := m.(*Event)
+	kept, isEvent := entry.(*Event)
+	if !ok || !isEvent {
+		return entry, true
+	}
+
+	switch payload := event.Payload.(type) {
+	case MessageDeletedPayload:
+		return entry, kept.Id != payload.MessageId
+	case MessageEditedPayload:
+		message, ok := kept.Payload.(MessagePayload)
+		if kept.Id != payload.MessageId || !ok {
+			return entry, true
+		}
+		// L'évènement a déjà été envoyé aux listeners, on en garde une copie modifiée
+		message.Text = payload.Text
+		message.Content = payload.Content
+		message.Plain = markdown.PlainText(payload.Content)
+		revised := *kept
+		revised.Payload = message
+		return &revised, true
+	}
+
+	return entry, true
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/config"
+)
+
+func TestNewManagerOptionsIdleTTL(t *testing.T) {
+	zero, hour := time.Duration(0), time.Hour
+	tests := []struct {
+		name string
+		ttl  *time.Duration
+		want time.Duration
+	}{
+		{"unset keeps the default", nil, DefaultManagerOptions().IdleTTL},
+		{"0 disables the collection", &zero, 0},
+		{"set", &hour, time.Hour},
+	}
+	for _, tt := range tests {
+		options, err := NewManagerOptions(&config.Config{ROOM_IDLE_TTL: tt.ttl})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if options.IdleTTL != tt.want {
+			t.Errorf("%s: IdleTTL = %v, want %v", tt.name, options.IdleTTL, tt.want)
+		}
+	}
+}
+
+func TestNewManagerOptions(t *testing.T) {
+	options, err := NewManagerOptions(&config.Config{
+		ROOM_BUFFER_SIZE:          32,
+		ROOM_MAX_SUBSCRIBERS:      5,
+		ROOM_SLOW_CONSUMER_POLICY: "drop",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Room.BufferSize != 32 || options.Room.MaxSubscribers != 5 || options.Room.SlowConsumerPolicy != broadcast.Drop {
+		t.Errorf("room options not applied: %+v", options.Room)
+	}
+	if options.ControlBufferSize != DefaultManagerOptions().ControlBufferSize {
+		t.Errorf("ControlBufferSize = %d, want the default", options.ControlBufferSize)
+	}
+	// Les messages restent limités quand rien n'est configuré
+	if options.Room.MaxMessageSize != 4096 {
+		t.Errorf("MaxMessageSize = %d, want 4096 by default", options.Room.MaxMessageSize)
+	}
+	options, _ = NewManagerOptions(&config.Config{ROOM_MAX_MESSAGE_SIZE: 100})
+	if options.Room.MaxMessageSize != 100 {
+		t.Errorf("MaxMessageSize = %d, want 100", options.Room.MaxMessageSize)
+	}
+
+	if _, err := NewManagerOptions(&config.Config{ROOM_SLOW_CONSUMER_POLICY: "skip"}); err == nil {
+		t.Error("an unknown slow consumer policy is accepted")
+	}
+}
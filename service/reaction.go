@@ -0,0 +1,188 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidEmoji   = errors.New("invalid emoji")
+	ErrAlreadyReacted = errors.New("already reacted with this emoji")
+)
+
+// pictographic holds the emoji runes: the emoji planes, and the older symbols drawn as emoji
+var pictographic = &unicode.RangeTable{
+	R16: []unicode.Range16{
+		{Lo: 0x00a9, Hi: 0x00ae, Stride: 5},
+		{Lo: 0x203c, Hi: 0x2049, Stride: 13},
+		{Lo: 0x2122, Hi: 0x2139, Stride: 23},
+		{Lo: 0x2194, Hi: 0x2199, Stride: 1},
+		{Lo: 0x21a9, Hi: 0x21aa, Stride: 1},
+		{Lo: 0x231a, Hi: 0x231b, Stride: 1},
+		{Lo: 0x2328, Hi: 0x2328, Stride: 1},
+		{Lo: 0x23cf, Hi: 0x23cf, Stride: 1},
+		{Lo: 0x23e9, Hi: 0x23f3, Stride: 1},
+		{Lo: 0x23f8, Hi: 0x23fa, Stride: 1},
+		{Lo: 0x24c2, Hi: 0x24c2, Stride: 1},
+		{Lo: 0x25aa, Hi: 0x25ab, Stride: 1},
+		{Lo: 0x25b6, Hi: 0x25c0, Stride: 10},
+		{Lo: 0x25fb, Hi: 0x25fe, Stride: 1},
+		{Lo: 0x2600, Hi: 0x27bf, Stride: 1},
+		{Lo: 0x2934, Hi: 0x2935, Stride: 1},
+		{Lo: 0x2b05, Hi: 0x2b07, Stride: 1},
+		{Lo: 0x2b1b, Hi: 0x2b1c, Stride: 1},
+		{Lo: 0x2b50, Hi: 0x2b55, Stride: 5},
+		{Lo: 0x3030, Hi: 0x303d, Stride: 13},
+		{Lo: 0x3297, Hi: 0x3299, Stride: 2},
+	},
+	R32: []unicode.Range32{
+		// Drapeaux, symboles, visages, personnes et tons de peau
+		{Lo: 0x1f000, Hi: 0x1faff, Stride: 1},
+	},
+	LatinOffset: 1,
+}
+
+const (
+	zeroWidthJoiner = '\u200d'
+	emojiVariation  = '\ufe0f'
+	keycap          = '\u20e3'
+)
+
+// emojiModifier tells if the rune changes the emoji before it: joiner, variation selector, keycap or tag
+func emojiModifier(r rune) bool {
+	return r == zeroWidthJoiner || r == '\ufe0e' || r == emojiVariation || r == keycap || (r >= 0xe0020 && r <= 0xe007f)
+}
+
+/*
+validEmoji accepts a single emoji or emoji sequence: pictographic runes, joined with ZWJ
+and completed with variation selectors, skin tones, keycaps and the tags of subdivision flags.
+*/
+func validEmoji(emoji string) bool {
+	if emoji == "" || len(emoji) > 64 {
+		return false
+	}
+
+	runes := []rune(emoji)
+	for i, r := range runes {
+		switch {
+		case unicode.Is(pictographic, r):
+		case i > 0 && emojiModifier(r):
+		case strings.ContainsRune("0123456789#*", r):
+			// Un chiffre n'est un emoji qu'en keycap, comme 1️⃣
+			next := runes[i+1:]
+			if len(next) > 0 && next[0] == emojiVariation {
+				next = next[1:]
+			}
+			if len(next) == 0 || next[0] != keycap {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+/*
+AddReaction adds the emoji of the user on a message.
+
+Parameters:
+  - msg (*model.Message): the message reacted to
+  - userId (uint): the ID of the user reacting
+  - emoji (string): the emoji
+
+Returns:
+  - (error): ErrInvalidEmoji, ErrAlreadyReacted if the user already added this emoji, or a database error
+*/
+func (s *MessageService) AddReaction(msg *model.Message, userId uint, emoji string) error {
+	if !validEmoji(emoji) {
+		return ErrInvalidEmoji
+	}
+
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&model.Reaction{
+		MessageId: msg.ID,
+		UserId:    userId,
+		Emoji:     emoji,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAlreadyReacted
+	}
+
+	return nil
+}
+
+/*
+RemoveReaction removes the emoji of the user from a message.
+
+Returns:
+  - (error): gorm.ErrRecordNotFound if the user did not react with this emoji, or a database error
+*/
+func (s *MessageService) RemoveReaction(msg *model.Message, userId uint, emoji string) error {
+	result := s.db.Where("message_id = ? AND user_id = ? AND emoji = ?", msg.ID, userId, emoji).Delete(&model.Reaction{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
+
+/*
+LoadReactions fills the Reactions of the messages with the count of every emoji,
+and whether the user reacted with it.
+
+Parameters:
+  - messages ([]*model.Message): the messages to fill
+  - userId (uint): the ID of the user asking
+*/
+func (s *MessageService) LoadReactions(messages []*model.Message, userId uint) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	byId := make(map[uint]*model.Message, len(messages))
+	ids := make([]uint, 0, len(messages))
+	for _, msg := range messages {
+		msg.Reactions = []model.ReactionSummary{}
+		byId[msg.ID] = msg
+		ids = append(ids, msg.ID)
+	}
+
+	var rows []struct {
+		MessageId uint
+		Emoji     string
+		Count     int
+		Me        int
+	}
+	err := s.db.Model(&model.Reaction{}).
+		Select("message_id, emoji, COUNT(*) AS count, SUM(CASE WHEN user_id = ? THEN 1 ELSE 0 END) AS me", userId).
+		Where("message_id IN ?", ids).
+		Group("message_id, emoji").
+		Order("MIN(id)").
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		msg := byId[row.MessageId]
+		msg.Reactions = append(msg.Reactions, model.ReactionSummary{
+			Emoji: row.Emoji,
+			Count: row.Count,
+			Me:    row.Me > 0,
+		})
+	}
+
+	return nil
+}
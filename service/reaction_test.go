@@ -0,0 +1,43 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"❤️", true},
+		{"✅", true},
+		{"©", true},
+		{"👍🏽", true},
+		{"👨‍👩‍👧", true},
+		{"🏳️‍🌈", true},
+		{"🇫🇷", true},
+		{"1️⃣", true},
+		{"#⃣", true},
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"", false},
+		{"a", false},
+		{"lol", false},
+		{"1", false},
+		{"1\ufe0f", false},
+		{"👍a", false},
+		{"👍 ", false},
+		{"<script>", false},
+		{"\u200d", false},
+		{"\ufe0f👍", false},
+		{"é", false},
+		{"中", false},
+		{strings.Repeat("👍", 17), false},
+	}
+	for _, tt := range tests {
+		if got := validEmoji(tt.emoji); got != tt.want {
+			t.Errorf("validEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidReport   = errors.New("a report must reference a message or a user of the room")
+	ErrAlreadyReported = errors.New("you already reported this")
+	ErrReportClosed    = errors.New("this report was already handled")
+	ErrNoMessage       = errors.New("this report is not about a message")
+)
+
+type ReportService struct {
+	db *gorm.DB
+}
+
+func NewReportService(db *gorm.DB) *ReportService {
+	return &ReportService{
+		db: db,
+	}
+}
+
+/*
+CreateReport queues a report for the moderators of its room. A reported message must be
+in the room, its author becomes the reported user. A user cannot report themselves,
+nor report the same message or user again while their first report is pending.
+
+Parameters:
+  - report (*model.Report): the report, with its reporter, room, reason and message or user
+
+Returns:
+  - (error): ErrInvalidReport, gorm.ErrRecordNotFound if the message or the user does not exist,
+    ErrAlreadyReported, or a database error
+*/
+func (s *ReportService) CreateReport(report *model.Report) error {
+	if report.RoomId == "" || (report.MessageId == nil && report.UserId == 0) {
+		return ErrInvalidReport
+	}
+
+	if report.MessageId != nil {
+		var msg model.Message
+		err := s.db.Where("room_id = ?", report.RoomId).First(&msg, *report.MessageId).Error
+		if err != nil {
+			return err
+		}
+
+		userId, err := strconv.Atoi(msg.UserId)
+		if err != nil {
+			return ErrInvalidReport
+		}
+		report.UserId = uint(userId)
+	} else {
+		err := s.db.Select("id").First(&model.User{}, report.UserId).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if report.UserId == report.ReporterId {
+		return ErrInvalidReport
+	}
+
+	var count int64
+	query := s.db.Model(&model.Report{}).Where("reporter_id = ? AND room_id = ? AND status = ?", report.ReporterId, report.RoomId, model.ReportPending)
+	if report.MessageId != nil {
+		query = query.Where("message_id = ?", *report.MessageId)
+	} else {
+		query = query.Where("message_id IS NULL AND user_id = ?", report.UserId)
+	}
+	err := query.Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrAlreadyReported
+	}
+
+	report.Status = model.ReportPending
+	return s.db.Create(report).Error
+}
+
+// GetReport returns a report of the room
+func (s *ReportService) GetReport(roomid string, id int) (*model.Report, error) {
+	var report model.Report
+	err := s.db.Where("room_id = ?", roomid).First(&report, id).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+/*
+GetReports returns the reports of a room, most recent first.
+
+Parameters:
+  - roomid (string): the room
+  - status (string): only the reports with this status, all of them if empty
+  - before (int): only reports with a lower ID are returned, 0 for the most recent ones
+  - limit (int): the maximum number of reports
+
+Returns:
+  - ([]*model.Report): the reports
+  - (error): ErrInvalidStatus, or a database error
+*/
+func (s *ReportService) GetReports(roomid string, status string, before int, limit int) ([]*model.Report, error) {
+	reports := []*model.Report{}
+	query := s.db.Where("room_id = ?", roomid)
+	if status != "" {
+		if status != model.ReportPending && status != model.ReportDismissed && status != model.ReportResolved {
+			return nil, ErrInvalidStatus
+		}
+		query = query.Where("status = ?", status)
+	}
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	err := query.Order("id DESC").Limit(limit).Find(&reports).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return reports, nil
+}
+
+/*
+Close closes a pending report. Only one moderator can close a report,
+the others get ErrReportClosed.
+
+Parameters:
+  - report (*model.Report): the report
+  - moderatorId (uint): the ID of the moderator
+  - status (string): model.ReportDismissed or model.ReportResolved
+  - resolution (string): the action taken, one of the model.Moderation* actions
+
+Returns:
+  - (error): ErrReportClosed, or a database error
+*/
+func (s *ReportService) Close(report *model.Report, moderatorId uint, status string, resolution string) error {
+	now := time.Now()
+	result := s.db.Model(report).Where("status = ?", model.ReportPending).Updates(map[string]interface{}{
+		"status":         status,
+		"resolution":     resolution,
+		"resolved_by_id": moderatorId,
+		"resolved_at":    now,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrReportClosed
+	}
+
+	report.Status = status
+	report.Resolution = resolution
+	report.ResolvedById = &moderatorId
+	report.ResolvedAt = &now
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/riri95500/go-chat/internal/fakedb"
+	"github.com/riri95500/go-chat/model"
+)
+
+// reportService holds message 7 of user 1 in general, user 3, and pending reports of the reporter
+func reportService(t *testing.T, pending int64) (*ReportService, *fakedb.DB) {
+	t.Helper()
+	db, fake := fakedb.Open(t, func(query string, args []driver.Value) ([]string, [][]driver.Value) {
+		switch {
+		case strings.Contains(query, "count(*)"):
+			return fakedb.Rows([]string{"count(*)"}, pending)
+		case strings.Contains(query, "FROM `messages`"):
+			return fakedb.Rows([]string{"id", "room_id", "user_id", "text"}, int64(7), "general", "1", "spam")
+		case strings.Contains(query, "FROM `users`"):
+			return fakedb.Rows([]string{"id"}, int64(3))
+		}
+		return nil, nil
+	})
+	return NewReportService(db), fake
+}
+
+func TestCreateReportOfAMessageReportsItsAuthor(t *testing.T) {
+	s, fake := reportService(t, 0)
+	messageId := uint(7)
+	report := &model.Report{ReporterId: 2, RoomId: "general", MessageId: &messageId, Reason: "spam"}
+	if err := s.CreateReport(report); err != nil {
+		t.Fatal(err)
+	}
+	if report.UserId != 1 {
+		t.Errorf("reported user %d, want the author 1", report.UserId)
+	}
+	if report.Status != model.ReportPending {
+		t.Errorf("status %q, want %q", report.Status, model.ReportPending)
+	}
+	if !fake.Ran("INSERT INTO `reports`") {
+		t.Error("report not saved")
+	}
+}
+
+func TestCreateReportOfAUser(t *testing.T) {
+	s, fake := reportService(t, 0)
+	report := &model.Report{ReporterId: 2, RoomId: "general", UserId: 3}
+	if err := s.CreateReport(report); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.Ran("message_id IS NULL") {
+		t.Error("pending reports of the user not looked up")
+	}
+	if !fake.Ran("INSERT INTO `reports`") {
+		t.Error("report not saved")
+	}
+}
+
+func TestCreateReportRefusesInvalidReports(t *testing.T) {
+	messageId := uint(7)
+	tests := []struct {
+		name    string
+		report  *model.Report
+		pending int64
+		want    error
+	}{
+		{"no room", &model.Report{ReporterId: 2, UserId: 3}, 0, ErrInvalidReport},
+		{"no message nor user", &model.Report{ReporterId: 2, RoomId: "general"}, 0, ErrInvalidReport},
+		{"own message", &model.Report{ReporterId: 1, RoomId: "general", MessageId: &messageId}, 0, ErrInvalidReport},
+		{"themselves", &model.Report{ReporterId: 3, RoomId: "general", UserId: 3}, 0, ErrInvalidReport},
+		{"message already reported", &model.Report{ReporterId: 2, RoomId: "general", MessageId: &messageId}, 1, ErrAlreadyReported},
+		{"user already reported", &model.Report{ReporterId: 2, RoomId: "general", UserId: 3}, 1, ErrAlreadyReported},
+	}
+	for _, tt := range tests {
+		s, fake := reportService(t, tt.pending)
+		if err := s.CreateReport(tt.report); err != tt.want {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.want)
+		}
+		if fake.Ran("INSERT INTO `reports`") {
+			t.Errorf("%s: report saved", tt.name)
+		}
+	}
+}
+
+func TestGetReportsRefusesUnknownStatuses(t *testing.T) {
+	s, fake := reportService(t, 0)
+	if _, err := s.GetReports("general", "open", 0, 50); err != ErrInvalidStatus {
+		t.Errorf("error %v, want %v", err, ErrInvalidStatus)
+	}
+	if fake.Ran("FROM `reports`") {
+		t.Error("reports queried with an unknown status")
+	}
+
+	if _, err := s.GetReports("general", model.ReportPending, 10, 50); err != nil {
+		t.Fatal(err)
+	}
+	if !fake.Ran("status = ?") || !fake.Ran("id < ?") {
+		t.Error("reports not filtered by status and position")
+	}
+}
+
+func TestCloseReport(t *testing.T) {
+	s, fake := reportService(t, 0)
+	report := &model.Report{RoomId: "general", UserId: 3, Status: model.ReportPending}
+	report.ID = 4
+	if err := s.Close(report, 9, model.ReportResolved, model.ModerationMute); err != nil {
+		t.Fatal(err)
+	}
+	if report.Status != model.ReportResolved || report.Resolution != model.ModerationMute {
+		t.Errorf("report %s/%s, want %s/%s", report.Status, report.Resolution, model.ReportResolved, model.ModerationMute)
+	}
+	if report.ResolvedById == nil || *report.ResolvedById != 9 || report.ResolvedAt == nil {
+		t.Error("moderator or time of the resolution not kept")
+	}
+	if !fake.Ran("UPDATE `reports`") {
+		t.Error("report not updated")
+	}
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riri95500/go-chat/model"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAlreadyReviewed = errors.New("this message was already reviewed")
+	ErrInvalidStatus   = errors.New("invalid status")
+)
+
+type ReviewService struct {
+	db *gorm.DB
+}
+
+func NewReviewService(db *gorm.DB) *ReviewService {
+	return &ReviewService{
+		db: db,
+	}
+}
+
+/*
+FlagMessage queues a saved message flagged by the filters for moderator review.
+It makes ReviewService usable as the FlaggedMessageStore of the manager.
+*/
+func (s *ReviewService) FlagMessage(message *Message, reasons []string) error {
+	messageId, err := strconv.Atoi(message.Id)
+	if err != nil {
+		return ErrInvalidMessageId
+	}
+
+	return s.db.Create(&model.FlaggedMessage{
+		MessageId: uint(messageId),
+		RoomId:    message.RoomId,
+		UserId:    message.UserId,
+		Text:      message.Text,
+		Reasons:   strings.Join(reasons, "; "),
+		Status:    model.ReviewPending,
+	}).Error
+}
+
+/*
+GetFlagged returns the flagged messages of a room, most recent first.
+
+Parameters:
+  - roomid (string): the room
+  - status (string): only the flagged messages with this status, all of them if empty
+  - before (int): only entries with a lower ID are returned, 0 for the most recent ones
+  - limit (int): the maximum number of entries
+
+Returns:
+  - ([]*model.FlaggedMessage): the flagged messages
+  - (error): ErrInvalidStatus, or a database error
+*/
+func (s *ReviewService) GetFlagged(roomid string, status string, before int, limit int) ([]*model.FlaggedMessage, error) {
+	flagged := []*model.FlaggedMessage{}
+	query := s.db.Where("room_id = ?", roomid)
+	if status != "" {
+		if status != model.ReviewPending && status != model.ReviewApproved && status != model.ReviewRemoved {
+			return nil, ErrInvalidStatus
+		}
+		query = query.Where("status = ?", status)
+	}
+	if before > 0 {
+		query = query.Where("id < ?", before)
+	}
+
+	err := query.Order("id DESC").Limit(limit).Find(&flagged).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return flagged, nil
+}
+
+/*
+Review closes a pending flagged message with the decision of a moderator.
+
+Parameters:
+  - roomid (string): the room of the message
+  - id (int): the ID of the flagged message
+  - moderatorId (uint): the ID of the moderator
+  - status (string): model.ReviewApproved or model.ReviewRemoved
+
+Returns:
+  - (*model.FlaggedMessage): the flagged message
+  - (error): gorm.ErrRecordNotFound, ErrAlreadyReviewed, or a database error
+*/
+func (s *ReviewService) Review(roomid string, id int, moderatorId uint, status string) (*model.FlaggedMessage, error) {
+	var flagged model.FlaggedMessage
+	err := s.db.Where("room_id = ?", roomid).First(&flagged, id).Error
+	if err != nil {
+		return nil, err
+	}
+	if flagged.Status != model.ReviewPending {
+		return nil, ErrAlreadyReviewed
+	}
+
+	now := time.Now()
+	flagged.Status = status
+	flagged.ReviewedById = &moderatorId
+	flagged.ReviewedAt = &now
+
+	// Seule une revue peut passer le message de pending à un autre statut
+	result := s.db.Model(&flagged).Where("status = ?", model.ReviewPending).Updates(map[string]interface{}{
+		"status":         flagged.Status,
+		"reviewed_by_id": moderatorId,
+		"reviewed_at":    now,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyReviewed
+	}
+
+	return &flagged, nil
+}
@@ -1,7 +1,27 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kjk/betterguid"
 	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/markdown"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/tracing"
+)
+
+var (
+	ErrRoomExists      = errors.New("room already exists")
+	ErrMessageTooLarge = errors.New("message is too large")
+	ErrBanned          = errors.New("you are banned from this room")
+	ErrMuted           = errors.New("you are muted in this room")
+	ErrSlowMode        = errors.New("slow mode is enabled, wait before sending another message")
+	ErrRoomOverloaded  = errors.New("room is too busy, try again later")
 )
 
 type Manager interface {
@@ -9,35 +29,245 @@ type Manager interface {
 	CloseListener(roomid string, channel chan interface{})
 	Submit(userid, roomid, text string)
 	DeleteBroadcast(roomid string)
+	CreateRoom(roomid string, options RoomOptions) error
+	OpenUserListener(roomid, userid, ip string) chan interface{}
+	Presence(roomid string) []string
+	Typing(userid, roomid string, typing bool)
+	Publish(event *Event)
+	SubmitMessage(ctx context.Context, message *Message) error
+	EditMessage(ctx context.Context, message *Message) error
+	Admit(userid, roomid string) error
+	Moderate(moderation *Moderation)
+	Stats() ManagerStats
+	Ping(ctx context.Context) error
+	Rooms() []RoomInfo
+	Listeners(roomid string) ([]ListenerInfo, error)
+	Disconnect(roomid, listenerId string) error
+	Announce(roomid, text string) error
+	AnnounceAll(text string) int
+	CloseRoom(roomid, reason string) error
+}
+
+/*
+ManagerStats is a snapshot of the manager, read without waiting for its goroutine
+so that it stays available when the manager is overloaded.
+*/
+type ManagerStats struct {
+	// Rooms running, including the user rooms
+	Rooms int
+	// Listeners open in every room
+	Listeners int
+	// Requests waiting in each control channel of the manager
+	Queues map[string]int
 }
 
 type Message struct {
+	// Set by the MessageStore, empty when messages are not stored
+	Id     string
 	UserId string
 	RoomId string
 	Text   string
+	// Id of the message starting the thread this message replies to
+	ParentId string
+	// Id of a message quoted by this message
+	QuoteId string
+	// Set by the manager when it has a MentionResolver
+	Mentions []Mention
+	// Ids of attachments uploaded by the author in the room, linked by the MessageStore
+	AttachmentIds []string
+	// Set by the MessageStore from AttachmentIds
+	Attachments []Attachment
+	// Text parsed as Markdown, set by the manager
+	Content *markdown.Node
+	// Span of the submission, so that the fan-out is traced under it
+	trace tracing.SpanContext
+	// Texts seen by the SentMessageFilters, given to them once the message is sent
+	filtered []filteredText
+}
+
+// MessageStore persists the messages before they are broadcast
+type MessageStore interface {
+	CreateMessage(message *Message) error
+	// UpdateMessage saves the new text and mentions of a message, it returns the users newly mentioned
+	UpdateMessage(message *Message) ([]string, error)
+}
+
+/*
+Moderation is an action of a moderator on a room, applied by the manager to the
+running room. Its persistence is left to the caller, see ModerationService.
+*/
+type Moderation struct {
+	// One of the model.Moderation* actions
+	Action      string
+	RoomId      string
+	ModeratorId string
+	// The user targeted, empty for slow mode
+	UserId string
+	// End of a ban or a mute, zero when it has no end
+	Until time.Time
+	// Interval of the slow mode, 0 to disable it
+	SlowMode time.Duration
+	// The message concerned, for a deleted message
+	MessageId string
+	Reason    string
+	// State of the room loaded off the manager goroutine, when the action had to wait for it
+	state *roomState
+}
+
+/*
+RoomModeration is the moderation state of a room: its slow mode and the
+bans and mutes in force with their end, a zero time when they have no end.
+*/
+type RoomModeration struct {
+	SlowMode time.Duration
+	Bans     map[string]time.Time
+	Mutes    map[string]time.Time
 }
 
 type Listener struct {
+	// Set when the listener is opened, to disconnect it
+	Id     string
 	RoomId string
-	Chan   chan interface{}
+	UserId string
+	// Address of the client, empty when unknown
+	IP          string
+	ConnectedAt time.Time
+	Chan        chan interface{}
+	// State of the room loaded off the manager goroutine, when its registration had to wait for it
+	state *roomState
+	// Receives Chan once it is made by register
+	opened chan chan interface{}
+}
+
+type room struct {
+	broadcaster  broadcast.Broadcaster
+	options      RoomOptions
+	listeners    map[chan interface{}]*Listener
+	lastActivity time.Time
+	// Nombre de listeners ouverts par utilisateur (un par onglet)
+	presence map[string]int
+	typing   map[string]*typingState
+	// Bans and mutes with their end, zero when they have no end
+	bans     map[string]time.Time
+	mutes    map[string]time.Time
+	slowMode time.Duration
+	// Date of the last message of each user, for slow mode
+	lastMessage map[string]time.Time
+	// Messages broadcast since the room started, and over the last minute
+	messageCount int
+	messageRate  rateCounter
+	// Whether the persisted state of the room was loaded, listeners and messages wait for it
+	loaded bool
+	// Created by CreateRoom: its options exist nowhere else, so it is never collected
+	created bool
+}
+
+// roomState is what the loaders know of a persisted room
+type roomState struct {
+	options    RoomOptions
+	moderation RoomModeration
+}
+
+type typingState struct {
+	active  bool
+	expires time.Time
+	sent    time.Time
+}
+
+type typingSignal struct {
+	UserId string
+	RoomId string
+	Typing bool
+}
+
+// admission tells what admitMessage checks
+type admission int
+
+const (
+	// Bans, mutes, size and slow mode, for new messages
+	checkMessage admission = iota
+	// Bans, mutes and size, for edits
+	checkEdit
+	// Bans and mutes only, for the writes that are not messages
+	checkWrite
+)
+
+type submitRequest struct {
+	Message *Message
+	check   admission
+	err     chan error
+	state   *roomState
+}
+
+type presenceRequest struct {
+	RoomId string
+	users  chan []string
+}
+
+type closeRequest struct {
+	RoomId string
+	Reason string
+	// nil when nobody waits for the result
+	err chan error
+}
+
+type roomRequest struct {
+	RoomId  string
+	Options RoomOptions
+	err     chan error
 }
 
 type manager struct {
-	roomChannels map[string]broadcast.Broadcaster
+	roomChannels map[string]*room
+	options      ManagerOptions
 	open         chan *Listener
 	close        chan *Listener
-	delete       chan string
-	messages     chan *Message
+	delete       chan *closeRequest
+	messages     chan *submitRequest
+	admit        chan *submitRequest
+	create       chan *roomRequest
+	presence     chan *presenceRequest
+	signals      chan *typingSignal
+	events       chan *Event
+	moderations  chan *Moderation
+	pings        chan chan struct{}
+	inspect      chan *inspectRequest
+	disconnect   chan *disconnectRequest
+	announce     chan *announceRequest
+	// Tenus à jour par la goroutine du manager pour Stats
+	roomCount     atomic.Int64
+	listenerCount atomic.Int64
 }
 
-// Cette fonction déclenchera register
+/*
+OpenListener opens an anonymous listener on the room. Like OpenUserListener it
+waits for the room to be loaded.
+*/
 func (m *manager) OpenListener(roomid string) chan interface{} {
-	listener := make(chan interface{})
-	m.open <- &Listener{
+	return m.OpenUserListener(roomid, "", "")
+}
+
+/*
+OpenUserListener opens a listener on behalf of an authenticated user, so that the
+user is counted in the room presence. Several listeners of the same user count once.
+An empty userid opens an anonymous listener, ip is the address of the client shown
+to the administrators.
+
+It returns once the listener is registered, with a channel sized from the options of
+the room: it holds the history replayed on registration and a room buffer of live events,
+so that a reader which has not started yet or falls a little behind loses nothing with
+the Drop policy. The channel is already closed when the room refused the listener.
+*/
+func (m *manager) OpenUserListener(roomid, userid, ip string) chan interface{} {
+	listener := &Listener{
+		Id:     betterguid.New(),
 		RoomId: roomid,
-		Chan:   listener,
+		UserId: userid,
+		IP:     ip,
+		opened: make(chan chan interface{}, 1),
 	}
-	return listener
+	m.open <- listener
+	return <-listener.opened
 }
 
 // Cette fonction déclenchera deregister
@@ -48,9 +278,11 @@ func (m *manager) CloseListener(roomid string, channel chan interface{}) {
 	}
 }
 
-// Cette fonction déclenchera deleteBroadcast
+// Cette fonction déclenchera deleteBroadcast, l'appelant audite la suppression avec son auteur
 func (m *manager) DeleteBroadcast(roomid string) {
-	m.delete <- roomid
+	m.delete <- &closeRequest{
+		RoomId: roomid,
+	}
 }
 
 // Cette fonction déclenchera broadcast.Submit
@@ -60,39 +292,784 @@ func (m *manager) Submit(userid, roomid, text string) {
 		RoomId: roomid,
 		Text:   text,
 	}
-	m.messages <- msg
+	if err := m.SubmitMessage(context.Background(), msg); err != nil {
+		m.options.Logger.Warn("message rejected", "room", roomid, "user", userid, "error", err)
+	}
+}
+
+/*
+SubmitMessage checks the message against the limits and the moderation of its room,
+runs the filters, resolves its mentions, saves it in the MessageStore if there is one,
+then broadcasts it to the room and notifies the mentioned users in their user room.
+The messages flagged by the filters are queued for review once saved.
+
+Parameters:
+  - ctx (context.Context): the context of the request, the submission and the fan-out are traced under its span
+  - message (*Message): the message to send, its Id is set once saved and its Text may be rewritten by the filters
+
+Returns:
+  - (error): ErrMessageTooLarge, ErrBanned, ErrMuted, ErrSlowMode, an error wrapping
+    ErrMessageRejected, the error of the MessageStore, or ErrRoomOverloaded when the buffer
+    of the room is full: the message is then saved but not broadcast
+*/
+func (m *manager) SubmitMessage(ctx context.Context, message *Message) error {
+	ctx, span := tracing.Child(ctx, "manager.SubmitMessage", "room.id", message.RoomId)
+	defer span.End()
+	message.trace = span.SpanContext()
+
+	req := &submitRequest{
+		Message: message,
+		err:     make(chan error, 1),
+	}
+	_, admit := tracing.Child(ctx, "manager.admit")
+	m.admit <- req
+	err := <-req.err
+	admit.RecordError(err)
+	admit.End()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	// Le filtrage et l'enregistrement se font hors de la goroutine du manager pour ne pas la bloquer
+	_, filter := tracing.Child(ctx, "manager.filter", "filters", len(m.options.Filters))
+	flags, err := filterMessage(m.options.Filters, message, false)
+	filter.RecordError(err)
+	filter.End()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	message.Content = markdown.Parse(message.Text)
+	if m.options.MentionResolver != nil {
+		mentions, err := resolveMentions(m.options.MentionResolver, message.Text)
+		if err != nil {
+			return err
+		}
+		message.Mentions = mentions
+	}
+
+	if m.options.MessageStore != nil {
+		_, store := tracing.Child(ctx, "MessageStore.CreateMessage")
+		err := m.options.MessageStore.CreateMessage(message)
+		store.RecordError(err)
+		store.End()
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+		span.SetAttributes("message.id", message.Id)
+	}
+
+	broadcast := &submitRequest{
+		Message: message,
+		err:     make(chan error, 1),
+	}
+	m.messages <- broadcast
+	if err := <-broadcast.err; err != nil {
+		span.RecordError(err)
+		return err
+	}
+	sentMessage(message)
+
+	if len(flags) > 0 && m.options.FlaggedMessageStore != nil && message.Id != "" {
+		if err := m.options.FlaggedMessageStore.FlagMessage(message, flags); err != nil {
+			m.options.Logger.Error("flagged message not queued", "room", message.RoomId, "message", message.Id, "error", err)
+		}
+	}
+
+	if m.options.LinkUnfurler != nil && message.Id != "" {
+		m.options.LinkUnfurler.Unfurl(message)
+	}
+
+	notified := map[string]bool{message.UserId: true}
+	for _, mention := range message.Mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		notified[mention.UserId] = true
+		m.events <- NewEvent(EventMention, UserRoomId(mention.UserId), message.UserId, MentionPayload{
+			RoomId:    message.RoomId,
+			MessageId: message.Id,
+		})
+	}
+
+	return nil
+}
+
+/*
+EditMessage checks the new text of a message as SubmitMessage checks a new message,
+slow mode aside: the bans, mutes and size limit of its room, then the filters, which
+may rewrite or reject it. Its mentions are resolved again, then the MessageStore saves
+the edit. The users newly mentioned are notified, and a flagged edit is queued for review.
+Broadcasting the message.edited event is left to the caller.
+
+Parameters:
+  - ctx (context.Context): the context of the request
+  - message (*Message): the message with its Id, room, author and new text, which the filters may rewrite
+
+Returns:
+  - (error): ErrMessageTooLarge, ErrBanned, ErrMuted, an error wrapping ErrMessageRejected,
+    or the error of the MessageStore such as ErrNotAuthor
+*/
+func (m *manager) EditMessage(ctx context.Context, message *Message) error {
+	ctx, span := tracing.Child(ctx, "manager.EditMessage", "room.id", message.RoomId, "message.id", message.Id)
+	defer span.End()
+
+	req := &submitRequest{
+		Message: message,
+		check:   checkEdit,
+		err:     make(chan error, 1),
+	}
+	m.admit <- req
+	if err := <-req.err; err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	_, filter := tracing.Child(ctx, "manager.filter", "filters", len(m.options.Filters))
+	flags, err := filterMessage(m.options.Filters, message, true)
+	filter.RecordError(err)
+	filter.End()
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+
+	message.Content = markdown.Parse(message.Text)
+	message.Mentions = nil
+	if m.options.MentionResolver != nil {
+		mentions, err := resolveMentions(m.options.MentionResolver, message.Text)
+		if err != nil {
+			return err
+		}
+		message.Mentions = mentions
+	}
+
+	// Sans MessageStore, toute mention est nouvelle
+	mentioned := []string{}
+	for _, mention := range message.Mentions {
+		mentioned = append(mentioned, mention.UserId)
+	}
+	if m.options.MessageStore != nil {
+		_, store := tracing.Child(ctx, "MessageStore.UpdateMessage")
+		mentioned, err = m.options.MessageStore.UpdateMessage(message)
+		store.RecordError(err)
+		store.End()
+		if err != nil {
+			span.RecordError(err)
+			return err
+		}
+	}
+
+	if len(flags) > 0 && m.options.FlaggedMessageStore != nil {
+		if err := m.options.FlaggedMessageStore.FlagMessage(message, flags); err != nil {
+			m.options.Logger.Error("flagged edit not queued", "room", message.RoomId, "message", message.Id, "error", err)
+		}
+	}
+
+	notified := map[string]bool{message.UserId: true}
+	for _, userid := range mentioned {
+		if notified[userid] {
+			continue
+		}
+		notified[userid] = true
+		m.events <- NewEvent(EventMention, UserRoomId(userid), message.UserId, MentionPayload{
+			RoomId:    message.RoomId,
+			MessageId: message.Id,
+		})
+	}
+
+	return nil
+}
+
+/*
+Admit checks that the user can write in the room, for the writes other than messages
+such as reactions and uploads. It loads the room if needed.
+
+Parameters:
+  - userid (string): the user
+  - roomid (string): the room
+
+Returns:
+  - (error): ErrBanned or ErrMuted
+*/
+func (m *manager) Admit(userid, roomid string) error {
+	req := &submitRequest{
+		Message: &Message{
+			UserId: userid,
+			RoomId: roomid,
+		},
+		check: checkWrite,
+		err:   make(chan error, 1),
+	}
+	m.admit <- req
+	return <-req.err
+}
+
+/*
+CreateRoom creates the room roomid with its own options instead of the global ones.
+It fails with ErrRoomExists if the room is already running. The room stays until it
+is deleted, idle or not.
+*/
+func (m *manager) CreateRoom(roomid string, options RoomOptions) error {
+	req := &roomRequest{
+		RoomId:  roomid,
+		Options: options,
+		err:     make(chan error, 1),
+	}
+	m.create <- req
+	return <-req.err
+}
+
+/*
+Presence returns the ids of the users having at least one listener open in the room
+*/
+func (m *manager) Presence(roomid string) []string {
+	req := &presenceRequest{
+		RoomId: roomid,
+		users:  make(chan []string, 1),
+	}
+	m.presence <- req
+	return <-req.users
+}
+
+/*
+Typing signals that the user started or stopped typing in the room.
+The signal is never stored, it is broadcast to the room as an EventTyping event.
+A user who stops sending signals stops typing after TypingTTL. The signals of
+banned and muted users, and the ones for rooms not running, are ignored.
+*/
+func (m *manager) Typing(userid, roomid string, typing bool) {
+	m.signals <- &typingSignal{
+		UserId: userid,
+		RoomId: roomid,
+		Typing: typing,
+	}
+}
+
+/*
+Publish sends an event as is to the listeners of its room, creating the room if needed
+*/
+func (m *manager) Publish(event *Event) {
+	m.events <- event
+}
+
+/*
+Moderate applies a moderation action to the room and announces it with a moderation event.
+Kicked and banned users have their listeners closed, banned users cannot open new
+listeners nor send messages, muted users cannot send messages.
+Every action is audited, the ones on reports and messages are only audited.
+*/
+func (m *manager) Moderate(moderation *Moderation) {
+	metadata := model.AuditMetadata{
+		"room": moderation.RoomId,
+	}
+	target := "room:" + moderation.RoomId
+	if moderation.UserId != "" {
+		target = "user:" + moderation.UserId
+	}
+	if moderation.MessageId != "" {
+		metadata["message"] = moderation.MessageId
+	}
+	if !moderation.Until.IsZero() {
+		metadata["until"] = moderation.Until
+	}
+	if moderation.Action == model.ModerationSlowMode {
+		metadata["slowMode"] = int(moderation.SlowMode / time.Second)
+	}
+	if moderation.Reason != "" {
+		metadata["reason"] = moderation.Reason
+	}
+	m.audit(&model.AuditEvent{
+		ActorId:  auditActor(moderation.ModeratorId),
+		Action:   model.AuditModerationPrefix + moderation.Action,
+		Target:   target,
+		Metadata: metadata,
+	})
+
+	if moderation.Action == model.ModerationDeleteMessage || moderation.Action == model.ModerationDismissReport {
+		return
+	}
+	m.moderations <- moderation
+}
+
+// audit hands the event to the AuditLogger, on the goroutine of the caller
+func (m *manager) audit(event *model.AuditEvent) {
+	if m.options.AuditLogger != nil {
+		m.options.AuditLogger.Audit(event)
+	}
+}
+
+func (m *manager) Stats() ManagerStats {
+	return ManagerStats{
+		Rooms:     int(m.roomCount.Load()),
+		Listeners: int(m.listenerCount.Load()),
+		Queues: map[string]int{
+			"open":        len(m.open),
+			"close":       len(m.close),
+			"delete":      len(m.delete),
+			"messages":    len(m.messages),
+			"admit":       len(m.admit),
+			"create":      len(m.create),
+			"presence":    len(m.presence),
+			"signals":     len(m.signals),
+			"events":      len(m.events),
+			"moderations": len(m.moderations),
+			"inspect":     len(m.inspect),
+			"disconnect":  len(m.disconnect),
+			"announce":    len(m.announce),
+		},
+	}
+}
+
+/*
+Ping waits for the manager goroutine to answer, it fails when the goroutine
+is stuck or too busy to answer before the context is done.
+*/
+func (m *manager) Ping(ctx context.Context) error {
+	done := make(chan struct{})
+	select {
+	case m.pings <- done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 func (m *manager) register(listener *Listener) {
-	m.room(listener.RoomId).Register(listener.Chan)
+	r, ok := m.loadedRoom(listener.RoomId, listener.state)
+	if !ok {
+		m.loadState(listener.RoomId, func(state *roomState) {
+			listener.state = state
+			m.open <- listener
+		})
+		return
+	}
+	// La channel dépend des options de la room : l'historique doit y tenir sans bloquer le broadcaster
+	listener.Chan = make(chan interface{}, r.options.BufferSize+r.options.HistoryDepth)
+	// L'appelant ne reçoit la channel qu'une fois le listener compté, ou refusé
+	defer func() { listener.opened <- listener.Chan }()
+	// La room est pleine, ou l'utilisateur est banni : on ferme la channel pour que le listener s'arrête
+	if r.options.MaxSubscribers > 0 && len(r.listeners) >= r.options.MaxSubscribers {
+		close(listener.Chan)
+		return
+	}
+	if listener.UserId != "" && restricted(r.bans, listener.UserId, time.Now()) {
+		close(listener.Chan)
+		return
+	}
+	listener.ConnectedAt = time.Now()
+	r.listeners[listener.Chan] = listener
+	m.listenerCount.Add(1)
+	r.lastActivity = time.Now()
+	r.broadcaster.Register(listener.Chan)
+
+	if listener.UserId == "" {
+		return
+	}
+	r.presence[listener.UserId]++
+	if r.presence[listener.UserId] == 1 {
+		r.broadcaster.Submit(NewEvent(EventJoined, listener.RoomId, listener.UserId, nil))
+	}
 }
 
 func (m *manager) deregister(listener *Listener) {
-	m.room(listener.RoomId).Unregister(listener.Chan)
+	r, ok := m.roomChannels[listener.RoomId]
+	if !ok {
+		return
+	}
+	registered, ok := r.listeners[listener.Chan]
+	if !ok {
+		return
+	}
+	delete(r.listeners, listener.Chan)
+	m.listenerCount.Add(-1)
+	r.lastActivity = time.Now()
+	r.broadcaster.Unregister(listener.Chan)
 	close(listener.Chan)
+
+	// On reprend le listener enregistré, celui reçu ne connait pas l'utilisateur
+	userid := registered.UserId
+	if userid == "" {
+		return
+	}
+	r.presence[userid]--
+	if r.presence[userid] <= 0 {
+		delete(r.presence, userid)
+		m.stopTyping(r, listener.RoomId, userid)
+		r.broadcaster.Submit(NewEvent(EventLeft, listener.RoomId, userid, nil))
+	}
 }
 
-func (m *manager) deleteBroadcast(roomid string) {
-	b, ok := m.roomChannels[roomid]
+func (m *manager) deleteBroadcast(req *closeRequest) {
+	r, ok := m.roomChannels[req.RoomId]
 	if ok {
-		b.Close()
-		delete(m.roomChannels, roomid)
+		// Les listeners s'arrêtent en recevant room.closed, ou à la fermeture de leur channel
+		// par le broadcaster quand room.closed est perdu faute de place
+		r.broadcaster.Submit(NewEvent(EventRoomClosed, req.RoomId, "", RoomClosedPayload{
+			Reason: req.Reason,
+		}))
+		r.broadcaster.Close()
+		delete(m.roomChannels, req.RoomId)
+		m.roomCount.Add(-1)
+		m.listenerCount.Add(-int64(len(r.listeners)))
+	}
+	if req.err == nil {
+		return
+	}
+	if !ok {
+		req.err <- ErrRoomNotRunning
+		return
+	}
+	req.err <- nil
+}
+
+func (m *manager) createRoom(req *roomRequest) {
+	if _, ok := m.roomChannels[req.RoomId]; ok {
+		req.err <- ErrRoomExists
+		return
+	}
+	// Les options sont données par l'appelant, rien n'est chargé
+	r := newRoom(req.Options)
+	r.loaded = true
+	r.created = true
+	m.roomChannels[req.RoomId] = r
+	m.roomCount.Add(1)
+	req.err <- nil
+}
+
+func (m *manager) listPresence(req *presenceRequest) {
+	users := []string{}
+	if r, ok := m.roomChannels[req.RoomId]; ok {
+		for userid := range r.presence {
+			users = append(users, userid)
+		}
+	}
+	req.users <- users
+}
+
+// admitMessage checks a message against the limits of its room before it is saved
+func (m *manager) admitMessage(req *submitRequest) {
+	r, ok := m.loadedRoom(req.Message.RoomId, req.state)
+	if !ok {
+		m.loadState(req.Message.RoomId, func(state *roomState) {
+			req.state = state
+			m.admit <- req
+		})
+		return
+	}
+	userid := req.Message.UserId
+	now := time.Now()
+	switch {
+	case restricted(r.bans, userid, now):
+		req.err <- ErrBanned
+		return
+	case restricted(r.mutes, userid, now):
+		req.err <- ErrMuted
+		return
+	case req.check == checkWrite:
+		req.err <- nil
+		return
+	case r.options.MaxMessageSize > 0 && len(req.Message.Text) > r.options.MaxMessageSize:
+		req.err <- ErrMessageTooLarge
+		return
+	case req.check == checkEdit:
+		req.err <- nil
+		return
+	}
+
+	// Le créneau n'est pris qu'une fois le message accepté, dans submit
+	if last, ok := r.lastMessage[userid]; ok && r.slowMode > 0 && now.Sub(last) < r.slowMode {
+		req.err <- ErrSlowMode
+		return
+	}
+	req.err <- nil
+}
+
+// restricted tells if the user has a ban or a mute in force, forgetting the expired ones
+func restricted(restrictions map[string]time.Time, userid string, now time.Time) bool {
+	until, ok := restrictions[userid]
+	if !ok {
+		return false
+	}
+	if !until.IsZero() && !now.Before(until) {
+		delete(restrictions, userid)
+		return false
+	}
+	return true
+}
+
+func (m *manager) moderate(moderation *Moderation) {
+	// Appliquée avant le chargement, l'action serait écrasée par l'état persisté
+	r, ok := m.loadedRoom(moderation.RoomId, moderation.state)
+	if !ok {
+		m.loadState(moderation.RoomId, func(state *roomState) {
+			moderation.state = state
+			m.moderations <- moderation
+		})
+		return
+	}
+	r.lastActivity = time.Now()
+
+	switch moderation.Action {
+	case model.ModerationBan:
+		r.bans[moderation.UserId] = moderation.Until
+	case model.ModerationUnban:
+		delete(r.bans, moderation.UserId)
+	case model.ModerationMute:
+		r.mutes[moderation.UserId] = moderation.Until
+	case model.ModerationUnmute:
+		delete(r.mutes, moderation.UserId)
+	case model.ModerationSlowMode:
+		r.slowMode = moderation.SlowMode
+		r.lastMessage = make(map[string]time.Time)
+	}
+
+	payload := ModerationPayload{
+		RoomId:   moderation.RoomId,
+		Action:   moderation.Action,
+		UserId:   moderation.UserId,
+		SlowMode: int(moderation.SlowMode / time.Second),
+		Reason:   moderation.Reason,
+	}
+	if !moderation.Until.IsZero() {
+		payload.Until = &moderation.Until
+	}
+	r.broadcaster.Submit(NewEvent(EventModeration, moderation.RoomId, moderation.ModeratorId, payload))
+
+	if moderation.Action == model.ModerationKick || moderation.Action == model.ModerationBan {
+		// L'utilisateur ne recevra plus les évènements de la room, il est prévenu dans sa room utilisateur
+		m.room(UserRoomId(moderation.UserId)).broadcaster.Submit(NewEvent(EventModeration, UserRoomId(moderation.UserId), moderation.ModeratorId, payload))
+		m.kick(r, moderation.UserId)
+	}
+}
+
+// kick closes every listener of the user in the room
+func (m *manager) kick(r *room, userid string) {
+	for _, listener := range r.listeners {
+		if listener.UserId == userid {
+			m.deregister(listener)
+		}
+	}
+}
+
+func (m *manager) submit(req *submitRequest) {
+	message := req.Message
+	r := m.room(message.RoomId)
+	m.stopTyping(r, message.RoomId, message.UserId)
+
+	event := NewEvent(EventMessage, message.RoomId, message.UserId, MessagePayload{
+		Text:        message.Text,
+		ParentId:    message.ParentId,
+		QuoteId:     message.QuoteId,
+		Mentions:    message.Mentions,
+		Attachments: message.Attachments,
+		Content:     message.Content,
+		Plain:       markdown.PlainText(message.Content),
+	})
+	if message.Id != "" {
+		event.Id = message.Id
+	}
+	event.trace = message.trace
+	if !r.broadcaster.Submit(event) {
+		req.err <- ErrRoomOverloaded
+		return
+	}
+
+	r.lastActivity = time.Now()
+	r.messageCount++
+	r.messageRate.add(r.lastActivity)
+	if r.slowMode > 0 {
+		r.lastMessage[message.UserId] = r.lastActivity
+	}
+	req.err <- nil
+}
+
+func (m *manager) publish(event *Event) {
+	r := m.room(event.RoomId)
+	r.lastActivity = time.Now()
+	r.broadcaster.Submit(event)
+}
+
+/*
+collect closes and forgets the rooms that have no listeners and
+no activity since IdleTTL. They are recreated on their next use.
+The rooms made by CreateRoom are kept, their options would be lost.
+*/
+func (m *manager) collect(now time.Time) {
+	for roomid, r := range m.roomChannels {
+		if !r.created && len(r.listeners) == 0 && now.Sub(r.lastActivity) > m.options.IdleTTL {
+			r.broadcaster.Close()
+			delete(m.roomChannels, roomid)
+			m.roomCount.Add(-1)
+			continue
+		}
+		for userid, last := range r.lastMessage {
+			if now.Sub(last) >= r.slowMode {
+				delete(r.lastMessage, userid)
+			}
+		}
+	}
+}
+
+func (m *manager) signal(event *typingSignal) {
+	r, ok := m.roomChannels[event.RoomId]
+	if !ok || !r.loaded {
+		return
+	}
+	now := time.Now()
+	if restricted(r.bans, event.UserId, now) || restricted(r.mutes, event.UserId, now) {
+		return
+	}
+	if !event.Typing {
+		m.stopTyping(r, event.RoomId, event.UserId)
+		return
+	}
+
+	state, ok := r.typing[event.UserId]
+	if !ok {
+		state = &typingState{}
+		r.typing[event.UserId] = state
+	}
+	state.expires = now.Add(m.options.TypingTTL)
+	// Déjà signalé, ou signalé trop récemment : inutile de rediffuser
+	if state.active || now.Sub(state.sent) < m.options.TypingThrottle {
+		return
+	}
+	state.active = true
+	state.sent = now
+	r.broadcaster.Submit(NewEvent(EventTyping, event.RoomId, event.UserId, TypingPayload{
+		Typing: true,
+	}))
+}
+
+func (m *manager) stopTyping(r *room, roomid, userid string) {
+	state, ok := r.typing[userid]
+	if !ok || !state.active {
+		return
+	}
+	state.active = false
+	r.broadcaster.Submit(NewEvent(EventTyping, roomid, userid, TypingPayload{
+		Typing: false,
+	}))
+}
+
+// expireTyping stops the typing indicators that were not refreshed in time
+func (m *manager) expireTyping(now time.Time) {
+	for roomid, r := range m.roomChannels {
+		for userid, state := range r.typing {
+			if state.active && now.After(state.expires) {
+				m.stopTyping(r, roomid, userid)
+			}
+			if !state.active && now.Sub(state.sent) >= m.options.TypingThrottle {
+				delete(r.typing, userid)
+			}
+		}
+	}
+}
+
+func newRoom(options RoomOptions) *room {
+	return &room{
+		broadcaster:  broadcast.NewBroadcasterWithOptions(options.broadcasterOptions()),
+		options:      options,
+		listeners:    make(map[chan interface{}]*Listener),
+		lastActivity: time.Now(),
+		presence:     make(map[string]int),
+		typing:       make(map[string]*typingState),
+		bans:         make(map[string]time.Time),
+		mutes:        make(map[string]time.Time),
+		lastMessage:  make(map[string]time.Time),
 	}
 }
 
 /*
-Get the room with the id roomid, or creates and registers it
+Get the room with the id roomid, or creates and registers it with the global options.
+Its persisted state is not loaded, see loadedRoom.
 */
-func (m *manager) room(roomid string) broadcast.Broadcaster {
-	b, ok := m.roomChannels[roomid]
+func (m *manager) room(roomid string) *room {
+	r, ok := m.roomChannels[roomid]
 	if !ok {
-		b = broadcast.NewBroadcaster(10)
-		m.roomChannels[roomid] = b
+		r = newRoom(m.options.Room)
+		r.loaded = (m.options.RoomLoader == nil && m.options.ModerationLoader == nil) || strings.HasPrefix(roomid, UserRoomPrefix)
+		m.roomChannels[roomid] = r
+		m.roomCount.Add(1)
+	}
+	return r
+}
+
+/*
+loadedRoom gets the room once its persisted state is loaded, applying state if it is not yet.
+The loaders query the database, so they never run on the manager goroutine: without a state
+to apply, loadedRoom returns false and the request waits for loadState.
+*/
+func (m *manager) loadedRoom(roomid string, state *roomState) (*room, bool) {
+	r := m.room(roomid)
+	if r.loaded {
+		return r, true
+	}
+	if state == nil {
+		return nil, false
+	}
+
+	r.options = state.options
+	// Une room pas encore chargée n'a aucun listener, son broadcaster est refait avec ses options
+	r.broadcaster.Close()
+	r.broadcaster = broadcast.NewBroadcasterWithOptions(state.options.broadcasterOptions())
+	r.slowMode = state.moderation.SlowMode
+	for userid, until := range state.moderation.Bans {
+		r.bans[userid] = until
+	}
+	for userid, until := range state.moderation.Mutes {
+		r.mutes[userid] = until
 	}
-	return b
+	r.loaded = true
+	return r, true
+}
+
+/*
+loadState runs the loaders on a goroutine of its own, then gives the state to resend,
+which sends the waiting request to the manager again.
+*/
+func (m *manager) loadState(roomid string, resend func(*roomState)) {
+	go func() {
+		state := &roomState{
+			options: m.options.Room,
+		}
+		if m.options.RoomLoader != nil {
+			if options, ok := m.options.RoomLoader(roomid); ok {
+				state.options = options
+			}
+		}
+		if m.options.ModerationLoader != nil {
+			state.moderation = m.options.ModerationLoader(roomid)
+		}
+		resend(state)
+	}()
 }
 
 func (m *manager) run() {
+	var gc <-chan time.Time
+	if m.options.IdleTTL > 0 && m.options.GCInterval > 0 {
+		ticker := time.NewTicker(m.options.GCInterval)
+		defer ticker.Stop()
+		gc = ticker.C
+	}
+
+	var typing <-chan time.Time
+	if m.options.TypingTTL > 0 {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		typing = ticker.C
+	}
+
 	for {
 		select {
 		//Cette fonction sera déclenché à l'appel de OpenListener
@@ -102,29 +1079,90 @@ func (m *manager) run() {
 		case listener := <-m.close:
 			m.deregister(listener)
 		//Cette fonction sera déclenché à l'appel de DeleteBroadcast
-		case roomid := <-m.delete:
-			m.deleteBroadcast(roomid)
+		case req := <-m.delete:
+			m.deleteBroadcast(req)
 		//Cette fonction sera déclenché à l'appel de Submit
-		case message := <-m.messages:
-			m.room(message.RoomId).Submit(*message)
+		case req := <-m.messages:
+			m.submit(req)
+		case req := <-m.admit:
+			m.admitMessage(req)
+		//Cette fonction sera déclenché à l'appel de CreateRoom
+		case req := <-m.create:
+			m.createRoom(req)
+		//Cette fonction sera déclenché à l'appel de Presence
+		case req := <-m.presence:
+			m.listPresence(req)
+		//Cette fonction sera déclenché à l'appel de Typing
+		case event := <-m.signals:
+			m.signal(event)
+		//Cette fonction sera déclenché à l'appel de Publish
+		case event := <-m.events:
+			m.publish(event)
+		//Cette fonction sera déclenché à l'appel de Moderate
+		case moderation := <-m.moderations:
+			m.moderate(moderation)
+		//Les rooms inactives sont nettoyées à chaque tick
+		case now := <-gc:
+			m.collect(now)
+		case now := <-typing:
+			m.expireTyping(now)
+		//Cette fonction sera déclenché à l'appel de Ping
+		case done := <-m.pings:
+			close(done)
+		//Cette fonction sera déclenché à l'appel de Rooms et Listeners
+		case req := <-m.inspect:
+			m.inspectRooms(req)
+		//Cette fonction sera déclenché à l'appel de Disconnect
+		case req := <-m.disconnect:
+			m.disconnectListener(req)
+		//Cette fonction sera déclenché à l'appel de Announce et AnnounceAll
+		case req := <-m.announce:
+			m.announceSystem(req)
 		}
 	}
 }
 
 var managerSingleton *manager
 
-func GetRoomManager() Manager {
+/*
+InitRoomManager creates the room manager with the given options and starts it.
+If the manager is already running, it is returned unchanged.
+*/
+func InitRoomManager(options ManagerOptions) Manager {
 	if managerSingleton == nil {
-		managerSingleton = &manager{
-			roomChannels: make(map[string]broadcast.Broadcaster),
-			open:         make(chan *Listener, 100),
-			close:        make(chan *Listener, 100),
-			delete:       make(chan string, 100),
-			messages:     make(chan *Message, 100),
-		}
-
+		managerSingleton = newManager(options)
 		go managerSingleton.run()
 	}
 
 	return managerSingleton
 }
+
+// newManager creates a manager without starting its goroutine
+func newManager(options ManagerOptions) *manager {
+	if options.Logger == nil {
+		options.Logger = slog.Default()
+	}
+
+	return &manager{
+		roomChannels: make(map[string]*room),
+		options:      options,
+		open:         make(chan *Listener, options.ControlBufferSize),
+		close:        make(chan *Listener, options.ControlBufferSize),
+		delete:       make(chan *closeRequest, options.ControlBufferSize),
+		messages:     make(chan *submitRequest, options.ControlBufferSize),
+		admit:        make(chan *submitRequest, options.ControlBufferSize),
+		create:       make(chan *roomRequest, options.ControlBufferSize),
+		presence:     make(chan *presenceRequest, options.ControlBufferSize),
+		signals:      make(chan *typingSignal, options.ControlBufferSize),
+		events:       make(chan *Event, options.ControlBufferSize),
+		moderations:  make(chan *Moderation, options.ControlBufferSize),
+		pings:        make(chan chan struct{}),
+		inspect:      make(chan *inspectRequest, options.ControlBufferSize),
+		disconnect:   make(chan *disconnectRequest, options.ControlBufferSize),
+		announce:     make(chan *announceRequest, options.ControlBufferSize),
+	}
+}
+
+func GetRoomManager() Manager {
+	return InitRoomManager(DefaultManagerOptions())
+}
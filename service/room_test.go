@@ -0,0 +1,562 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/broadcast"
+	"github.com/riri95500/go-chat/model"
+)
+
+// startManager runs a manager of its own, the singleton is left alone
+func startManager(t *testing.T, options ManagerOptions) *manager {
+	t.Helper()
+	m := newManager(options)
+	go m.run()
+	return m
+}
+
+// waitListeners waits for the manager goroutine to register the listeners opened so far
+func waitListeners(t *testing.T, m *manager, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.Stats().Listeners != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d listeners registered, want %d", m.Stats().Listeners, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// nextEvent reads the next event of a listener, failing the test after a second
+func nextEvent(t *testing.T, listener chan interface{}) *Event {
+	t.Helper()
+	for {
+		select {
+		case m, ok := <-listener:
+			if !ok {
+				t.Fatal("listener closed")
+			}
+			if event, ok := m.(*Event); ok {
+				return event
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+	}
+}
+
+// noEvent checks that the listener receives nothing for a short while
+func noEvent(t *testing.T, listener chan interface{}) {
+	t.Helper()
+	select {
+	case m, ok := <-listener:
+		if ok {
+			t.Fatalf("unexpected event %+v", m)
+		}
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func messageText(t *testing.T, event *Event) string {
+	t.Helper()
+	payload, ok := event.Payload.(MessagePayload)
+	if !ok {
+		t.Fatalf("event %s is not a message", event.Type)
+	}
+	return payload.Text
+}
+
+func TestHistoryReplayedWithDropPolicy(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.HistoryDepth = 2
+	options.Room.SlowConsumerPolicy = broadcast.Drop
+	m := startManager(t, options)
+
+	first := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	for _, text := range []string{"one", "two", "three"} {
+		m.Submit("1", "general", text)
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		if got := messageText(t, nextEvent(t, first)); got != want {
+			t.Fatalf("live message %q, want %q", got, want)
+		}
+	}
+
+	// Le lecteur ne commence à lire qu'après l'envoi de l'historique
+	late := m.OpenListener("general")
+	time.Sleep(20 * time.Millisecond)
+	for _, want := range []string{"two", "three"} {
+		if got := messageText(t, nextEvent(t, late)); got != want {
+			t.Errorf("replayed %q, want %q", got, want)
+		}
+	}
+	noEvent(t, late)
+}
+
+func TestDropPolicyKeepsListenerBuffer(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.BufferSize = 5
+	options.Room.SlowConsumerPolicy = broadcast.Drop
+	m := startManager(t, options)
+
+	// Le listener ne lit pas pendant l'envoi, son buffer garde les messages
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	for _, text := range []string{"one", "two", "three"} {
+		m.Submit("1", "general", text)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for _, want := range []string{"one", "two", "three"} {
+		if got := messageText(t, nextEvent(t, listener)); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestHistoryFollowsEditsAndDeletions(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.HistoryDepth = 5
+	m := startManager(t, options)
+
+	first := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	for _, text := range []string{"one", "two", "three"} {
+		m.Submit("1", "general", text)
+	}
+	ids := []string{}
+	for i := 0; i < 3; i++ {
+		ids = append(ids, nextEvent(t, first).Id)
+	}
+	m.Publish(NewEvent(EventMessageEdited, "general", "1", MessageEditedPayload{MessageId: ids[0], Text: "one, edited"}))
+	m.Publish(NewEvent(EventMessageDeleted, "general", "1", MessageDeletedPayload{MessageId: ids[1]}))
+	nextEvent(t, first)
+	nextEvent(t, first)
+
+	late := m.OpenListener("general")
+	for i, want := range []string{"one, edited", "three"} {
+		event := nextEvent(t, late)
+		if got := messageText(t, event); got != want {
+			t.Errorf("replayed %q, want %q", got, want)
+		}
+		if want := ids[i*2]; event.Id != want {
+			t.Errorf("replayed message %s, want %s", event.Id, want)
+		}
+	}
+	noEvent(t, late)
+}
+
+// waitRooms waits for the manager to run the given number of rooms
+func waitRooms(t *testing.T, m *manager, count int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for m.Stats().Rooms != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d rooms running, want %d", m.Stats().Rooms, count)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestIdleRoomsCollected(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.IdleTTL = 20 * time.Millisecond
+	options.GCInterval = 5 * time.Millisecond
+	m := startManager(t, options)
+
+	listener := m.OpenListener("busy")
+	waitListeners(t, m, 1)
+	m.Publish(NewEvent(EventSystem, "idle", "", SystemPayload{Text: "hello"}))
+	waitRooms(t, m, 2)
+
+	// La room sans listener est collectée, celle qui en a un reste
+	waitRooms(t, m, 1)
+	time.Sleep(50 * time.Millisecond)
+	waitRooms(t, m, 1)
+
+	m.CloseListener("busy", listener)
+	waitRooms(t, m, 0)
+}
+
+func TestCreatedRoomsNotCollected(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.IdleTTL = 20 * time.Millisecond
+	options.GCInterval = 5 * time.Millisecond
+	m := startManager(t, options)
+
+	if err := m.CreateRoom("created", RoomOptions{BufferSize: 4, MaxMessageSize: 5}); err != nil {
+		t.Fatal(err)
+	}
+	m.Publish(NewEvent(EventSystem, "idle", "", SystemPayload{Text: "hello"}))
+	waitRooms(t, m, 2)
+
+	// Seule la room créée à la demande est collectée
+	waitRooms(t, m, 1)
+	time.Sleep(50 * time.Millisecond)
+	waitRooms(t, m, 1)
+	err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "created", Text: "too long"})
+	if err != ErrMessageTooLarge {
+		t.Errorf("SubmitMessage in the idle created room = %v, want its own ErrMessageTooLarge", err)
+	}
+}
+
+func TestIdleTTLZeroKeepsRooms(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.IdleTTL = 0
+	options.GCInterval = 5 * time.Millisecond
+	m := startManager(t, options)
+
+	m.Publish(NewEvent(EventSystem, "idle", "", SystemPayload{Text: "hello"}))
+	waitRooms(t, m, 1)
+	time.Sleep(50 * time.Millisecond)
+	waitRooms(t, m, 1)
+}
+
+func TestRoomLoaderRunsOffManagerGoroutine(t *testing.T) {
+	release := make(chan struct{})
+	options := DefaultManagerOptions()
+	options.RoomLoader = func(roomid string) (RoomOptions, bool) {
+		<-release
+		return RoomOptions{BufferSize: 10, MaxSubscribers: 1}, true
+	}
+	m := startManager(t, options)
+
+	// OpenListener attend le chargement de la room
+	opened := make(chan chan interface{})
+	go func() { opened <- m.OpenListener("general") }()
+	time.Sleep(20 * time.Millisecond)
+	// Le chargement est en cours : le manager répond toujours
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Ping(ctx); err != nil {
+		t.Fatalf("manager blocked by the loader: %v", err)
+	}
+	if m.Stats().Listeners != 0 {
+		t.Fatal("listener registered before the room was loaded")
+	}
+
+	close(release)
+	first := <-opened
+	waitListeners(t, m, 1)
+
+	// MaxSubscribers vient du loader
+	second := m.OpenListener("general")
+	select {
+	case _, ok := <-second:
+		if ok {
+			t.Error("second listener received an event instead of being closed")
+		}
+	case <-time.After(time.Second):
+		t.Error("second listener of a room limited to 1 was not closed")
+	}
+	m.CloseListener("general", first)
+	waitListeners(t, m, 0)
+}
+
+func TestUserRoomsNotLoaded(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.RoomLoader = func(roomid string) (RoomOptions, bool) {
+		t.Errorf("room %s loaded", roomid)
+		return RoomOptions{}, false
+	}
+	m := startManager(t, options)
+
+	m.OpenListener(UserRoomId("1"))
+	waitListeners(t, m, 1)
+}
+
+func TestListenerSizedFromItsRoom(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.BufferSize = 1
+	options.Room.HistoryDepth = 1
+	options.RoomLoader = func(roomid string) (RoomOptions, bool) {
+		return RoomOptions{BufferSize: 20, HistoryDepth: 20, SlowConsumerPolicy: broadcast.Drop}, roomid == "deep"
+	}
+	m := startManager(t, options)
+
+	for i := 0; i < 20; i++ {
+		if err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "deep", Text: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Tout l'historique de la room tient dans la channel, le lecteur ne commence qu'après
+	listener := m.OpenListener("deep")
+	if cap(listener) != 40 {
+		t.Fatalf("listener of capacity %d, want 40 from the options of the room", cap(listener))
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		if got := messageText(t, nextEvent(t, listener)); got != strconv.Itoa(i) {
+			t.Fatalf("replayed %q, want %d", got, i)
+		}
+	}
+	if other := m.OpenListener("other"); cap(other) != 2 {
+		t.Errorf("listener of an unknown room of capacity %d, want the global 2", cap(other))
+	}
+}
+
+func TestMessagesWaitForRoomOptions(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.RoomLoader = func(roomid string) (RoomOptions, bool) {
+		return RoomOptions{BufferSize: 10, MaxMessageSize: 5}, roomid == "small"
+	}
+	m := startManager(t, options)
+
+	err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "small", Text: "too long"})
+	if err != ErrMessageTooLarge {
+		t.Errorf("SubmitMessage in a persisted room = %v, want ErrMessageTooLarge", err)
+	}
+	err = m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "other", Text: "too long"})
+	if err != nil {
+		t.Errorf("SubmitMessage in an unknown room = %v, want the global options", err)
+	}
+}
+
+func TestMessagesLimitedByDefault(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+
+	err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "general", Text: strings.Repeat("a", 4097)})
+	if err != ErrMessageTooLarge {
+		t.Errorf("SubmitMessage of 4097 bytes = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+
+	observer := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	firstTab := m.OpenUserListener("general", "1", "")
+	secondTab := m.OpenUserListener("general", "1", "")
+	waitListeners(t, m, 3)
+
+	// Un seul presence.joined pour deux onglets
+	if event := nextEvent(t, observer); event.Type != EventJoined || event.Actor != "1" {
+		t.Fatalf("got %s from %s, want presence.joined from 1", event.Type, event.Actor)
+	}
+	noEvent(t, observer)
+	if users := m.Presence("general"); len(users) != 1 || users[0] != "1" {
+		t.Errorf("Presence = %v, want [1]", users)
+	}
+
+	m.CloseListener("general", firstTab)
+	noEvent(t, observer)
+	m.CloseListener("general", secondTab)
+	if event := nextEvent(t, observer); event.Type != EventLeft || event.Actor != "1" {
+		t.Fatalf("got %s from %s, want presence.left from 1", event.Type, event.Actor)
+	}
+	if users := m.Presence("general"); len(users) != 0 {
+		t.Errorf("Presence = %v after the user left", users)
+	}
+}
+
+func TestOverloadedRoomRefusesMessages(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.Room.BufferSize = 1
+	options.Room.SlowConsumerPolicy = broadcast.Block
+	m := startManager(t, options)
+
+	// Le listener ne lit rien : le broadcaster se bloque et son buffer se remplit
+	m.OpenListener("general")
+	waitListeners(t, m, 1)
+	for i := 0; i < 10; i++ {
+		err := m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "general", Text: "hello"})
+		if err == ErrRoomOverloaded {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Error("every message was accepted by a full room")
+}
+
+// editStore saves nothing, it records the edits and answers the newly mentioned users
+type editStore struct {
+	edited         []*Message
+	newlyMentioned []string
+}
+
+func (s *editStore) CreateMessage(message *Message) error {
+	return nil
+}
+
+func (s *editStore) UpdateMessage(message *Message) ([]string, error) {
+	s.edited = append(s.edited, message)
+	return s.newlyMentioned, nil
+}
+
+// flagStore records the messages flagged by the filters
+type flagStore struct {
+	flagged map[string][]string
+}
+
+func (s *flagStore) FlagMessage(message *Message, reasons []string) error {
+	s.flagged[message.Id] = reasons
+	return nil
+}
+
+func TestEditsGoThroughTheChecksOfMessages(t *testing.T) {
+	store := &editStore{}
+	options := DefaultManagerOptions()
+	options.Room.MaxMessageSize = 20
+	options.MessageStore = store
+	options.Filters = []MessageFilter{
+		NewProfanityFilter([]string{"darn"}, FilterRewrite),
+		filterFunc(func(message *Message) FilterResult {
+			if strings.Contains(message.Text, "spam") {
+				return FilterResult{Action: FilterReject, Reason: "spam"}
+			}
+			return FilterResult{}
+		}),
+	}
+	m := startManager(t, options)
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+	moderated(t, m, listener, &Moderation{Action: model.ModerationMute, RoomId: "general", ModeratorId: "1", UserId: "3"})
+	moderated(t, m, listener, &Moderation{Action: model.ModerationSlowMode, RoomId: "general", ModeratorId: "1", SlowMode: time.Minute})
+
+	edit := func(userid, text string) (*Message, error) {
+		message := &Message{Id: "7", UserId: userid, RoomId: "general", Text: text}
+		return message, m.EditMessage(context.Background(), message)
+	}
+	if _, err := edit("2", "buy spam"); !errors.Is(err, ErrMessageRejected) {
+		t.Errorf("edit into spam: %v, want a rejection", err)
+	}
+	if _, err := edit("2", strings.Repeat("a", 21)); err != ErrMessageTooLarge {
+		t.Errorf("edit too large: %v", err)
+	}
+	if _, err := edit("3", "hello"); err != ErrMuted {
+		t.Errorf("edit by a muted user: %v", err)
+	}
+	if len(store.edited) != 0 {
+		t.Fatalf("%d refused edits saved", len(store.edited))
+	}
+
+	// La slow mode ne s'applique pas aux corrections
+	for _, text := range []string{"darn typo", "fixed"} {
+		message, err := edit("2", text)
+		if err != nil {
+			t.Fatalf("edit %q: %v", text, err)
+		}
+		if message.Content == nil {
+			t.Error("the edited text was not parsed")
+		}
+	}
+	if len(store.edited) != 2 || store.edited[0].Text != "**** typo" {
+		t.Errorf("saved %+v, want the rewritten text", store.edited)
+	}
+}
+
+// failingStore fails to save the messages while fail is set
+type failingStore struct {
+	editStore
+	fail bool
+}
+
+func (s *failingStore) CreateMessage(message *Message) error {
+	if s.fail {
+		return errors.New("database is down")
+	}
+	return nil
+}
+
+func TestDuplicatesComparedWithSentMessagesOnly(t *testing.T) {
+	store := &failingStore{fail: true}
+	options := DefaultManagerOptions()
+	options.MessageStore = store
+	options.Filters = []MessageFilter{
+		NewDuplicateFilter(time.Minute),
+		filterFunc(func(message *Message) FilterResult {
+			if strings.Contains(message.Text, "spam") {
+				return FilterResult{Action: FilterReject, Reason: "spam"}
+			}
+			return FilterResult{}
+		}),
+	}
+	m := startManager(t, options)
+	submit := func(text string) error {
+		return m.SubmitMessage(context.Background(), &Message{UserId: "1", RoomId: "general", Text: text})
+	}
+
+	// Ni le message perdu par le store, ni celui rejeté par un filtre suivant ne sont retenus
+	if err := submit("hello"); err == nil {
+		t.Fatal("message saved by a failing store")
+	}
+	store.fail = false
+	if err := submit("hello"); err != nil {
+		t.Errorf("message sent again after a failed save: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := submit("buy spam"); err == nil || err.Error() != "message rejected: spam" {
+			t.Errorf("spam %d: error %v, want the spam rejection", i, err)
+		}
+	}
+	if err := submit("hello"); !errors.Is(err, ErrMessageRejected) {
+		t.Errorf("repeated message: error %v, want a rejection", err)
+	}
+
+	// Une édition n'est pas comparée au dernier message, ni retenue
+	if err := m.EditMessage(context.Background(), &Message{Id: "7", UserId: "1", RoomId: "general", Text: "hello"}); err != nil {
+		t.Errorf("edit with the text of the last message: %v", err)
+	}
+	if err := m.EditMessage(context.Background(), &Message{Id: "7", UserId: "1", RoomId: "general", Text: "bye"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := submit("bye"); err != nil {
+		t.Errorf("message with the text of an edit: %v", err)
+	}
+}
+
+func TestEditsNotifyNewMentionsAndQueueFlags(t *testing.T) {
+	store := &editStore{newlyMentioned: []string{"3"}}
+	flags := &flagStore{flagged: map[string][]string{}}
+	options := DefaultManagerOptions()
+	options.MessageStore = store
+	options.FlaggedMessageStore = flags
+	options.MentionResolver = mapResolver{"bob": "2", "carol": "3"}
+	options.Filters = []MessageFilter{NewProfanityFilter([]string{"darn"}, FilterFlag)}
+	m := startManager(t, options)
+
+	bob := m.OpenListener(UserRoomId("2"))
+	carol := m.OpenListener(UserRoomId("3"))
+	waitListeners(t, m, 2)
+
+	message := &Message{Id: "7", UserId: "1", RoomId: "general", Text: "darn @bob @carol"}
+	if err := m.EditMessage(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if len(message.Mentions) != 2 {
+		t.Errorf("mentions %+v, want bob and carol", message.Mentions)
+	}
+	// Seule carol, nouvellement mentionnée, est prévenue
+	if event := nextEvent(t, carol); event.Type != EventMention {
+		t.Errorf("got %s, want a mention", event.Type)
+	}
+	noEvent(t, bob)
+	if !reflect.DeepEqual(flags.flagged["7"], []string{"inappropriate language"}) {
+		t.Errorf("flagged %v", flags.flagged)
+	}
+}
+
+func TestPing(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+	if err := m.Ping(context.Background()); err != nil {
+		t.Errorf("running manager: %v", err)
+	}
+
+	// Sans sa goroutine, le manager ne répond pas
+	stopped := newManager(DefaultManagerOptions())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := stopped.Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("stopped manager: error %v, want %v", err, context.DeadlineExceeded)
+	}
+}
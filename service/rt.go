@@ -1,15 +1,18 @@
 package service
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/kjk/betterguid"
 	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/tracing"
 	"gorm.io/gorm"
 )
 
 type RTService struct {
 	db *gorm.DB
+	// Contexte de la requête en cours, pour les spans
+	ctx context.Context
 }
 
 func NewRTService(db *gorm.DB) *RTService {
@@ -18,6 +21,24 @@ func NewRTService(db *gorm.DB) *RTService {
 	}
 }
 
+/*
+WithContext returns a copy of the service running its queries with the context,
+so that they are traced under the span of the request.
+*/
+func (rt *RTService) WithContext(ctx context.Context) *RTService {
+	return &RTService{
+		db:  rt.db,
+		ctx: ctx,
+	}
+}
+
+func (rt *RTService) context() context.Context {
+	if rt.ctx == nil {
+		return context.Background()
+	}
+	return rt.ctx
+}
+
 /*
 CreateRT creates a new refresh token with the provided IP address and user ID.
 
@@ -30,6 +51,10 @@ Returns:
   - (error): An error if one occurred during database save.
 */
 func (rt *RTService) CreateRT(ip string, userId int) (*model.RefreshToken, error) {
+	ctx, span := tracing.Child(rt.context(), "RTService.CreateRT", "user.id", userId)
+	defer span.End()
+	db := rt.db.WithContext(ctx)
+
 	hash := betterguid.New()
 
 	token := &model.RefreshToken{
@@ -38,14 +63,16 @@ func (rt *RTService) CreateRT(ip string, userId int) (*model.RefreshToken, error
 		UserId: userId,
 	}
 
-	err := rt.db.Save(token).Error
+	err := db.Save(token).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
 	var previousTokens []model.RefreshToken
-	err = rt.db.Where("ip = ? AND user_id = ? AND NOT hash = ?", ip, userId, hash).Delete(previousTokens).Error
+	err = db.Where("ip = ? AND user_id = ? AND NOT hash = ?", ip, userId, hash).Delete(previousTokens).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -53,10 +80,13 @@ func (rt *RTService) CreateRT(ip string, userId int) (*model.RefreshToken, error
 }
 
 func (rt *RTService) GetRT(hash string) (*model.RefreshToken, error) {
+	ctx, span := tracing.Child(rt.context(), "RTService.GetRT")
+	defer span.End()
+
 	var token model.RefreshToken
-	err := rt.db.Where("hash = ?", hash).Preload("User").First(&token).Error
-	fmt.Println(token, err)
+	err := rt.db.WithContext(ctx).Where("hash = ?", hash).Preload("User").First(&token).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
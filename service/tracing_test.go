@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/riri95500/go-chat/tracing"
+)
+
+// spanRecorder is a tracing.Exporter keeping the spans in memory
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (r *spanRecorder) Export(ctx context.Context, spans []*tracing.Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestSubmissionAndFanOutTraced(t *testing.T) {
+	spans := &spanRecorder{}
+	tracer := tracing.NewTracer("test", 1, spans, slog.Default())
+	tracing.SetGlobal(tracer)
+	defer tracing.SetGlobal(tracing.NewTracer("", 0, nil, nil))
+
+	m := startManager(t, DefaultManagerOptions())
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	ctx, request := tracing.Start(context.Background(), "POST /rooms/:roomid", tracing.KindServer)
+	if err := m.SubmitMessage(ctx, &Message{UserId: "1", RoomId: "general", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	nextEvent(t, listener)
+	// L'inscription d'un listener attend la fin de la diffusion en cours, et donc son span
+	m.OpenListener("general")
+	waitListeners(t, m, 2)
+	request.End()
+	tracer.Shutdown(context.Background())
+
+	byName := map[string]*tracing.Span{}
+	for _, span := range spans.spans {
+		byName[span.Name] = span
+	}
+	submit := byName["manager.SubmitMessage"]
+	if submit == nil || submit.Parent != request.Context.SpanID {
+		t.Fatalf("submission traced as %+v, want a child of the request", submit)
+	}
+	for _, name := range []string{"manager.admit", "manager.filter", "broadcast.fanout"} {
+		if span := byName[name]; span == nil || span.Parent != submit.Context.SpanID {
+			t.Errorf("%s traced as %+v, want a child of the submission", name, span)
+		}
+	}
+	if fanout := byName["broadcast.fanout"]; fanout != nil && fanout.Attributes["listeners"] != 1 {
+		t.Errorf("fan-out to %v listeners, want 1", fanout.Attributes["listeners"])
+	}
+}
@@ -0,0 +1,90 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func typingOf(t *testing.T, event *Event) bool {
+	t.Helper()
+	payload, ok := event.Payload.(TypingPayload)
+	if event.Type != EventTyping || !ok {
+		t.Fatalf("got %s, want a typing event", event.Type)
+	}
+	return payload.Typing
+}
+
+func TestTypingBroadcastAndThrottled(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.TypingThrottle = time.Hour
+	m := startManager(t, options)
+
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	m.Typing("1", "general", true)
+	if !typingOf(t, nextEvent(t, listener)) {
+		t.Error("first signal does not start typing")
+	}
+	// Déjà en train d'écrire : rien n'est rediffusé
+	m.Typing("1", "general", true)
+	noEvent(t, listener)
+
+	m.Typing("1", "general", false)
+	if typingOf(t, nextEvent(t, listener)) {
+		t.Error("stop signal does not stop typing")
+	}
+	// Relancé avant la fin du throttle : pas de nouvelle diffusion
+	m.Typing("1", "general", true)
+	noEvent(t, listener)
+}
+
+func TestTypingStoppedByMessage(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	m.Typing("1", "general", true)
+	if !typingOf(t, nextEvent(t, listener)) {
+		t.Fatal("signal does not start typing")
+	}
+	m.Submit("1", "general", "hello")
+	if typingOf(t, nextEvent(t, listener)) {
+		t.Error("sending a message does not stop typing")
+	}
+	if text := messageText(t, nextEvent(t, listener)); text != "hello" {
+		t.Errorf("got message %q", text)
+	}
+}
+
+func TestTypingExpires(t *testing.T) {
+	options := DefaultManagerOptions()
+	options.TypingTTL = 10 * time.Millisecond
+	m := startManager(t, options)
+
+	listener := m.OpenListener("general")
+	waitListeners(t, m, 1)
+
+	m.Typing("1", "general", true)
+	if !typingOf(t, nextEvent(t, listener)) {
+		t.Fatal("signal does not start typing")
+	}
+	// Les indicateurs expirés sont arrêtés au tick suivant, une fois par seconde
+	select {
+	case m := <-listener:
+		if typingOf(t, m.(*Event)) {
+			t.Error("expired indicator is not stopped")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("typing indicator never expired")
+	}
+}
+
+func TestTypingIgnoredInUnknownRoom(t *testing.T) {
+	m := startManager(t, DefaultManagerOptions())
+
+	m.Typing("1", "nowhere", true)
+	time.Sleep(20 * time.Millisecond)
+	waitRooms(t, m, 0)
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/riri95500/go-chat/config"
+	"github.com/riri95500/go-chat/markdown"
+	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/unfurl"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LinkUnfurler previews the links of the saved messages, without delaying their broadcast
+type LinkUnfurler interface {
+	Unfurl(message *Message)
+}
+
+type UnfurlOptions struct {
+	// Number of goroutines fetching pages
+	Workers int
+	// Messages waiting to be unfurled, the ones submitted while the queue is full are not unfurled
+	QueueSize int
+	// Previews, failed ones included, are fetched again after this long
+	CacheTTL time.Duration
+	// Maximum number of links previewed per message
+	MaxLinks int
+}
+
+/*
+NewUnfurlOptions builds the UnfurlOptions from the application Config: 2 workers,
+a queue of 100 messages, previews cached for 24 hours and at most 3 links per message by default.
+*/
+func NewUnfurlOptions(conf *config.Config) UnfurlOptions {
+	options := UnfurlOptions{
+		Workers:   2,
+		QueueSize: 100,
+		CacheTTL:  24 * time.Hour,
+		MaxLinks:  3,
+	}
+	if conf.UNFURL_WORKERS > 0 {
+		options.Workers = conf.UNFURL_WORKERS
+	}
+	if conf.UNFURL_CACHE_TTL > 0 {
+		options.CacheTTL = conf.UNFURL_CACHE_TTL
+	}
+
+	return options
+}
+
+type unfurlJob struct {
+	roomid    string
+	messageId uint
+	links     []string
+}
+
+/*
+UnfurlWorker is the LinkUnfurler fetching the previews in the background. The previews
+are cached in the database, attached to the message, then announced to the room with a
+message.unfurled event.
+*/
+type UnfurlWorker struct {
+	db      *gorm.DB
+	fetcher *unfurl.Fetcher
+	options UnfurlOptions
+	jobs    chan *unfurlJob
+	publish func(*Event)
+	logger  *slog.Logger
+}
+
+func NewUnfurlWorker(db *gorm.DB, fetcher *unfurl.Fetcher, options UnfurlOptions, logger *slog.Logger) *UnfurlWorker {
+	return &UnfurlWorker{
+		db:      db,
+		fetcher: fetcher,
+		options: options,
+		logger:  logger,
+		jobs:    make(chan *unfurlJob, options.QueueSize),
+	}
+}
+
+// Start starts the workers, the events are published through the manager
+func (w *UnfurlWorker) Start(manager Manager) {
+	w.publish = manager.Publish
+	for i := 0; i < w.options.Workers; i++ {
+		go w.run()
+	}
+}
+
+// Unfurl queues the links of a saved message, it never blocks
+func (w *UnfurlWorker) Unfurl(message *Message) {
+	messageId, err := strconv.Atoi(message.Id)
+	if err != nil {
+		return
+	}
+
+	links := markdown.Links(message.Content)
+	if len(links) == 0 {
+		return
+	}
+	if len(links) > w.options.MaxLinks {
+		links = links[:w.options.MaxLinks]
+	}
+
+	select {
+	case w.jobs <- &unfurlJob{roomid: message.RoomId, messageId: uint(messageId), links: links}:
+	default:
+		w.logger.Warn("unfurl queue full, message not unfurled", "room", message.RoomId, "message", message.Id)
+	}
+}
+
+func (w *UnfurlWorker) run() {
+	for job := range w.jobs {
+		w.process(job)
+	}
+}
+
+func (w *UnfurlWorker) process(job *unfurlJob) {
+	previews := []model.LinkPreview{}
+	for _, link := range job.links {
+		preview, err := w.preview(context.Background(), link)
+		if err != nil {
+			w.logger.Error("unfurl cache failed", "link", link, "error", err)
+			continue
+		}
+		if !preview.Failed {
+			previews = append(previews, *preview)
+		}
+	}
+	if len(previews) == 0 {
+		return
+	}
+
+	err := w.db.Model(&model.Message{Model: gorm.Model{ID: job.messageId}}).Association("Previews").Append(previews)
+	if err != nil {
+		w.logger.Error("unfurl previews not saved", "message", job.messageId, "error", err)
+		return
+	}
+
+	payload := MessageUnfurledPayload{
+		MessageId: strconv.Itoa(int(job.messageId)),
+	}
+	for _, preview := range previews {
+		payload.Previews = append(payload.Previews, unfurl.Preview{
+			URL:         preview.URL,
+			Title:       preview.Title,
+			Description: preview.Description,
+			Image:       preview.Image,
+			SiteName:    preview.SiteName,
+		})
+	}
+	w.publish(NewEvent(EventMessageUnfurled, job.roomid, "", payload))
+}
+
+/*
+preview returns the preview of the link from the cache, fetching it when it is missing or expired.
+A failed fetch is cached too, the returned preview then has Failed set.
+*/
+func (w *UnfurlWorker) preview(ctx context.Context, link string) (*model.LinkPreview, error) {
+	sum := sha256.Sum256([]byte(link))
+	hash := hex.EncodeToString(sum[:])
+
+	var cached model.LinkPreview
+	err := w.db.Where("url_hash = ?", hash).First(&cached).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	found := err == nil
+	if found && time.Since(cached.UpdatedAt) < w.options.CacheTTL {
+		return &cached, nil
+	}
+
+	fetched, err := w.fetcher.Fetch(ctx, link)
+	if err != nil {
+		w.logger.Debug("unfurl failed", "link", link, "error", err)
+		fetched = &unfurl.Preview{}
+	}
+
+	cached.URLHash = hash
+	cached.URL = link
+	cached.Title = fetched.Title
+	cached.Description = fetched.Description
+	cached.Image = fetched.Image
+	cached.SiteName = fetched.SiteName
+	cached.Failed = err != nil
+
+	if found {
+		return &cached, w.db.Save(&cached).Error
+	}
+
+	// Un autre worker a pu enregistrer le même lien entre temps
+	err = w.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&cached).Error
+	if err != nil {
+		return nil, err
+	}
+	if cached.ID == 0 {
+		err = w.db.Where("url_hash = ?", hash).First(&cached).Error
+	}
+
+	return &cached, err
+}
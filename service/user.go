@@ -1,12 +1,18 @@
 package service
 
 import (
+	"context"
+	"strconv"
+
 	"github.com/riri95500/go-chat/model"
+	"github.com/riri95500/go-chat/tracing"
 	"gorm.io/gorm"
 )
 
 type UserService struct {
 	db *gorm.DB
+	// Contexte de la requête en cours, pour les spans
+	ctx context.Context
 }
 
 /*
@@ -27,6 +33,24 @@ func NewUserService(db *gorm.DB) *UserService {
 	}
 }
 
+/*
+WithContext returns a copy of the service running its queries with the context,
+so that they are traced under the span of the request.
+*/
+func (s *UserService) WithContext(ctx context.Context) *UserService {
+	return &UserService{
+		db:  s.db,
+		ctx: ctx,
+	}
+}
+
+func (s *UserService) context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
 /*
 GetUser retrieves a user by ID from the database.
 
@@ -41,9 +65,13 @@ Return values:
 	error - if any error occurs while retrieving the user, it is returned here
 */
 func (s *UserService) GetUser(id int) (*model.User, error) {
+	ctx, span := tracing.Child(s.context(), "UserService.GetUser", "user.id", id)
+	defer span.End()
+
 	var user model.User
-	err := s.db.First(&user, id).Error
+	err := s.db.WithContext(ctx).First(&user, id).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -59,9 +87,43 @@ Returns:
   - error: An error object if the query fails.
 */
 func (s *UserService) GetUsers() ([]*model.User, error) {
+	ctx, span := tracing.Child(s.context(), "UserService.GetUsers")
+	defer span.End()
+
 	var users []*model.User
-	err := s.db.Find(&users).Error
+	err := s.db.WithContext(ctx).Find(&users).Error
 	if err != nil {
+		span.RecordError(err)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+/*
+GetUsersByIds retrieves the users matching the given IDs, unknown IDs are ignored.
+
+Parameters:
+
+	ids - the IDs of the users to retrieve
+
+Return values:
+
+	[]*model.User - the users found
+	error - if any error occurs while retrieving the users, it is returned here
+*/
+func (s *UserService) GetUsersByIds(ids []int) ([]*model.User, error) {
+	users := []*model.User{}
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	ctx, span := tracing.Child(s.context(), "UserService.GetUsersByIds", "user.count", len(ids))
+	defer span.End()
+
+	err := s.db.WithContext(ctx).Find(&users, ids).Error
+	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -88,9 +150,13 @@ u, err := userService.GetUserByEmail("alice@example.com")
 fmt.Printf("Retrieved user: %#v\n", u)
 */
 func (s *UserService) GetUserByEmail(email string) (*model.User, error) {
+	ctx, span := tracing.Child(s.context(), "UserService.GetUserByEmail")
+	defer span.End()
+
 	var user model.User
-	err := s.db.Where("email = ?", email).First(&user).Error
+	err := s.db.WithContext(ctx).Where("email = ?", email).First(&user).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -115,8 +181,17 @@ func (s *UserService) CreateUser(data *model.UserCreateDTO) (*model.User, error)
 		Email:    data.Email,
 		Password: data.Password,
 	}
-	err := s.db.Save(&user).Error
+	if data.Username != "" {
+		user.Username = &data.Username
+	}
+
+	// Le hash du mot de passe se fait dans BeforeCreate, il compte dans ce span
+	ctx, span := tracing.Child(s.context(), "UserService.CreateUser")
+	defer span.End()
+
+	err := s.db.WithContext(ctx).Save(&user).Error
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
 
@@ -124,7 +199,12 @@ func (s *UserService) CreateUser(data *model.UserCreateDTO) (*model.User, error)
 }
 
 func (s *UserService) DeleteUser(id int) error {
-	return s.db.Delete(&model.User{}, id).Error
+	ctx, span := tracing.Child(s.context(), "UserService.DeleteUser", "user.id", id)
+	defer span.End()
+
+	err := s.db.WithContext(ctx).Delete(&model.User{}, id).Error
+	span.RecordError(err)
+	return err
 }
 
 /*
@@ -137,20 +217,101 @@ Parameters:
 
 Returns:
 
+  - *model.User: the updated User
+  - []string: the names of the fields whose value changed, nothing is saved when it is empty
   - error: if any error occurred during the update
 */
-func (s *UserService) UpdateUser(id int, data *model.UserUpdateDTO) (*model.User, error) {
-	user, err := s.GetUser(id)
+func (s *UserService) UpdateUser(id int, data *model.UserUpdateDTO) (*model.User, []string, error) {
+	ctx, span := tracing.Child(s.context(), "UserService.UpdateUser", "user.id", id)
+	defer span.End()
+
+	user, err := s.WithContext(ctx).GetUser(id)
 	if err != nil {
-		return nil, err
+		span.RecordError(err)
+		return nil, nil, err
+	}
+
+	changed := []string{}
+	if data.Email != user.Email {
+		user.Email = data.Email
+		changed = append(changed, "email")
+	}
+	if data.Username != "" && (user.Username == nil || *user.Username != data.Username) {
+		user.Username = &data.Username
+		changed = append(changed, "username")
+	}
+	if len(changed) == 0 {
+		return user, changed, nil
 	}
 
-	user.Email = data.Email
+	err = s.db.WithContext(ctx).Save(&user).Error
+	if err != nil {
+		span.RecordError(err)
+		return nil, nil, err
+	}
+
+	return user, changed, nil
+}
+
+/*
+ResolveMentions finds the users matching the emails or usernames mentioned in a message.
+It makes UserService usable as the MentionResolver of the room manager.
+
+Parameters:
+  - names ([]string): the emails and usernames, without the @ prefix
+
+Returns:
+  - (map[string]string): the ID of the user of every name that matched a user
+  - (error): a database error
+*/
+func (s *UserService) ResolveMentions(names []string) (map[string]string, error) {
+	resolved := map[string]string{}
+	if len(names) == 0 {
+		return resolved, nil
+	}
 
-	err = s.db.Save(&user).Error
+	var users []*model.User
+	err := s.db.Where("email IN ? OR username IN ?", names, names).Find(&users).Error
 	if err != nil {
 		return nil, err
 	}
 
-	return user, nil
+	for _, user := range users {
+		id := strconv.Itoa(int(user.ID))
+		resolved[user.Email] = id
+		if user.Username != nil {
+			resolved[*user.Username] = id
+		}
+	}
+
+	return resolved, nil
+}
+
+/*
+PromoteAdmins grants the global administrator role to the users with the given
+ids, and revokes it from everyone else. It runs at startup from ADMIN_USER_IDS.
+Ids are used rather than emails, which the users can change.
+
+Parameters:
+  - ids ([]uint): the ids of the administrators
+
+Returns:
+  - (error): a database error
+*/
+func (s *UserService) PromoteAdmins(ids []uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		revoke := tx.Model(&model.User{}).Where("admin = ?", true)
+		if len(ids) > 0 {
+			revoke = revoke.Where("id NOT IN ?", ids)
+		}
+		err := revoke.Update("admin", false).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		return tx.Model(&model.User{}).Where("id IN ?", ids).Update("admin", true).Error
+	})
 }
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "general/1.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	content, err := store.Get(ctx, "general/1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content)
+	content.Close()
+	if string(data) != "hello" {
+		t.Errorf("got %q", data)
+	}
+
+	if err := store.Delete(ctx, "general/1.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(ctx, "general/1.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a deleted blob: error %v, want ErrNotFound", err)
+	}
+	if err := store.Delete(ctx, "general/1.txt"); err != nil {
+		t.Errorf("Delete of a missing blob: %v", err)
+	}
+}
+
+func TestLocalStoreLeavesNoPartialFile(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.Put(context.Background(), "short.txt", strings.NewReader("hel"), 5, "text/plain")
+	if err == nil {
+		t.Fatal("a truncated upload was accepted")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("files left after a failed upload: %v", entries)
+	}
+}
+
+func TestLocalStoreRefusesKeysOutsideItsDirectory(t *testing.T) {
+	parent := t.TempDir()
+	store, err := NewLocalStore(filepath.Join(parent, "blobs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, key := range []string{"", "..", "../escape.txt", "a/../../escape.txt", "/etc/passwd"} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, "text/plain"); err == nil {
+			t.Errorf("Put(%q) accepted", key)
+		}
+		if _, err := store.Get(ctx, key); err == nil {
+			t.Errorf("Get(%q) accepted", key)
+		}
+		if err := store.Delete(ctx, key); err == nil {
+			t.Errorf("Delete(%q) accepted", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(parent, "escape.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Error("a file was written outside the store")
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/riri95500/go-chat/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps the content of the attachments
+type BlobStore interface {
+	// Put stores size bytes read from r under key
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the content stored under key, ErrNotFound if there is none
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the content stored under key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+/*
+NewBlobStore creates the BlobStore chosen by ATTACHMENT_STORE: "local" (the default)
+stores the files in ATTACHMENT_DIR, "s3" stores them in an S3-compatible bucket.
+
+Parameters:
+  - conf (*config.Config): the application configuration
+
+Returns:
+  - (BlobStore): the store
+  - (error): an error if the store is unknown or cannot be created
+*/
+func NewBlobStore(conf *config.Config) (BlobStore, error) {
+	switch conf.ATTACHMENT_STORE {
+	case "", "local":
+		return NewLocalStore(conf.ATTACHMENT_DIR)
+	case "s3":
+		return NewS3Store(S3Options{
+			Endpoint:  conf.S3_ENDPOINT,
+			Region:    conf.S3_REGION,
+			Bucket:    conf.S3_BUCKET,
+			AccessKey: conf.S3_ACCESS_KEY,
+			SecretKey: conf.S3_SECRET_KEY,
+		})
+	default:
+		return nil, fmt.Errorf("unknown attachment store: %q", conf.ATTACHMENT_STORE)
+	}
+}
@@ -0,0 +1,259 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riri95500/go-chat/config"
+)
+
+// DefaultOTLPEndpoint is the OTLP/HTTP endpoint of a collector running next to the server
+const DefaultOTLPEndpoint = "http://localhost:4318"
+
+/*
+New builds the tracer from the Config and makes it the global one.
+
+Parameters:
+  - conf (*config.Config): TRACING_EXPORTER is "none", the default, "stdout" or "otlp",
+    TRACING_OTLP_ENDPOINT the collector, TRACING_SERVICE_NAME the service name, "go-chat"
+    by default, and TRACING_SAMPLE_RATIO the part of the traces exported, all of them by default
+  - logger (*slog.Logger): where export errors are reported
+
+Returns:
+  - (*Tracer): the tracer, to Shutdown before exiting
+  - (error): an error if the exporter is unknown
+*/
+func New(conf *config.Config, logger *slog.Logger) (*Tracer, error) {
+	service := conf.TRACING_SERVICE_NAME
+	if service == "" {
+		service = "go-chat"
+	}
+	ratio := conf.TRACING_SAMPLE_RATIO
+	if ratio <= 0 {
+		ratio = 1
+	}
+
+	var exporter Exporter
+	switch conf.TRACING_EXPORTER {
+	case "", "none":
+	case "stdout":
+		exporter = NewStdoutExporter(os.Stdout)
+	case "otlp":
+		endpoint := conf.TRACING_OTLP_ENDPOINT
+		if endpoint == "" {
+			endpoint = DefaultOTLPEndpoint
+		}
+		exporter = NewOTLPExporter(endpoint, service)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", conf.TRACING_EXPORTER)
+	}
+
+	tracer := NewTracer(service, ratio, exporter, logger)
+	SetGlobal(tracer)
+
+	return tracer, nil
+}
+
+// StdoutExporter writes the spans as JSON lines, for development
+type StdoutExporter struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func NewStdoutExporter(out io.Writer) *StdoutExporter {
+	return &StdoutExporter{
+		out: out,
+	}
+}
+
+type stdoutSpan struct {
+	TraceID    string                 `json:"traceId"`
+	SpanID     string                 `json:"spanId"`
+	ParentID   string                 `json:"parentId,omitempty"`
+	Name       string                 `json:"name"`
+	Start      time.Time              `json:"start"`
+	Duration   string                 `json:"duration"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+}
+
+func (e *StdoutExporter) Export(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	encoder := json.NewEncoder(e.out)
+	for _, span := range spans {
+		line := stdoutSpan{
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Name:       span.Name,
+			Start:      span.StartTime,
+			Duration:   span.EndTime.Sub(span.StartTime).String(),
+			Attributes: span.Attributes,
+			Error:      span.Error,
+		}
+		if span.Parent != (SpanID{}) {
+			line.ParentID = span.Parent.String()
+		}
+		if err := encoder.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+OTLPExporter sends the spans to an OpenTelemetry collector with OTLP over HTTP,
+encoded in JSON, on <endpoint>/v1/traces.
+*/
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+}
+
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		url:     strings.TrimSuffix(endpoint, "/") + "/v1/traces",
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "github.com/riri95500/go-chat"
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              int(span.Kind),
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		}
+		if span.Parent != (SpanID{}) {
+			s.ParentSpanID = span.Parent.String()
+		}
+		for key, value := range span.Attributes {
+			s.Attributes = append(s.Attributes, otlpAttr(key, value))
+		}
+		if span.Error != "" {
+			// 2 : STATUS_CODE_ERROR
+			s.Status = &otlpStatus{Code: 2, Message: span.Error}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpAttribute{otlpAttr("service.name", e.service)}
+
+	body, err := json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{resource}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector answered %s", res.Status)
+	}
+
+	return nil
+}
+
+// otlpAttr converts an attribute, the types OTLP has no value for are sent as strings
+func otlpAttr(key string, value interface{}) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case string:
+		attr.Value.StringValue = &v
+	case bool:
+		attr.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		attr.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		attr.Value.IntValue = &s
+	case uint:
+		s := strconv.FormatUint(uint64(v), 10)
+		attr.Value.IntValue = &s
+	case float64:
+		attr.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		attr.Value.StringValue = &s
+	}
+
+	return attr
+}
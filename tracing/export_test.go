@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riri95500/go-chat/config"
+)
+
+// ended returns a sampled span child of parent, ended a millisecond after it started
+func ended(name string, parent SpanID, kv ...interface{}) *Span {
+	span := &Span{Name: name, Kind: KindClient, Parent: parent, Attributes: map[string]interface{}{}}
+	span.Context = SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	span.StartTime = time.Unix(1700000000, 0)
+	span.EndTime = span.StartTime.Add(time.Millisecond)
+	span.SetAttributes(kv...)
+	return span
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(&config.Config{TRACING_EXPORTER: "jaeger"}, slog.Default()); err == nil {
+		t.Error("unknown exporter accepted")
+	}
+
+	previous := global
+	defer SetGlobal(previous)
+	tracer, err := New(&config.Config{}, slog.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if global != tracer || tracer.service != "go-chat" || tracer.exporter != nil {
+		t.Errorf("tracer %+v, want the global go-chat tracer exporting nothing", tracer)
+	}
+}
+
+func TestStdoutExporter(t *testing.T) {
+	out := &bytes.Buffer{}
+	span := ended("query", newSpanID(), "db.table", "users")
+	span.Error = "failed"
+	if err := NewStdoutExporter(out).Export(context.Background(), []*Span{span, ended("request", SpanID{})}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines written, want one per span", len(lines))
+	}
+	line := stdoutSpan{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line.Name != "query" || line.ParentID != span.Parent.String() || line.Duration != "1ms" || line.Error != "failed" || line.Attributes["db.table"] != "users" {
+		t.Errorf("span written as %+v", line)
+	}
+	if strings.Contains(lines[1], "parentId") {
+		t.Errorf("root written with a parent: %s", lines[1])
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var received otlpRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("posted to %s as %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer server.Close()
+
+	span := ended("query", newSpanID(), "db.rows_affected", int64(2))
+	span.Error = "failed"
+	if err := NewOTLPExporter(server.URL+"/", "chat").Export(context.Background(), []*Span{span}); err != nil {
+		t.Fatal(err)
+	}
+
+	resource := received.ResourceSpans[0]
+	if *resource.Resource.Attributes[0].Value.StringValue != "chat" {
+		t.Errorf("service %+v, want chat", resource.Resource.Attributes)
+	}
+	s := resource.ScopeSpans[0].Spans[0]
+	if s.TraceID != span.Context.TraceID.String() || s.ParentSpanID != span.Parent.String() || s.Kind != int(KindClient) ||
+		s.StartTimeUnixNano != "1700000000000000000" || s.EndTimeUnixNano != "1700000000001000000" {
+		t.Errorf("span sent as %+v", s)
+	}
+	if s.Status == nil || s.Status.Code != 2 || s.Status.Message != "failed" {
+		t.Errorf("status %+v, want the error", s.Status)
+	}
+	if len(s.Attributes) != 1 || *s.Attributes[0].Value.IntValue != "2" {
+		t.Errorf("attributes %+v, want the rows as an integer", s.Attributes)
+	}
+}
+
+func TestOTLPExporterReportsRefusedSpans(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	if err := NewOTLPExporter(server.URL, "chat").Export(context.Background(), []*Span{ended("query", SpanID{})}); err == nil {
+		t.Error("refused export not reported")
+	}
+}
+
+func TestOTLPAttributes(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{"general", `{"key":"k","value":{"stringValue":"general"}}`},
+		{true, `{"key":"k","value":{"boolValue":true}}`},
+		{3, `{"key":"k","value":{"intValue":"3"}}`},
+		{uint(4), `{"key":"k","value":{"intValue":"4"}}`},
+		{1.5, `{"key":"k","value":{"doubleValue":1.5}}`},
+		{time.Second, `{"key":"k","value":{"stringValue":"1s"}}`},
+	}
+	for _, tt := range tests {
+		got, err := json.Marshal(otlpAttr("k", tt.value))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v sent as %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
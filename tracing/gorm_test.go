@@ -0,0 +1,52 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/riri95500/go-chat/internal/fakedb"
+)
+
+type user struct {
+	ID    uint
+	Email string
+}
+
+func TestGormPluginTracesQueries(t *testing.T) {
+	tracer, r := useTracer(t, 1)
+	db, _ := fakedb.Open(t, nil)
+	if err := db.Use(NewGormPlugin()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hors d'une trace, pas de span
+	db.Where("email = ?", "bob@example.com").Find(&[]user{})
+
+	ctx, span := Start(context.Background(), "request", KindServer)
+	db.WithContext(ctx).Where("email = ?", "bob@example.com").First(&user{})
+	db.WithContext(ctx).Create(&user{Email: "carol@example.com"})
+	span.End()
+	tracer.Shutdown(context.Background())
+
+	queries := map[string]*Span{}
+	for _, s := range r.exported() {
+		if strings.HasPrefix(s.Name, "gorm.") {
+			queries[s.Name] = s
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("queries traced: %v, want the query and the create of the request", queries)
+	}
+	query := queries["gorm.query"]
+	if query == nil || query.Parent != span.Context.SpanID || query.Kind != KindClient || query.Error != "" {
+		t.Fatalf("query traced as %+v, want a client span of the request without error", query)
+	}
+	statement, _ := query.Attributes["db.statement"].(string)
+	if !strings.Contains(statement, "email = ?") || strings.Contains(statement, "bob@example.com") {
+		t.Errorf("statement %q, want it without its values", statement)
+	}
+	if query.Attributes["db.table"] != "users" {
+		t.Errorf("table %v, want users", query.Attributes["db.table"])
+	}
+}
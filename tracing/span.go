@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+// SpanKind tells the role of a span, as in OpenTelemetry
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext identifies a span across goroutines and processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Only sampled spans are exported, their children are sampled too
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent formats the span context as a W3C traceparent header
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", sc.TraceID, sc.SpanID, flags)
+}
+
+/*
+ParseTraceParent reads a W3C traceparent header, such as
+"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+ok is false when the header is absent or invalid.
+*/
+func ParseTraceParent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+
+	return sc, sc.IsValid()
+}
+
+/*
+Span is a timed operation of a trace. Its methods can be called on a nil span,
+which is what Child returns outside of a trace, so callers never check for it.
+*/
+type Span struct {
+	tracer     *Tracer
+	Context    SpanContext
+	Parent     SpanID
+	Name       string
+	Kind       SpanKind
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]interface{}
+	// Message of the error that failed the operation, empty when it succeeded
+	Error string
+
+	mu    sync.Mutex
+	ended bool
+}
+
+/*
+SetAttributes adds attributes given as key and value pairs, like slog.
+
+Parameters:
+  - kv (...interface{}): the keys, strings, each followed by its value
+*/
+func (s *Span) SetAttributes(kv ...interface{}) {
+	if s == nil || !s.Context.Sampled {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		s.Attributes[key] = kv[i+1]
+	}
+}
+
+// RecordError marks the span as failed, a nil error is ignored
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Error = err.Error()
+}
+
+// End ends the span and hands it to the exporter, only the first call counts
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if s.EndTime.IsZero() {
+		s.EndTime = time.Now()
+	}
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.tracer.export(s)
+	}
+}
+
+// SpanContext returns the context of the span, the zero SpanContext for a nil span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+type spanKey struct{}
+
+type remoteKey struct{}
+
+// ContextWithSpan returns a copy of the context carrying the span, the parent of the next spans
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns the current span of the context, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// ContextWithRemoteParent returns a copy of the context whose next span continues a trace of another process
+func ContextWithRemoteParent(ctx context.Context, parent SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, parent)
+}
+
+// parentOf returns the span context the next span of the context descends from
+func parentOf(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.Context, true
+	}
+	if parent, ok := ctx.Value(remoteKey{}).(SpanContext); ok && parent.IsValid() {
+		return parent, true
+	}
+	return SpanContext{}, false
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
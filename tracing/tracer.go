@@ -0,0 +1,245 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"log/slog"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// Spans waiting for the exporter, the next ones are dropped
+	queueSize = 2048
+	// Spans sent to the exporter at once
+	batchSize = 512
+	// Delay after which the spans waiting are sent even if the batch is not full
+	batchTimeout = 5 * time.Second
+)
+
+// Exporter sends the ended spans to their backend
+type Exporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+/*
+Tracer creates the spans and exports the sampled ones in batches, in the background.
+A Tracer without exporter creates spans for the trace ids of the logs, and exports nothing.
+*/
+type Tracer struct {
+	service  string
+	ratio    float64
+	exporter Exporter
+	logger   *slog.Logger
+
+	// Protège la fermeture de queue contre les spans qui se terminent pendant Shutdown
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Span
+	done   chan struct{}
+}
+
+var global = &Tracer{}
+
+/*
+NewTracer creates a tracer and starts its export goroutine.
+
+Parameters:
+  - service (string): the name of the service in the exported spans
+  - ratio (float64): the part of the new traces sampled, between 0 and 1, traces
+    started by another service follow its decision
+  - exporter (Exporter): where the spans go, nil to export nothing
+  - logger (*slog.Logger): where export errors are reported
+
+Returns:
+  - (*Tracer): the tracer, to Shutdown before exiting
+*/
+func NewTracer(service string, ratio float64, exporter Exporter, logger *slog.Logger) *Tracer {
+	t := &Tracer{
+		service:  service,
+		ratio:    ratio,
+		exporter: exporter,
+		logger:   logger,
+	}
+	if exporter != nil {
+		t.queue = make(chan *Span, queueSize)
+		t.done = make(chan struct{})
+		go t.run()
+	}
+
+	return t
+}
+
+// SetGlobal makes the tracer the one used by Start and Child
+func SetGlobal(t *Tracer) {
+	global = t
+}
+
+/*
+Start starts a span, child of the current span of the context, or the root of a new trace.
+
+Parameters:
+  - ctx (context.Context): the context of the operation
+  - name (string): the name of the operation
+  - kind (SpanKind): the role of the span
+  - kv (...interface{}): attributes given as key and value pairs
+
+Returns:
+  - (context.Context): a copy of ctx carrying the span, for the operations it contains
+  - (*Span): the span, to End
+*/
+func Start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	return global.start(ctx, name, kind, kv...)
+}
+
+/*
+Child starts a span only inside a trace, so that the work done outside of a request,
+such as the queries of background workers, does not make traces of its own.
+It returns ctx unchanged and a nil span when the context has no span.
+*/
+func Child(ctx context.Context, name string, kv ...interface{}) (context.Context, *Span) {
+	if _, ok := parentOf(ctx); !ok {
+		return ctx, nil
+	}
+	return global.start(ctx, name, KindInternal, kv...)
+}
+
+/*
+Record exports a span measured elsewhere, for work handed over to another goroutine
+such as the fan-out of a message by a broadcaster.
+
+Parameters:
+  - parent (SpanContext): the span the work was done for, nothing is recorded when it is not sampled
+  - name (string): the name of the operation
+  - start (time.Time), end (time.Time): when the work began and ended
+  - kv (...interface{}): attributes given as key and value pairs
+*/
+func Record(parent SpanContext, name string, start, end time.Time, kv ...interface{}) {
+	if !parent.IsValid() || !parent.Sampled {
+		return
+	}
+
+	span := global.newSpan(parent, true, name, KindInternal)
+	span.StartTime = start
+	span.EndTime = end
+	span.SetAttributes(kv...)
+	span.End()
+}
+
+func (t *Tracer) start(ctx context.Context, name string, kind SpanKind, kv ...interface{}) (context.Context, *Span) {
+	parent, ok := parentOf(ctx)
+	span := t.newSpan(parent, ok, name, kind)
+	span.SetAttributes(kv...)
+
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) newSpan(parent SpanContext, hasParent bool, name string, kind SpanKind) *Span {
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		StartTime:  time.Now(),
+		Attributes: map[string]interface{}{},
+	}
+	span.Context.SpanID = newSpanID()
+	if hasParent {
+		span.Context.TraceID = parent.TraceID
+		span.Context.Sampled = parent.Sampled
+		span.Parent = parent.SpanID
+	} else {
+		span.Context.TraceID = newTraceID()
+		span.Context.Sampled = t.sample(span.Context.TraceID)
+	}
+
+	return span
+}
+
+// sample keeps a part of the traces given by the ratio, decided on the trace id so every service agrees
+func (t *Tracer) sample(id TraceID) bool {
+	if t.exporter == nil || t.ratio <= 0 {
+		return false
+	}
+	if t.ratio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(id[8:]) < uint64(t.ratio*math.MaxUint64)
+}
+
+// export queues an ended span, it is dropped when the queue is full
+func (t *Tracer) export(span *Span) {
+	if t.queue == nil {
+		return
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return
+	}
+	select {
+	case t.queue <- span:
+	default:
+	}
+}
+
+func (t *Tracer) run() {
+	defer close(t.done)
+
+	ticker := time.NewTicker(batchTimeout)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+		defer cancel()
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.logger.Warn("spans not exported", "count", len(batch), "error", err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+
+	for {
+		select {
+		case span, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+/*
+Shutdown exports the spans still queued. Spans ended after Shutdown are lost.
+
+Parameters:
+  - ctx (context.Context): how long to wait for the last export
+*/
+func (t *Tracer) Shutdown(ctx context.Context) {
+	if t.queue == nil {
+		return
+	}
+
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,176 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is an Exporter keeping the spans in memory
+type recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+	err   error
+}
+
+func (r *recorder) Export(ctx context.Context, spans []*Span) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return r.err
+}
+
+func (r *recorder) exported() []*Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Span{}, r.spans...)
+}
+
+// useTracer makes a tracer sampling with ratio the global one for the test, exporting to the recorder
+func useTracer(t *testing.T, ratio float64) (*Tracer, *recorder) {
+	t.Helper()
+	r := &recorder{}
+	tracer := NewTracer("test", ratio, r, slog.Default())
+	previous := global
+	SetGlobal(tracer)
+	t.Cleanup(func() {
+		SetGlobal(previous)
+		tracer.Shutdown(context.Background())
+	})
+	return tracer, r
+}
+
+func TestTraceParent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceParent(header)
+	if !ok || !sc.Sampled || sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Fatalf("parsed %+v, %v", sc, ok)
+	}
+	if sc.TraceParent() != header {
+		t.Errorf("formatted as %s, want %s", sc.TraceParent(), header)
+	}
+
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-zzf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+	} {
+		if _, ok := ParseTraceParent(header); ok {
+			t.Errorf("%q accepted", header)
+		}
+	}
+	if sc, ok := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"); !ok || sc.Sampled {
+		t.Errorf("unsampled parent parsed as %+v, %v", sc, ok)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	tests := []struct {
+		name     string
+		exporter Exporter
+		ratio    float64
+		want     bool
+	}{
+		{"no exporter", nil, 1, false},
+		{"no ratio", &recorder{}, 0, false},
+		{"every trace", &recorder{}, 1, true},
+	}
+	for _, tt := range tests {
+		tracer := NewTracer("test", tt.ratio, tt.exporter, slog.Default())
+		if got := tracer.sample(newTraceID()); got != tt.want {
+			t.Errorf("%s: sampled %v, want %v", tt.name, got, tt.want)
+		}
+		tracer.Shutdown(context.Background())
+	}
+
+	tracer := NewTracer("test", 0.5, &recorder{}, slog.Default())
+	defer tracer.Shutdown(context.Background())
+	var low, high TraceID
+	high[8] = 0xff
+	if !tracer.sample(low) || tracer.sample(high) {
+		t.Error("sampling not decided on the trace id")
+	}
+}
+
+func TestSpansExportedWithTheirParents(t *testing.T) {
+	tracer, r := useTracer(t, 1)
+
+	ctx, root := Start(context.Background(), "request", KindServer, "http.route", "/rooms")
+	_, child := Child(ctx, "query", "db.table", "messages", "ignored")
+	child.RecordError(errors.New("failed"))
+	child.RecordError(nil)
+	child.End()
+	root.End()
+	root.End()
+	Record(root.SpanContext(), "fanout", time.Now().Add(-time.Millisecond), time.Now(), "listeners", 3)
+	tracer.Shutdown(context.Background())
+
+	spans := r.exported()
+	if len(spans) != 3 {
+		t.Fatalf("%d spans exported, want 3", len(spans))
+	}
+	byName := map[string]*Span{}
+	for _, span := range spans {
+		byName[span.Name] = span
+	}
+	if byName["request"].Attributes["http.route"] != "/rooms" || byName["request"].Parent != (SpanID{}) {
+		t.Errorf("root exported as %+v", byName["request"])
+	}
+	for _, name := range []string{"query", "fanout"} {
+		span := byName[name]
+		if span == nil || span.Context.TraceID != root.Context.TraceID || span.Parent != root.Context.SpanID {
+			t.Errorf("%s exported as %+v, want a child of the request", name, span)
+		}
+	}
+	if byName["query"].Error != "failed" || byName["query"].Attributes["db.table"] != "messages" || len(byName["query"].Attributes) != 1 {
+		t.Errorf("query exported as %+v", byName["query"])
+	}
+}
+
+func TestNothingRecordedOutsideOfTraces(t *testing.T) {
+	tracer, r := useTracer(t, 1)
+
+	ctx, span := Child(context.Background(), "query")
+	if span != nil || SpanFromContext(ctx) != nil {
+		t.Error("span started outside of a trace")
+	}
+	// Les méthodes d'un span nil ne font rien
+	span.SetAttributes("key", "value")
+	span.RecordError(errors.New("failed"))
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Error("nil span with a valid context")
+	}
+
+	Record(SpanContext{TraceID: newTraceID(), SpanID: newSpanID()}, "fanout", time.Now(), time.Now())
+	tracer.Shutdown(context.Background())
+	_, late := Start(context.Background(), "after shutdown", KindInternal)
+	late.End()
+
+	if spans := r.exported(); len(spans) != 0 {
+		t.Errorf("%d spans exported, want none", len(spans))
+	}
+}
+
+func TestRemoteParentDecidesSampling(t *testing.T) {
+	tracer, r := useTracer(t, 1)
+
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, span := Start(ContextWithRemoteParent(context.Background(), parent), "request", KindServer)
+	if span.Context.TraceID != parent.TraceID || span.Parent != parent.SpanID || span.Context.Sampled {
+		t.Errorf("span %+v, want an unsampled continuation of the remote trace", span.Context)
+	}
+	span.End()
+	tracer.Shutdown(context.Background())
+
+	if spans := r.exported(); len(spans) != 0 {
+		t.Errorf("%d spans of an unsampled trace exported", len(spans))
+	}
+}
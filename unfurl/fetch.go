@@ -0,0 +1,241 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrForbiddenAddress = errors.New("the address is not allowed")
+	ErrNotHTML          = errors.New("the page is not HTML")
+	ErrNoPreview        = errors.New("the page has no preview metadata")
+)
+
+// Preview is the metadata describing a page
+type Preview struct {
+	URL         string `json:"url"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+	SiteName    string `json:"siteName,omitempty"`
+}
+
+type Options struct {
+	// Maximum duration of a fetch, redirects included
+	Timeout time.Duration
+	// Maximum number of bytes read from a page
+	MaxBodySize int64
+	// Maximum number of redirects followed
+	MaxRedirects int
+	UserAgent    string
+	// Lets the fetcher reach loopback and private addresses, for tests against a local server only
+	AllowPrivate bool
+}
+
+/*
+DefaultOptions fetches at most 512KB of a page in 5 seconds, following up to 3 redirects.
+*/
+func DefaultOptions() Options {
+	return Options{
+		Timeout:      5 * time.Second,
+		MaxBodySize:  512 << 10,
+		MaxRedirects: 3,
+		UserAgent:    "go-chat-unfurl/1.0",
+	}
+}
+
+/*
+Fetcher downloads pages to read their OpenGraph and Twitter card metadata.
+Unless AllowPrivate is set, it refuses to connect to loopback, private, link-local
+and other reserved addresses. The check is made on the resolved address of every
+connection, redirects and DNS rebinding included.
+*/
+type Fetcher struct {
+	client  *http.Client
+	options Options
+	// Refuse une adresse résolue, forbiddenIP sauf dans les tests
+	forbidden func(net.IP) bool
+}
+
+func NewFetcher(options Options) *Fetcher {
+	defaults := DefaultOptions()
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = defaults.MaxBodySize
+	}
+	if options.MaxRedirects <= 0 {
+		options.MaxRedirects = defaults.MaxRedirects
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = defaults.UserAgent
+	}
+
+	f := &Fetcher{
+		options:   options,
+		forbidden: forbiddenIP,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: options.Timeout,
+		Control: f.control,
+	}
+	f.client = &http.Client{
+		Timeout: options.Timeout,
+		Transport: &http.Transport{
+			// Pas de proxy, il contournerait la vérification des adresses
+			Proxy:                  nil,
+			DialContext:            dialer.DialContext,
+			TLSHandshakeTimeout:    options.Timeout,
+			ResponseHeaderTimeout:  options.Timeout,
+			MaxResponseHeaderBytes: 64 << 10,
+			MaxIdleConns:           10,
+			IdleConnTimeout:        30 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > options.MaxRedirects {
+				return errors.New("too many redirects")
+			}
+			return checkURL(req.URL)
+		},
+	}
+
+	return f
+}
+
+// control runs before every connection, once the address is resolved
+func (f *Fetcher) control(network string, address string, c syscall.RawConn) error {
+	if f.options.AllowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || f.forbidden(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+
+	return nil
+}
+
+func checkURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, u.Redacted())
+	}
+
+	return nil
+}
+
+/*
+Fetch downloads the page and returns its preview.
+
+Parameters:
+  - ctx (context.Context): the context of the fetch, the fetch is also limited by Options.Timeout
+  - rawurl (string): the absolute http or https URL of the page
+
+Returns:
+  - (*Preview): the preview, its URL is the one of the page reached after the redirects
+  - (error): ErrForbiddenAddress, ErrNotHTML, ErrNoPreview, or an HTTP error
+*/
+func (f *Fetcher) Fetch(ctx context.Context, rawurl string) (*Preview, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkURL(u); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, f.options.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.options.UserAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unfurl %s: %s", u.Redacted(), res.Status)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNotHTML
+	}
+
+	preview := parse(io.LimitReader(res.Body, f.options.MaxBodySize), res.Request.URL)
+	if preview.Title == "" && preview.Description == "" {
+		return nil, ErrNoPreview
+	}
+
+	return preview, nil
+}
+
+var reservedNetworks = parseNetworks(
+	"0.0.0.0/8",       // "ce réseau"
+	"100.64.0.0/10",   // CGNAT
+	"192.0.0.0/24",    // IETF
+	"192.0.2.0/24",    // documentation
+	"198.18.0.0/15",   // benchmarks
+	"198.51.100.0/24", // documentation
+	"203.0.113.0/24",  // documentation
+	"240.0.0.0/4",     // réservé
+	"64:ff9b::/96",    // NAT64, peut cibler une adresse privée
+	"2001:db8::/32",   // documentation
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+
+	return networks
+}
+
+// forbiddenIP tells if the address is not a public unicast address
+func forbiddenIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package unfurl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		html string
+		want Preview
+	}{
+		{
+			name: "OpenGraph first",
+			html: `<head><title>Title</title><meta name="twitter:title" content="Twitter"><meta property="og:title" content="OpenGraph"><meta name="description" content="Description"><meta property="og:site_name" content="Site"></head>`,
+			want: Preview{Title: "OpenGraph", Description: "Description", SiteName: "Site"},
+		},
+		{
+			name: "Twitter card then title",
+			html: `<head><title> Title </title><meta name="twitter:description" content="Card"><meta name="description" content="Description"></head>`,
+			want: Preview{Title: "Title", Description: "Card"},
+		},
+		{
+			name: "first value wins",
+			html: `<head><meta property="og:title" content="First"><meta property="og:title" content="Second"></head>`,
+			want: Preview{Title: "First"},
+		},
+		{
+			name: "case and spaces",
+			html: `<HEAD><META PROPERTY=" OG:TITLE " CONTENT="  Spaced
+				out  "></HEAD>`,
+			want: Preview{Title: "Spaced out"},
+		},
+		{
+			name: "images resolved",
+			html: `<head><meta property="og:title" content="x"><meta name="twitter:image" content="img/cat.png"></head>`,
+			want: Preview{Title: "x", Image: "https://example.com/blog/img/cat.png"},
+		},
+		{
+			name: "unsafe images dropped",
+			html: `<head><meta property="og:title" content="x"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: Preview{Title: "x"},
+		},
+		{
+			name: "data images dropped",
+			html: `<head><meta property="og:title" content="x"><meta property="og:image" content="data:image/png;base64,AAAA"></head>`,
+			want: Preview{Title: "x"},
+		},
+		{
+			name: "body ignored",
+			html: `<head></head><body><meta property="og:title" content="In the body"><title>Body</title></body>`,
+			want: Preview{},
+		},
+		{
+			name: "markup kept as text",
+			html: `<head><meta property="og:title" content="<script>alert(1)</script>"></head>`,
+			want: Preview{Title: "<script>alert(1)</script>"},
+		},
+	}
+	base := mustParse(t, "https://example.com/blog/post")
+	for _, tt := range tests {
+		got := parse(strings.NewReader(tt.html), base)
+		tt.want.URL = base.String()
+		if *got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, *got, tt.want)
+		}
+	}
+}
+
+func TestParseTruncatesLongValues(t *testing.T) {
+	long := strings.Repeat("é", maxTitleLength+10)
+	got := parse(strings.NewReader(`<head><meta property="og:title" content="`+long+`"></head>`), mustParse(t, "https://example.com"))
+	if runes := []rune(got.Title); len(runes) != maxTitleLength || runes[len(runes)-1] != '…' {
+		t.Errorf("title of %d runes: %q", len(runes), got.Title)
+	}
+}